@@ -12,9 +12,13 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"im/internal/auth"
+	"im/internal/botwebhook"
 	"im/internal/config"
+	"im/internal/playerinfo"
+	"im/internal/scheduler"
 	"im/internal/server"
 	"im/internal/storage"
+	"im/internal/webhook"
 	"im/internal/ws"
 )
 
@@ -42,6 +46,14 @@ func main() {
 
 	accountRepo := storage.NewAccountRepository(mysqlStore.DB)
 	settingsRepo := storage.NewAgencySettingsRepository(mysqlStore.DB)
+	messageRepo := storage.NewMessageRepository(mysqlStore.DB)
+	roomRepo := storage.NewRoomRepository(mysqlStore.DB)
+	agentStatusRepo := storage.NewAgentStatusRepository(mysqlStore.DB)
+	agentProfileRepo := storage.NewAgentProfileRepository(mysqlStore.DB)
+	cannedRepo := storage.NewCannedResponseRepository(mysqlStore.DB)
+	webhookRepo := storage.NewWebhookRepository(mysqlStore.DB)
+	announcementRepo := storage.NewAnnouncementRepository(mysqlStore.DB)
+	scheduledRepo := storage.NewScheduledMessageRepository(mysqlStore.DB)
 	tokenStore := auth.NewRedisTokenStore(redisClient)
 
 	authManager, err := auth.NewManager(accountRepo, tokenStore, cfg.JWT.Secret, cfg.JWT.Issuer, cfg.JWT.Expiry)
@@ -49,8 +61,39 @@ func main() {
 		log.Fatalf("init auth manager failed: %v", err)
 	}
 
-	hub := ws.NewHub()
-	srv := server.New(hub, authManager, settingsRepo, "web")
+	hub := ws.NewHub(
+		ws.WithMessageStore(messageRepo),
+		ws.WithRoomStore(roomRepo),
+		ws.WithAgentStatusStore(agentStatusRepo),
+		ws.WithAgentProfileStore(agentProfileRepo),
+		ws.WithPriorityResolver(playerinfo.NewResolver(settingsRepo)),
+		ws.WithHistoryLimit(cfg.Chat.HistoryLimit),
+		ws.WithInitialHistoryLimit(cfg.Chat.InitialHistory),
+		ws.WithRoomEviction(cfg.Chat.RoomIdleTTL, cfg.Chat.EvictionInterval),
+		ws.WithAutoClose(cfg.Chat.AutoCloseAfter),
+		ws.WithQueueNotices(cfg.Chat.QueueNoticeInterval),
+		ws.WithOfflineMode(cfg.BusinessHours),
+		ws.WithGreetingStore(settingsRepo),
+		ws.WithCannedResponseStore(cannedRepo),
+		ws.WithBotRuleStore(settingsRepo),
+		ws.WithBotDispatcher(botwebhook.NewDispatcher()),
+		ws.WithAnnouncementStore(announcementRepo),
+	)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	hubDone := make(chan struct{})
+	go func() {
+		hub.Run(hubCtx)
+		close(hubDone)
+	}()
+
+	webhooks := webhook.NewDispatcher(webhookRepo)
+	go webhooks.Run(hubCtx, hub)
+
+	schedule := scheduler.NewScheduler(scheduledRepo)
+	go schedule.Run(hubCtx, hub)
+
+	srv := server.New(hub, authManager, settingsRepo, webhooks, schedule, cfg.APIKeys, "web")
 	httpServer := srv.Start(":8080")
 
 	fmt.Println("IM(客服系統) 伺服器已啟動於 http://localhost:8080")
@@ -67,4 +110,7 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		fmt.Printf("關閉服務失敗: %v\n", err)
 	}
+	// Stop the hub and wait for it to save the rooms still in memory.
+	stopHub()
+	<-hubDone
 }
@@ -19,6 +19,10 @@ const (
 	RolePlayer Role = "player"
 )
 
+// MasterAgency is the agency of the bootstrap administrator, whose scope
+// covers every agency.
+const MasterAgency = "master"
+
 var (
 	ErrAccountExists      = errors.New("account already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
@@ -104,7 +108,7 @@ func (m *Manager) ensureBootstrapAdmin() error {
 			Username:    "admin01",
 			DisplayName: "客服主管",
 			Role:        RoleAdmin,
-			Agency:      "master",
+			Agency:      MasterAgency,
 			CreatedBy:   "system",
 		},
 		PasswordHash: passwordHash[:],
@@ -0,0 +1,102 @@
+// Package botwebhook forwards player messages to an agency's external bot,
+// which answers in the webhook response or later through the bot reply API.
+package botwebhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"im/internal/webhook"
+	"im/internal/ws"
+)
+
+// TokenHeader carries the agency's bot token so that the bot can tell the
+// server's requests apart from anyone else's. Requests are also signed like
+// webhook deliveries, with the bot token as the secret, in the
+// webhook.TimestampHeader and webhook.SignatureHeader headers.
+const TokenHeader = "X-Bot-Token"
+
+const (
+	defaultTimeout = 5 * time.Second
+	maxReplySize   = 64 << 10
+)
+
+// Dispatcher implements ws.BotDispatcher with a JSON POST to the webhook URL
+// registered for the agency's bot.
+type Dispatcher struct {
+	client *http.Client
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{client: &http.Client{Timeout: defaultTimeout}}
+}
+
+type messageEvent struct {
+	Event   string         `json:"event"`
+	Agency  string         `json:"agency"`
+	RoomID  string         `json:"roomId"`
+	Room    ws.RoomSummary `json:"room"`
+	Message ws.ChatMessage `json:"message"`
+}
+
+// DispatchBotMessage posts a message.created event for the player message.
+// Any response other than 2xx is an error. A JSON response body of the form
+// {"content": "...", "handoff": false} is returned as the bot's answer; an
+// empty or non-JSON body means the bot answers later, if at all.
+func (d *Dispatcher) DispatchBotMessage(ctx context.Context, bot ws.AgencyBot, room ws.RoomSummary, msg ws.ChatMessage) (ws.BotAnswer, error) {
+	body, err := json.Marshal(messageEvent{
+		Event:   ws.EventMessageCreated,
+		Agency:  bot.Agency,
+		RoomID:  room.RoomID,
+		Room:    room,
+		Message: msg,
+	})
+	if err != nil {
+		return ws.BotAnswer{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, bot.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return ws.BotAnswer{}, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, bot.Token)
+	req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(bot.Token, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return ws.BotAnswer{}, err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, maxReplySize))
+	if err != nil {
+		return ws.BotAnswer{}, fmt.Errorf("bot webhook: read reply: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ws.BotAnswer{}, fmt.Errorf("bot webhook: unexpected status %d", resp.StatusCode)
+	}
+	return parseAnswer(resp.Header.Get("Content-Type"), reply)
+}
+
+// parseAnswer reads the bot's answer from a JSON reply body.
+func parseAnswer(contentType string, reply []byte) (ws.BotAnswer, error) {
+	var answer ws.BotAnswer
+	if len(bytes.TrimSpace(reply)) == 0 {
+		return answer, nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		return answer, nil
+	}
+	if err := json.Unmarshal(reply, &answer); err != nil {
+		return ws.BotAnswer{}, fmt.Errorf("bot webhook: decode reply: %w", err)
+	}
+	return answer, nil
+}
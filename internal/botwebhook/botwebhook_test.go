@@ -0,0 +1,119 @@
+package botwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"im/internal/webhook"
+	"im/internal/ws"
+)
+
+func newTestBot(url string) ws.AgencyBot {
+	return ws.AgencyBot{Agency: "agency-a", WebhookURL: url, Token: "bot-token"}
+}
+
+func dispatch(t *testing.T, ctx context.Context, handler http.HandlerFunc) (ws.BotAnswer, error) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	room := ws.RoomSummary{RoomID: "room-1", Agency: "agency-a"}
+	msg := ws.ChatMessage{RoomID: "room-1", Sequence: 3, SenderID: "p1", SenderRole: ws.RolePlayer, Content: "存款沒到帳"}
+	return NewDispatcher().DispatchBotMessage(ctx, newTestBot(server.URL), room, msg)
+}
+
+func TestDispatchRequest(t *testing.T) {
+	_, err := dispatch(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get(TokenHeader) != "bot-token" {
+			t.Errorf("expected the bot token, got %q", r.Header.Get(TokenHeader))
+		}
+		timestamp, err := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+		if err != nil {
+			t.Errorf("invalid timestamp header: %v", err)
+		}
+		if got, want := r.Header.Get(webhook.SignatureHeader), webhook.Sign("bot-token", timestamp, body); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+		var event messageEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if event.Event != ws.EventMessageCreated || event.Agency != "agency-a" || event.RoomID != "room-1" || event.Room.RoomID != "room-1" || event.Message.Content != "存款沒到帳" || event.Message.Sequence != 3 {
+			t.Errorf("unexpected event %+v", event)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if err != nil {
+		t.Fatalf("dispatch failed: %v", err)
+	}
+}
+
+func TestDispatchReply(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        ws.BotAnswer
+		wantErr     bool
+	}{
+		{"answer", http.StatusOK, "application/json", `{"content":"請提供訂單編號"}`, ws.BotAnswer{Content: "請提供訂單編號"}, false},
+		{"handoff", http.StatusOK, "application/json; charset=utf-8", `{"content":"為您轉接客服","handoff":true}`, ws.BotAnswer{Content: "為您轉接客服", Handoff: true}, false},
+		{"no content", http.StatusNoContent, "", "", ws.BotAnswer{}, false},
+		{"empty json body", http.StatusOK, "application/json", "", ws.BotAnswer{}, false},
+		{"empty json object", http.StatusAccepted, "application/json", `{}`, ws.BotAnswer{}, false},
+		{"plain text", http.StatusOK, "text/plain", "ok", ws.BotAnswer{}, false},
+		{"malformed json", http.StatusOK, "application/json", `{"content":`, ws.BotAnswer{}, true},
+		{"server error", http.StatusInternalServerError, "application/json", `{"content":"ignored"}`, ws.BotAnswer{}, true},
+		{"unauthorized", http.StatusUnauthorized, "", "", ws.BotAnswer{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, err := dispatch(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", answer)
+				}
+				return
+			}
+			if err != nil || answer != tt.want {
+				t.Fatalf("expected %+v, got %+v %v", tt.want, answer, err)
+			}
+		})
+	}
+}
+
+func TestDispatchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewDispatcher().DispatchBotMessage(ctx, newTestBot(server.URL), ws.RoomSummary{RoomID: "room-1"}, ws.ChatMessage{Content: "hi"})
+	if err == nil {
+		t.Fatal("expected a slow bot to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the dispatch to give up with the context, took %s", elapsed)
+	}
+}
@@ -30,10 +30,27 @@ type JWTConfig struct {
 	Expiry time.Duration
 }
 
+// ChatConfig controls how much chat state the hub keeps in memory.
+type ChatConfig struct {
+	HistoryLimit     int
+	InitialHistory   int
+	RoomIdleTTL      time.Duration
+	EvictionInterval time.Duration
+	AutoCloseAfter   time.Duration
+	// QueueNoticeInterval is how often waiting players are told their queue
+	// position; zero disables the notices.
+	QueueNoticeInterval time.Duration
+}
+
 type Config struct {
-	MySQL MySQLConfig
-	Redis RedisConfig
-	JWT   JWTConfig
+	MySQL         MySQLConfig
+	Redis         RedisConfig
+	JWT           JWTConfig
+	Chat          ChatConfig
+	BusinessHours BusinessHoursConfig
+	// APIKeys maps each backend API key to the agency it acts for. Keys of
+	// the master agency act for every agency.
+	APIKeys map[string]string
 }
 
 func Default() *Config {
@@ -55,6 +72,14 @@ func Default() *Config {
 			Issuer: "im-system",
 			Expiry: 24 * time.Hour,
 		},
+		Chat: ChatConfig{
+			HistoryLimit:        500,
+			InitialHistory:      50,
+			RoomIdleTTL:         30 * time.Minute,
+			EvictionInterval:    time.Minute,
+			AutoCloseAfter:      24 * time.Hour,
+			QueueNoticeInterval: 30 * time.Second,
+		},
 	}
 }
 
@@ -76,6 +101,22 @@ func (c MySQLConfig) DSN() string {
 	)
 }
 
+// applyAPIKeyValue reads an "agency = key1,key2" line of the api_keys section.
+func applyAPIKeyValue(cfg *Config, agency, value string) {
+	if agency == "" {
+		return
+	}
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if cfg.APIKeys == nil {
+			cfg.APIKeys = make(map[string]string)
+		}
+		cfg.APIKeys[key] = agency
+	}
+}
+
 func quoteDSNValue(value string) string {
 	replacer := strings.NewReplacer("@", "%40", ":", "%3A")
 	return replacer.Replace(value)
@@ -149,6 +190,14 @@ func Load(path string) (*Config, error) {
 					cfg.JWT.Expiry = time.Duration(parsed) * time.Second
 				}
 			}
+		case "chat":
+			applyChatValue(&cfg.Chat, key, value)
+		case "api_keys":
+			applyAPIKeyValue(cfg, strings.TrimSpace(parts[0]), value)
+		case "business_hours":
+			if err := applyBusinessHoursValue(&cfg.BusinessHours, strings.TrimSpace(parts[0]), value); err != nil {
+				return nil, fmt.Errorf("business_hours %s: %w", strings.TrimSpace(parts[0]), err)
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -174,6 +223,31 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+func applyChatValue(chat *ChatConfig, key, value string) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return
+	}
+	switch key {
+	case "history_limit":
+		chat.HistoryLimit = parsed
+	case "initial_history":
+		if parsed > 0 {
+			chat.InitialHistory = parsed
+		}
+	case "room_idle_ttl":
+		chat.RoomIdleTTL = time.Duration(parsed) * time.Second
+	case "eviction_interval":
+		if parsed > 0 {
+			chat.EvictionInterval = time.Duration(parsed) * time.Second
+		}
+	case "auto_close":
+		chat.AutoCloseAfter = time.Duration(parsed) * time.Second
+	case "queue_notice_interval":
+		chat.QueueNoticeInterval = time.Duration(parsed) * time.Second
+	}
+}
+
 func applyMySQLValue(mysql *MySQLConfig, key, value string) {
 	switch key {
 	case "host":
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BusinessHoursConfig holds the opening hours and holidays of each agency.
+// The empty agency key holds the defaults; an agency without any hours
+// configured is always open except on its holidays.
+type BusinessHoursConfig struct {
+	Location *time.Location
+	// Hours maps an agency to its weekly opening hours. Agencies without an
+	// entry use the hours under the empty key.
+	Hours map[string]WeeklyHours
+	// Holidays maps an agency to the dates (YYYY-MM-DD) it is closed. The
+	// dates under the empty key apply to every agency.
+	Holidays map[string]map[string]bool
+}
+
+// WeeklyHours lists the opening ranges of each day, indexed by time.Weekday.
+type WeeklyHours [7][]ClockRange
+
+// ClockRange is an opening range given as offsets from midnight; End is
+// exclusive.
+type ClockRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// IsOpen reports whether the agency's support desk is open at the given time.
+func (c BusinessHoursConfig) IsOpen(agency string, at time.Time) bool {
+	location := c.Location
+	if location == nil {
+		location = time.Local
+	}
+	local := at.In(location)
+
+	date := local.Format("2006-01-02")
+	if c.Holidays[""][date] || c.Holidays[agency][date] {
+		return false
+	}
+
+	hours, ok := c.Hours[agency]
+	if !ok {
+		hours, ok = c.Hours[""]
+	}
+	if !ok {
+		return true
+	}
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute + time.Duration(local.Second())*time.Second
+	for _, span := range hours[local.Weekday()] {
+		if offset >= span.Start && offset < span.End {
+			return true
+		}
+	}
+	return false
+}
+
+// applyBusinessHoursValue reads one key of the [business_hours] section:
+// timezone, default, holidays, <agency> or <agency>.holidays. Agency codes keep
+// their case.
+func applyBusinessHoursValue(hours *BusinessHoursConfig, key, value string) error {
+	name := strings.ToLower(key)
+	switch {
+	case name == "timezone":
+		if value == "" {
+			return nil
+		}
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return err
+		}
+		hours.Location = location
+	case name == "holidays" || strings.HasSuffix(name, ".holidays"):
+		agency := strings.TrimSuffix(key[:len(key)-len("holidays")], ".")
+		dates, err := parseHolidays(value)
+		if err != nil {
+			return err
+		}
+		if hours.Holidays == nil {
+			hours.Holidays = make(map[string]map[string]bool)
+		}
+		hours.Holidays[agency] = dates
+	default:
+		agency := key
+		if name == "default" {
+			agency = ""
+		}
+		if value == "" {
+			delete(hours.Hours, agency)
+			return nil
+		}
+		weekly, err := parseWeeklyHours(value)
+		if err != nil {
+			return err
+		}
+		if hours.Hours == nil {
+			hours.Hours = make(map[string]WeeklyHours)
+		}
+		hours.Hours[agency] = weekly
+	}
+	return nil
+}
+
+// parseWeeklyHours parses segments such as "mon-fri 09:00-12:00,13:00-18:00;
+// sat 10:00-14:00".
+func parseWeeklyHours(value string) (WeeklyHours, error) {
+	var weekly WeeklyHours
+	for _, segment := range strings.Split(value, ";") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" {
+			continue
+		}
+		fields := strings.Fields(segment)
+		if len(fields) != 2 {
+			return weekly, fmt.Errorf("invalid hours %q", segment)
+		}
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return weekly, err
+		}
+		for _, span := range strings.Split(fields[1], ",") {
+			clock, err := parseClockRange(span)
+			if err != nil {
+				return weekly, err
+			}
+			for _, day := range days {
+				weekly[day] = append(weekly[day], clock)
+			}
+		}
+	}
+	return weekly, nil
+}
+
+// parseWeekdays parses comma separated days and day ranges, e.g. "mon-fri" or
+// "sat,sun". Ranges may wrap around the week.
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	days := make([]time.Weekday, 0, 7)
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		start, ok := weekdayNames[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", from)
+		}
+		if !isRange {
+			days = append(days, start)
+			continue
+		}
+		end, ok := weekdayNames[to]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", to)
+		}
+		for day := start; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClockRange(value string) (ClockRange, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return ClockRange{}, fmt.Errorf("invalid time range %q", value)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return ClockRange{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return ClockRange{}, err
+	}
+	if end <= start {
+		return ClockRange{}, fmt.Errorf("time range %q ends before it starts", value)
+	}
+	return ClockRange{Start: start, End: end}, nil
+}
+
+// parseClock parses HH:MM, allowing 24:00 as the end of the day.
+func parseClock(value string) (time.Duration, error) {
+	hour, minute, ok := strings.Cut(value, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	h, err := strconv.Atoi(hour)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	m, err := strconv.Atoi(minute)
+	if err != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func parseHolidays(value string) (map[string]bool, error) {
+	dates := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday %q", part)
+		}
+		dates[day.Format("2006-01-02")] = true
+	}
+	return dates, nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWeeklyHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[time.Weekday][]ClockRange
+		wantErr bool
+	}{
+		{
+			name:  "weekday range with split day",
+			value: "mon-fri 09:00-12:00,13:00-18:00",
+			want: map[time.Weekday][]ClockRange{
+				time.Monday:    {{9 * time.Hour, 12 * time.Hour}, {13 * time.Hour, 18 * time.Hour}},
+				time.Friday:    {{9 * time.Hour, 12 * time.Hour}, {13 * time.Hour, 18 * time.Hour}},
+				time.Saturday:  nil,
+				time.Sunday:    nil,
+				time.Wednesday: {{9 * time.Hour, 12 * time.Hour}, {13 * time.Hour, 18 * time.Hour}},
+			},
+		},
+		{
+			name:  "range wrapping around the week",
+			value: "fri-mon 10:00-14:00",
+			want: map[time.Weekday][]ClockRange{
+				time.Friday:    {{10 * time.Hour, 14 * time.Hour}},
+				time.Saturday:  {{10 * time.Hour, 14 * time.Hour}},
+				time.Sunday:    {{10 * time.Hour, 14 * time.Hour}},
+				time.Monday:    {{10 * time.Hour, 14 * time.Hour}},
+				time.Tuesday:   nil,
+				time.Thursday:  nil,
+				time.Wednesday: nil,
+			},
+		},
+		{
+			name:  "day list, several segments and end of day",
+			value: "sat,sun 20:00-24:00; wed 00:00-01:30",
+			want: map[time.Weekday][]ClockRange{
+				time.Saturday:  {{20 * time.Hour, 24 * time.Hour}},
+				time.Sunday:    {{20 * time.Hour, 24 * time.Hour}},
+				time.Wednesday: {{0, 90 * time.Minute}},
+				time.Monday:    nil,
+			},
+		},
+		{name: "unknown weekday", value: "mon-fry 09:00-18:00", wantErr: true},
+		{name: "missing range", value: "mon", wantErr: true},
+		{name: "range ending before it starts", value: "mon 18:00-09:00", wantErr: true},
+		{name: "minutes past midnight", value: "mon 09:00-24:30", wantErr: true},
+		{name: "invalid hour", value: "mon 25:00-26:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weekly, err := parseWeeklyHours(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q, got %+v", tt.value, weekly)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse %q failed: %v", tt.value, err)
+			}
+			for day, want := range tt.want {
+				got := weekly[day]
+				if len(got) != len(want) {
+					t.Fatalf("%s: expected %v, got %v", day, want, got)
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Fatalf("%s: expected %v, got %v", day, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBusinessHoursIsOpen(t *testing.T) {
+	location := time.FixedZone("UTC+8", 8*60*60)
+	hours := BusinessHoursConfig{Location: location}
+	for key, value := range map[string]string{
+		"timezone":         "",
+		"default":          "mon-fri 09:00-18:00",
+		"late":             "sat-sun 20:00-24:00",
+		"holidays":         "2026-01-01",
+		"late.holidays":    "2026-01-03",
+		"AgencyX.holidays": "2026-01-05",
+	} {
+		if err := applyBusinessHoursValue(&hours, key, value); err != nil {
+			t.Fatalf("apply %s failed: %v", key, err)
+		}
+	}
+
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+		if err != nil {
+			t.Fatalf("parse %q: %v", value, err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name   string
+		agency string
+		at     time.Time
+		want   bool
+	}{
+		{"default hours open", "agency-a", at("2026-01-06 09:00"), true},
+		{"default hours end is exclusive", "agency-a", at("2026-01-06 18:00"), false},
+		{"default hours weekend", "agency-a", at("2026-01-10 12:00"), false},
+		{"shared holiday", "agency-a", at("2026-01-01 10:00"), false},
+		{"agency hours replace default", "late", at("2026-01-06 10:00"), false},
+		{"agency hours until midnight", "late", at("2026-01-10 23:59"), true},
+		{"agency holiday", "late", at("2026-01-03 21:00"), false},
+		{"agency holiday keeps case", "AgencyX", at("2026-01-05 10:00"), false},
+		{"other agency unaffected by holiday", "agency-a", at("2026-01-05 10:00"), true},
+		{"time converted to location", "agency-a", time.Date(2026, 1, 6, 1, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hours.IsOpen(tt.agency, tt.at); got != tt.want {
+				t.Fatalf("IsOpen(%q, %s) = %v, want %v", tt.agency, tt.at, got, tt.want)
+			}
+		})
+	}
+
+	if !(BusinessHoursConfig{}).IsOpen("agency-a", at("2026-01-10 03:00")) {
+		t.Fatalf("expected agencies without hours to always be open")
+	}
+}
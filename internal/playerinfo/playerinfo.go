@@ -0,0 +1,114 @@
+// Package playerinfo queries an agency's player info API for details that
+// affect how a player is served, such as their VIP level.
+package playerinfo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"im/internal/storage"
+)
+
+const defaultTimeout = 3 * time.Second
+
+// priorityKeys are the response fields read as a priority, in order of
+// preference. The first one present wins.
+var priorityKeys = []string{"priority", "vipLevel", "vip_level", "vip"}
+
+// Resolver implements ws.PriorityResolver on top of the PlayerInfoAPI stored
+// in each agency's API settings.
+type Resolver struct {
+	settings storage.AgencySettingsStore
+	client   *http.Client
+}
+
+func NewResolver(settings storage.AgencySettingsStore) *Resolver {
+	return &Resolver{
+		settings: settings,
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// ResolvePriority calls GET {playerInfoApi}?agency={agency}&playerId={id} and
+// reads the player's priority or VIP level from the JSON response, either at
+// the top level or under "data". Agencies without a player info API, players
+// the API does not know (404) and players without a level have priority zero.
+func (r *Resolver) ResolvePriority(ctx context.Context, agency, playerID string) (int, error) {
+	if agency == "" || r.settings == nil {
+		return 0, nil
+	}
+	settings, err := r.settings.Get(ctx, agency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	endpoint := strings.TrimSpace(settings.PlayerInfoAPI)
+	if endpoint == "" {
+		return 0, nil
+	}
+
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("player info api: %w", err)
+	}
+	query := target.Query()
+	query.Set("agency", agency)
+	query.Set("playerId", playerID)
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("player info api: unexpected status %d", resp.StatusCode)
+	}
+
+	var payload map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return 0, fmt.Errorf("player info api: %w", err)
+	}
+	if priority, ok := readPriority(payload); ok {
+		return priority, nil
+	}
+	if data, ok := payload["data"].(map[string]any); ok {
+		if priority, ok := readPriority(data); ok {
+			return priority, nil
+		}
+	}
+	return 0, nil
+}
+
+func readPriority(fields map[string]any) (int, bool) {
+	for _, key := range priorityKeys {
+		switch value := fields[key].(type) {
+		case float64:
+			return int(value), true
+		case string:
+			if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,126 @@
+package playerinfo
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"im/internal/storage"
+)
+
+// memorySettingsStore serves agency settings from a map, returning
+// sql.ErrNoRows for unknown agencies like the repository does.
+type memorySettingsStore map[string]storage.AgencyAPISettings
+
+func (s memorySettingsStore) List(context.Context) ([]storage.AgencyAPISettings, error) {
+	settings := make([]storage.AgencyAPISettings, 0, len(s))
+	for _, item := range s {
+		settings = append(settings, item)
+	}
+	return settings, nil
+}
+
+func (s memorySettingsStore) Get(_ context.Context, agency string) (*storage.AgencyAPISettings, error) {
+	settings, ok := s[agency]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &settings, nil
+}
+
+func (s memorySettingsStore) Upsert(_ context.Context, settings *storage.AgencyAPISettings) error {
+	s[settings.Agency] = *settings
+	return nil
+}
+
+func newTestResolver(endpoint string) *Resolver {
+	return NewResolver(memorySettingsStore{
+		"agency-a": {Agency: "agency-a", PlayerInfoAPI: endpoint},
+		"agency-b": {Agency: "agency-b"},
+	})
+}
+
+func TestResolvePriority(t *testing.T) {
+	players := map[string]string{
+		"vip":    `{"vipLevel":3}`,
+		"nested": `{"code":0,"data":{"vip_level":"2"}}`,
+		"ranked": `{"priority":5,"vipLevel":1}`,
+		"plain":  `{"nickname":"玩家"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("agency") != "agency-a" || r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, ok := players[r.URL.Query().Get("playerId")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	resolver := newTestResolver(server.URL + "/players?token=abc")
+
+	tests := []struct {
+		name     string
+		agency   string
+		playerID string
+		want     int
+	}{
+		{"vip level", "agency-a", "vip", 3},
+		{"level under data", "agency-a", "nested", 2},
+		{"priority wins over vip level", "agency-a", "ranked", 5},
+		{"player without a level", "agency-a", "plain", 0},
+		{"player unknown to the api", "agency-a", "missing", 0},
+		{"agency without a player info api", "agency-b", "vip", 0},
+		{"agency without settings", "agency-c", "vip", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ResolvePriority(context.Background(), tt.agency, tt.playerID)
+			if err != nil || got != tt.want {
+				t.Fatalf("ResolvePriority(%q, %q) = %d, %v, want %d", tt.agency, tt.playerID, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePriorityErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("playerId") {
+		case "malformed":
+			w.Write([]byte(`{"vipLevel":`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"vipLevel":1}`))
+		}
+	}))
+	defer server.Close()
+	resolver := newTestResolver(server.URL)
+	resolver.client.Timeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		playerID string
+		want     string
+	}{
+		{"malformed body", "malformed", "player info api"},
+		{"server error", "broken", "unexpected status 500"},
+		{"timeout", "slow", "Timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ResolvePriority(context.Background(), "agency-a", tt.playerID)
+			if err == nil || !strings.Contains(err.Error(), tt.want) || got != 0 {
+				t.Fatalf("expected an error containing %q, got %d, %v", tt.want, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Package scheduler sends the chat messages agents schedule for later into
+// their rooms through the hub once their send time has come.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"im/internal/storage"
+	"im/internal/ws"
+)
+
+const (
+	// MaxDelay is how far ahead a message may be scheduled.
+	MaxDelay = 30 * 24 * time.Hour
+
+	pollInterval = 5 * time.Second
+	storeTimeout = 5 * time.Second
+	dueBatchSize = 50
+
+	// scheduledMetadataKey carries the scheduled message ID on the chat
+	// message it was sent as.
+	scheduledMetadataKey = "scheduled"
+)
+
+// ErrInvalidScheduledMessage is returned when a scheduled message has no
+// content or a send time outside the allowed window.
+var ErrInvalidScheduledMessage = errors.New("invalid scheduled message")
+
+// Scheduler stores scheduled messages and sends them when they are due.
+// Because pending messages live in the store, they are still sent after a
+// restart; messages that came due while the server was down are sent as soon
+// as it is back.
+type Scheduler struct {
+	store storage.ScheduledMessageStore
+}
+
+func NewScheduler(store storage.ScheduledMessageStore) *Scheduler {
+	return &Scheduler{store: store}
+}
+
+// Run sends due messages into the hub until ctx is cancelled. Messages the
+// previous run left in the sending state are settled first.
+func (s *Scheduler) Run(ctx context.Context, hub *ws.Hub) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	s.resumeSending(ctx, hub)
+	s.sendDue(ctx, hub)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendDue(ctx, hub)
+		}
+	}
+}
+
+func (s *Scheduler) sendDue(ctx context.Context, hub *ws.Hub) {
+	for ctx.Err() == nil {
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		due, err := s.store.DueScheduledMessages(storeCtx, time.Now(), dueBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("load due scheduled messages: %v", err)
+			return
+		}
+		for i := range due {
+			s.send(ctx, hub, &due[i])
+		}
+		if len(due) < dueBatchSize {
+			return
+		}
+	}
+}
+
+// send posts one message into its room. The message is claimed as sending
+// before it is posted so that a concurrent cancellation either wins or is
+// refused, and is only marked sent once the hub has stored it.
+func (s *Scheduler) send(ctx context.Context, hub *ws.Hub, message *storage.ScheduledMessage) {
+	message.Status = storage.ScheduledMessageSending
+	if err := s.finish(ctx, message); err != nil {
+		if !errors.Is(err, storage.ErrScheduledMessageNotPending) {
+			log.Printf("claim scheduled message %d: %v", message.ID, err)
+		}
+		return
+	}
+	stored, err := post(hub, message)
+	s.record(ctx, message, stored, err)
+}
+
+func post(hub *ws.Hub, message *storage.ScheduledMessage) (ws.ChatMessage, error) {
+	return hub.PostMessage(message.RoomID, ws.ChatMessage{
+		SenderID:    message.SenderID,
+		DisplayName: message.DisplayName,
+		Content:     message.Content,
+		Metadata:    map[string]string{scheduledMetadataKey: strconv.FormatInt(message.ID, 10)},
+	})
+}
+
+// resumeSending settles the messages a stopped server left in the sending
+// state. A message that already reached its room, found by its
+// metadata.scheduled, is only marked sent; the others are posted now.
+func (s *Scheduler) resumeSending(ctx context.Context, hub *ws.Hub) {
+	for ctx.Err() == nil {
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		sending, err := s.store.SendingScheduledMessages(storeCtx, dueBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("load sending scheduled messages: %v", err)
+			return
+		}
+		for i := range sending {
+			message := &sending[i]
+			stored, found, err := hub.MessageWithMetadata(message.RoomID, scheduledMetadataKey, strconv.FormatInt(message.ID, 10))
+			if err == nil && !found {
+				stored, err = post(hub, message)
+			}
+			if err := s.record(ctx, message, stored, err); err != nil {
+				return
+			}
+		}
+		if len(sending) < dueBatchSize {
+			return
+		}
+	}
+}
+
+// record stores the outcome of posting a claimed message.
+func (s *Scheduler) record(ctx context.Context, message *storage.ScheduledMessage, stored ws.ChatMessage, err error) error {
+	if err != nil {
+		log.Printf("send scheduled message %d to room %s: %v", message.ID, message.RoomID, err)
+		message.Status = storage.ScheduledMessageFailed
+		message.LastError = err.Error()
+		message.SentAt = nil
+	} else {
+		sentAt := stored.Timestamp
+		message.Status = storage.ScheduledMessageSent
+		message.Sequence = stored.Sequence
+		message.SentAt = &sentAt
+	}
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := s.store.UpdateScheduledMessage(storeCtx, message); err != nil {
+		log.Printf("update scheduled message %d: %v", message.ID, err)
+		return err
+	}
+	return nil
+}
+
+func (s *Scheduler) finish(ctx context.Context, message *storage.ScheduledMessage) error {
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	return s.store.FinishScheduledMessage(storeCtx, message)
+}
+
+// Schedule validates and stores a message to be sent at its SendAt.
+func (s *Scheduler) Schedule(ctx context.Context, message *storage.ScheduledMessage) error {
+	message.Content = strings.TrimSpace(message.Content)
+	if message.Content == "" {
+		return fmt.Errorf("%w: content is required", ErrInvalidScheduledMessage)
+	}
+	now := time.Now()
+	if !message.SendAt.After(now) {
+		return fmt.Errorf("%w: send time must be in the future", ErrInvalidScheduledMessage)
+	}
+	if message.SendAt.After(now.Add(MaxDelay)) {
+		return fmt.Errorf("%w: send time must be within %d days", ErrInvalidScheduledMessage, int(MaxDelay/(24*time.Hour)))
+	}
+	message.Status = storage.ScheduledMessagePending
+	message.LastError = ""
+	message.Sequence = 0
+	message.SentAt = nil
+	message.CreatedAt = now
+	return s.store.CreateScheduledMessage(ctx, message)
+}
+
+// Scheduled lists the scheduled messages of a room, optionally only those in
+// the given status.
+func (s *Scheduler) Scheduled(ctx context.Context, roomID, status string) ([]storage.ScheduledMessage, error) {
+	return s.store.ListScheduledMessages(ctx, roomID, status)
+}
+
+// Message returns one scheduled message of a room.
+func (s *Scheduler) Message(ctx context.Context, roomID string, id int64) (*storage.ScheduledMessage, error) {
+	message, err := s.store.GetScheduledMessage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.RoomID != roomID {
+		return nil, storage.ErrScheduledMessageNotFound
+	}
+	return message, nil
+}
+
+// Cancel stops a pending message from being sent. It returns
+// storage.ErrScheduledMessageNotPending once the message was sent.
+func (s *Scheduler) Cancel(ctx context.Context, message *storage.ScheduledMessage) error {
+	message.Status = storage.ScheduledMessageCancelled
+	return s.store.FinishScheduledMessage(ctx, message)
+}
@@ -0,0 +1,241 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"im/internal/storage"
+	"im/internal/ws"
+)
+
+// memoryScheduledMessageStore keeps scheduled messages in memory.
+type memoryScheduledMessageStore struct {
+	mu       sync.Mutex
+	nextID   int64
+	messages map[int64]storage.ScheduledMessage
+}
+
+func newMemoryScheduledMessageStore() *memoryScheduledMessageStore {
+	return &memoryScheduledMessageStore{messages: make(map[int64]storage.ScheduledMessage)}
+}
+
+func (s *memoryScheduledMessageStore) CreateScheduledMessage(_ context.Context, message *storage.ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	message.ID = s.nextID
+	s.messages[message.ID] = *message
+	return nil
+}
+
+func (s *memoryScheduledMessageStore) GetScheduledMessage(_ context.Context, id int64) (*storage.ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message, ok := s.messages[id]
+	if !ok {
+		return nil, storage.ErrScheduledMessageNotFound
+	}
+	return &message, nil
+}
+
+func (s *memoryScheduledMessageStore) ListScheduledMessages(_ context.Context, roomID, status string) ([]storage.ScheduledMessage, error) {
+	return s.list(func(message storage.ScheduledMessage) bool {
+		return message.RoomID == roomID && (status == "" || message.Status == status)
+	}, 0), nil
+}
+
+func (s *memoryScheduledMessageStore) DueScheduledMessages(_ context.Context, now time.Time, limit int) ([]storage.ScheduledMessage, error) {
+	return s.list(func(message storage.ScheduledMessage) bool {
+		return message.Status == storage.ScheduledMessagePending && !message.SendAt.After(now)
+	}, limit), nil
+}
+
+func (s *memoryScheduledMessageStore) SendingScheduledMessages(_ context.Context, limit int) ([]storage.ScheduledMessage, error) {
+	return s.list(func(message storage.ScheduledMessage) bool {
+		return message.Status == storage.ScheduledMessageSending
+	}, limit), nil
+}
+
+func (s *memoryScheduledMessageStore) FinishScheduledMessage(_ context.Context, message *storage.ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.messages[message.ID]
+	if !ok {
+		return storage.ErrScheduledMessageNotFound
+	}
+	if current.Status != storage.ScheduledMessagePending {
+		return storage.ErrScheduledMessageNotPending
+	}
+	s.messages[message.ID] = *message
+	return nil
+}
+
+func (s *memoryScheduledMessageStore) UpdateScheduledMessage(_ context.Context, message *storage.ScheduledMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[message.ID]; !ok {
+		return storage.ErrScheduledMessageNotFound
+	}
+	s.messages[message.ID] = *message
+	return nil
+}
+
+func (s *memoryScheduledMessageStore) list(match func(storage.ScheduledMessage) bool, limit int) []storage.ScheduledMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []storage.ScheduledMessage
+	for _, message := range s.messages {
+		if match(message) {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages
+}
+
+// insert stores a message as is, bypassing the validation of Schedule.
+func (s *memoryScheduledMessageStore) insert(message storage.ScheduledMessage) int64 {
+	s.CreateScheduledMessage(context.Background(), &message)
+	return message.ID
+}
+
+func (s *memoryScheduledMessageStore) get(t *testing.T, id int64) storage.ScheduledMessage {
+	t.Helper()
+	message, err := s.GetScheduledMessage(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get scheduled message %d: %v", id, err)
+	}
+	return *message
+}
+
+func newTestRoom(t *testing.T, hub *ws.Hub) string {
+	t.Helper()
+	room, _, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	return room.RoomID
+}
+
+func TestSchedule(t *testing.T) {
+	scheduler := NewScheduler(newMemoryScheduledMessageStore())
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		content string
+		sendAt  time.Time
+		wantErr bool
+	}{
+		{"within the window", "  稍後為您處理  ", now.Add(time.Hour), false},
+		{"blank content", "   ", now.Add(time.Hour), true},
+		{"send time in the past", "hi", now.Add(-time.Minute), true},
+		{"send time beyond the maximum delay", "hi", now.Add(MaxDelay + time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &storage.ScheduledMessage{RoomID: "room-1", Content: tt.content, SendAt: tt.sendAt, Status: storage.ScheduledMessageSent}
+			err := scheduler.Schedule(ctx, message)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidScheduledMessage) {
+					t.Fatalf("expected ErrInvalidScheduledMessage, got %v", err)
+				}
+				return
+			}
+			if err != nil || message.ID == 0 || message.Status != storage.ScheduledMessagePending || message.Content != "稍後為您處理" {
+				t.Fatalf("unexpected scheduled message %+v %v", message, err)
+			}
+		})
+	}
+}
+
+func TestSendDuePostsIntoRoom(t *testing.T) {
+	store := newMemoryScheduledMessageStore()
+	scheduler := NewScheduler(store)
+	hub := ws.NewHub()
+	roomID := newTestRoom(t, hub)
+
+	dueID := store.insert(storage.ScheduledMessage{RoomID: roomID, SenderID: "agent-1", DisplayName: "客服一", Content: "提款已到帳", SendAt: time.Now().Add(-time.Second), Status: storage.ScheduledMessagePending})
+	laterID := store.insert(storage.ScheduledMessage{RoomID: roomID, SenderID: "agent-1", Content: "later", SendAt: time.Now().Add(time.Hour), Status: storage.ScheduledMessagePending})
+	missingID := store.insert(storage.ScheduledMessage{RoomID: "room-unknown", SenderID: "agent-1", Content: "lost", SendAt: time.Now().Add(-time.Second), Status: storage.ScheduledMessagePending})
+
+	scheduler.sendDue(context.Background(), hub)
+
+	sent := store.get(t, dueID)
+	if sent.Status != storage.ScheduledMessageSent || sent.Sequence != 1 || sent.SentAt == nil {
+		t.Fatalf("expected the due message to be sent, got %+v", sent)
+	}
+	snapshot, _ := hub.RoomSnapshot(roomID)
+	if len(snapshot.History) != 1 || snapshot.History[0].Content != "提款已到帳" || snapshot.History[0].Metadata["scheduled"] != "1" {
+		t.Fatalf("expected the message in the room history, got %+v", snapshot.History)
+	}
+	if later := store.get(t, laterID); later.Status != storage.ScheduledMessagePending {
+		t.Fatalf("expected the later message to stay pending, got %+v", later)
+	}
+	if failed := store.get(t, missingID); failed.Status != storage.ScheduledMessageFailed || failed.LastError == "" {
+		t.Fatalf("expected the message for an unknown room to fail, got %+v", failed)
+	}
+}
+
+func TestCancelRacesWithSend(t *testing.T) {
+	store := newMemoryScheduledMessageStore()
+	scheduler := NewScheduler(store)
+	hub := ws.NewHub()
+	roomID := newTestRoom(t, hub)
+	ctx := context.Background()
+
+	cancelledID := store.insert(storage.ScheduledMessage{RoomID: roomID, Content: "cancelled", SendAt: time.Now().Add(-time.Second), Status: storage.ScheduledMessagePending})
+	cancelled := store.get(t, cancelledID)
+	if err := scheduler.Cancel(ctx, &cancelled); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	scheduler.sendDue(ctx, hub)
+	if snapshot, _ := hub.RoomSnapshot(roomID); len(snapshot.History) != 0 {
+		t.Fatalf("expected a cancelled message not to be sent, got %+v", snapshot.History)
+	}
+
+	sentID := store.insert(storage.ScheduledMessage{RoomID: roomID, Content: "sent", SendAt: time.Now().Add(-time.Second), Status: storage.ScheduledMessagePending})
+	scheduler.sendDue(ctx, hub)
+	sent := store.get(t, sentID)
+	if err := scheduler.Cancel(ctx, &sent); !errors.Is(err, storage.ErrScheduledMessageNotPending) {
+		t.Fatalf("expected cancelling a sent message to be refused, got %v", err)
+	}
+	if stored := store.get(t, sentID); stored.Status != storage.ScheduledMessageSent {
+		t.Fatalf("expected the message to stay sent, got %+v", stored)
+	}
+}
+
+func TestResumeSending(t *testing.T) {
+	store := newMemoryScheduledMessageStore()
+	scheduler := NewScheduler(store)
+	hub := ws.NewHub()
+	roomID := newTestRoom(t, hub)
+
+	postedID := store.insert(storage.ScheduledMessage{RoomID: roomID, Content: "posted before the crash", Status: storage.ScheduledMessageSending})
+	unpostedID := store.insert(storage.ScheduledMessage{RoomID: roomID, Content: "claimed before the crash", Status: storage.ScheduledMessageSending})
+	posted := store.get(t, postedID)
+	if _, err := post(hub, &posted); err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+
+	scheduler.resumeSending(context.Background(), hub)
+
+	snapshot, _ := hub.RoomSnapshot(roomID)
+	if len(snapshot.History) != 2 || snapshot.History[0].Content != "posted before the crash" || snapshot.History[1].Content != "claimed before the crash" {
+		t.Fatalf("expected each message to be posted exactly once, got %+v", snapshot.History)
+	}
+	if stored := store.get(t, postedID); stored.Status != storage.ScheduledMessageSent || stored.Sequence != 1 {
+		t.Fatalf("expected the posted message to be marked sent, got %+v", stored)
+	}
+	if stored := store.get(t, unpostedID); stored.Status != storage.ScheduledMessageSent || stored.Sequence != 2 {
+		t.Fatalf("expected the claimed message to be sent, got %+v", stored)
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -13,8 +15,10 @@ import (
 	"time"
 
 	"im/internal/auth"
+	"im/internal/scheduler"
 	"im/internal/simplews"
 	"im/internal/storage"
+	"im/internal/webhook"
 	"im/internal/ws"
 )
 
@@ -23,15 +27,23 @@ type Server struct {
 	hub        *ws.Hub
 	auth       *auth.Manager
 	settings   storage.AgencySettingsStore
+	webhooks   *webhook.Dispatcher
+	scheduler  *scheduler.Scheduler
+	apiKeys    map[string]string
 	upgrader   simplews.Upgrader
 	staticRoot string
 }
 
-func New(hub *ws.Hub, authManager *auth.Manager, settings storage.AgencySettingsStore, staticRoot string) *Server {
+// New creates the server. apiKeys maps the backend API keys accepted in the
+// X-API-Key header to the agency each one acts for.
+func New(hub *ws.Hub, authManager *auth.Manager, settings storage.AgencySettingsStore, webhooks *webhook.Dispatcher, scheduler *scheduler.Scheduler, apiKeys map[string]string, staticRoot string) *Server {
 	return &Server{
-		hub:      hub,
-		auth:     authManager,
-		settings: settings,
+		hub:       hub,
+		auth:      authManager,
+		settings:  settings,
+		webhooks:  webhooks,
+		scheduler: scheduler,
+		apiKeys:   apiKeys,
 		upgrader: simplews.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -51,11 +63,27 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("/api/auth/profile", s.handleProfile)
 	mux.HandleFunc("/api/auth/register", s.handleRegister)
 	mux.HandleFunc("/api/agents/online", s.handleOnlineAgents)
+	mux.HandleFunc("/api/agents/", s.handleAgent)
+	mux.HandleFunc("/api/metrics", s.handleMetrics)
 	mux.HandleFunc("/api/agencies/settings", s.handleAgencySettingsCollection)
 	mux.HandleFunc("/api/agencies/settings/", s.handleAgencySettings)
+	mux.HandleFunc("/api/canned-responses", s.handleCannedResponses)
+	mux.HandleFunc("/api/canned-responses/", s.handleCannedResponse)
+	mux.HandleFunc("/api/bot/rooms/", s.handleBotReply)
+	mux.HandleFunc("/api/webhooks", s.handleWebhooks)
+	mux.HandleFunc("/api/webhooks/", s.handleWebhook)
 	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/ws/admin", s.handleAdminEvents)
+	mux.HandleFunc("/ws/sse", s.handleEventSource)
+	mux.HandleFunc("/ws/session", s.handleSession)
+	mux.HandleFunc("/ws/poll", s.handlePoll)
+	mux.HandleFunc("/ws/send", s.handleSessionSend)
 	mux.HandleFunc("/api/rooms", s.handleRooms)
+	mux.HandleFunc("/api/queue", s.handleQueue)
 	mux.HandleFunc("/api/rooms/", s.handleRoom)
+	mux.HandleFunc("/api/outreach", s.handleOutreach)
+	mux.HandleFunc("/api/announcements", s.handleAnnouncements)
+	mux.HandleFunc("/api/announcements/", s.handleAnnouncement)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
@@ -116,6 +144,43 @@ func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) (*auth.Acc
 	return account, true
 }
 
+// apiCaller is who a REST request acts as: an admin account or a backend
+// holding an API key.
+type apiCaller struct {
+	ID          string
+	DisplayName string
+	// Agency limits the caller to one agency; empty means every agency.
+	Agency string
+}
+
+func (c apiCaller) canAccess(agency string) bool {
+	return c.Agency == "" || strings.EqualFold(c.Agency, agency)
+}
+
+// requireAdminOrAPIKey accepts either an admin token or an API key from the
+// X-API-Key header.
+func (s *Server) requireAdminOrAPIKey(w http.ResponseWriter, r *http.Request) (apiCaller, bool) {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		for candidate, agency := range s.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) != 1 {
+				continue
+			}
+			caller := apiCaller{ID: "api:" + agency, DisplayName: "系統", Agency: agency}
+			if strings.EqualFold(agency, auth.MasterAgency) {
+				caller.Agency = ""
+			}
+			return caller, true
+		}
+		s.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return apiCaller{}, false
+	}
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return apiCaller{}, false
+	}
+	return apiCaller{ID: account.Username, DisplayName: account.DisplayName, Agency: adminScope(account)}, true
+}
+
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -171,7 +236,14 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		s.writeError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	s.writeJSON(w, account, http.StatusOK)
+	if account.Role != auth.RolePlayer {
+		s.writeJSON(w, account, http.StatusOK)
+		return
+	}
+	s.writeJSON(w, struct {
+		*auth.Account
+		PendingMessages int `json:"pendingMessages"`
+	}{Account: account, PendingMessages: s.hub.PendingOutreach(account.Agency, account.Username)}, http.StatusOK)
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -233,13 +305,179 @@ func (s *Server) handleOnlineAgents(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if _, ok := s.requireAdmin(w, r); !ok {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
 		return
 	}
 	agents := s.hub.OnlineAgents()
+	if scope := adminScope(account); scope != "" {
+		scoped := make([]ws.AgentPresence, 0, len(agents))
+		for _, agent := range agents {
+			if strings.EqualFold(agent.Agency, scope) {
+				scoped = append(scoped, agent)
+			}
+		}
+		agents = scoped
+	}
 	s.writeJSON(w, agents, http.StatusOK)
 }
 
+// handleAgent serves /api/agents/profiles and the per-agent status and
+// profile resources under /api/agents/{agentId}/.
+func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/agents/"), "/"), "/")
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if len(parts) == 1 && parts[0] == "profiles" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		profiles, err := s.hub.AgentProfiles()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if scope := adminScope(account); scope != "" {
+			profiles, err = s.scopedProfiles(r.Context(), scope, profiles)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		s.writeJSON(w, profiles, http.StatusOK)
+		return
+	}
+
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	agentID := parts[0]
+
+	switch parts[1] {
+	case "status":
+		s.handleAgentStatus(agentID, account, w, r)
+	case "profile":
+		if !s.requireAgentScope(w, r, account, agentID) {
+			return
+		}
+		s.handleAgentProfile(agentID, w, r)
+	default:
+		http.Error(w, "unknown action", http.StatusNotFound)
+	}
+}
+
+// handleAgentStatus reads or sets an agent's status. Admins other than the
+// master agency's only reach agents of their own agency.
+func (s *Server) handleAgentStatus(agentID string, account *auth.Account, w http.ResponseWriter, r *http.Request) {
+	if !s.requireAgentScope(w, r, account, agentID) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, s.hub.AgentStatus(agentID), http.StatusOK)
+	case http.MethodPost:
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		status := strings.ToLower(strings.TrimSpace(payload.Status))
+		record, err := s.hub.SetAgentStatus(agentID, status, account.DisplayName)
+		if err != nil {
+			if errors.Is(err, ws.ErrInvalidAgentStatus) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, record, http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// scopedProfiles keeps the profiles of the agents whose accounts belong to the
+// agency.
+func (s *Server) scopedProfiles(ctx context.Context, agency string, profiles []ws.AgentProfile) ([]ws.AgentProfile, error) {
+	accounts, err := s.auth.ListAccounts(ctx, auth.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	inScope := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		if strings.EqualFold(account.Agency, agency) {
+			inScope[account.Username] = true
+		}
+	}
+	scoped := make([]ws.AgentProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if inScope[profile.AgentID] {
+			scoped = append(scoped, profile)
+		}
+	}
+	return scoped, nil
+}
+
+func (s *Server) handleAgentProfile(agentID string, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profile, err := s.hub.AgentProfile(agentID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, profile, http.StatusOK)
+	case http.MethodPost, http.MethodPut:
+		var payload struct {
+			MaxConcurrent int      `json:"maxConcurrent"`
+			Languages     []string `json:"languages"`
+			Skills        []string `json:"skills"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if payload.MaxConcurrent < 0 {
+			http.Error(w, "maxConcurrent must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := s.hub.SetAgentProfile(ws.AgentProfile{
+			AgentID:       agentID,
+			MaxConcurrent: payload.MaxConcurrent,
+			Languages:     payload.Languages,
+			Skills:        payload.Skills,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, profile, http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+	s.writeJSON(w, s.hub.Metrics(), http.StatusOK)
+}
+
 func (s *Server) handleAgencySettingsCollection(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -265,11 +503,28 @@ func (s *Server) handleAgencySettings(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "settings store not configured", http.StatusServiceUnavailable)
 		return
 	}
-	agency := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/agencies/settings/"), "/")
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/agencies/settings/"), "/"), "/")
+	agency := parts[0]
 	if agency == "" {
 		http.Error(w, "agency required", http.StatusBadRequest)
 		return
 	}
+	if len(parts) > 1 {
+		if parts[1] == "greeting" {
+			s.handleAgencyGreeting(agency, w, r)
+			return
+		}
+		if parts[1] == "bot-rules" {
+			s.handleAgencyBotRules(agency, w, r)
+			return
+		}
+		if parts[1] == "bot-webhook" {
+			s.handleAgencyBotWebhook(agency, w, r)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		if _, ok := s.requireAdmin(w, r); !ok {
@@ -326,172 +581,1491 @@ func (s *Server) handleAgencySettings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	role := r.URL.Query().Get("role")
-	if role == "" {
-		role = ws.RolePlayer
-	}
-	if role != ws.RoleAgent && role != ws.RolePlayer {
-		http.Error(w, "invalid role", http.StatusBadRequest)
-		return
-	}
-
-	roomID := r.URL.Query().Get("roomId")
-	if roomID == "" {
-		http.Error(w, "roomId is required", http.StatusBadRequest)
-		return
-	}
-
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "id is required", http.StatusBadRequest)
-		return
-	}
-
-	displayName := r.URL.Query().Get("name")
-	if displayName == "" {
-		displayName = fmt.Sprintf("%s-%s", role, id)
-	}
-
-	conn, err := s.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	client := ws.NewClient(s.hub, conn, roomID, id, role, displayName)
-	if _, err := s.hub.Register(client); err != nil {
-		_ = conn.Close()
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// handleAgencyGreeting reads or replaces the greeting, away message and
+// pre-chat menu shown to an agency's players.
+func (s *Server) handleAgencyGreeting(agency string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok || !s.requireAgencyScope(w, account, agency) {
 		return
 	}
-
-	go client.WritePump()
-	client.ReadPump()
-}
-
-func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		if _, ok := s.requireAdmin(w, r); !ok {
+		greeting, err := s.hub.Greeting(agency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		rooms := s.hub.Rooms()
-		s.writeJSON(w, rooms, http.StatusOK)
+		s.writeJSON(w, greeting, http.StatusOK)
+	case http.MethodPost, http.MethodPut:
+		var payload struct {
+			Greeting    string             `json:"greeting"`
+			AwayMessage string             `json:"awayMessage"`
+			Menu        []ws.PreChatOption `json:"menu"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		greeting, err := s.hub.SetGreeting(ws.AgencyGreeting{
+			Agency:      agency,
+			Greeting:    payload.Greeting,
+			AwayMessage: payload.AwayMessage,
+			Menu:        payload.Menu,
+		})
+		if err != nil {
+			if errors.Is(err, ws.ErrInvalidGreeting) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, greeting, http.StatusOK)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
-	parts := strings.Split(strings.Trim(path, "/"), "/")
-	if len(parts) == 0 || parts[0] == "" {
-		http.Error(w, "room id missing", http.StatusBadRequest)
-		return
-	}
-
-	roomID := parts[0]
+// botSettings is an agency's bot as shown to admins. The token is only
+// revealed in the response that issued it; otherwise HasToken tells whether
+// the external bot has one.
+type botSettings struct {
+	ws.AgencyBot
+	HasToken bool `json:"hasToken"`
+}
 
-	if len(parts) > 1 {
-		switch parts[1] {
-		case "assign":
-			if r.Method == http.MethodPost {
-				s.handleAssign(roomID, w, r)
-				return
-			}
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		case "messages":
-			s.handleRoomMessages(roomID, w, r)
-			return
-		default:
-			http.Error(w, "unknown action", http.StatusNotFound)
-			return
-		}
+func newBotSettings(bot ws.AgencyBot, revealToken bool) botSettings {
+	settings := botSettings{AgencyBot: bot, HasToken: bot.Token != ""}
+	if !revealToken {
+		settings.Token = ""
 	}
+	return settings
+}
 
+// handleAgencyBotRules reads or replaces the auto-reply rules of an agency.
+func (s *Server) handleAgencyBotRules(agency string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok || !s.requireAgencyScope(w, account, agency) {
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
-		if _, ok := s.requireAdmin(w, r); !ok {
+		bot, err := s.hub.BotRules(agency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		snapshot, err := s.hub.RoomSnapshot(roomID)
+		s.writeJSON(w, newBotSettings(bot, false), http.StatusOK)
+	case http.MethodPost, http.MethodPut:
+		var payload struct {
+			Rules []ws.BotRule `json:"rules"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		bot, err := s.hub.SetBotRules(agency, payload.Rules)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			if errors.Is(err, ws.ErrInvalidBotRule) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		s.writeJSON(w, snapshot, http.StatusOK)
+		s.writeJSON(w, newBotSettings(bot, false), http.StatusOK)
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleAssign(roomID string, w http.ResponseWriter, r *http.Request) {
-	if _, ok := s.requireAdmin(w, r); !ok {
+// handleAgencyBotWebhook registers or removes the external bot of an agency.
+// The response carries the token the bot uses on the bot reply API when it was
+// issued by this request.
+func (s *Server) handleAgencyBotWebhook(agency string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok || !s.requireAgencyScope(w, account, agency) {
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	var payload struct {
-		AgentID     string `json:"agentId"`
-		DisplayName string `json:"displayName"`
+		WebhookURL  string `json:"webhookUrl"`
+		RotateToken bool   `json:"rotateToken"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
-
-	if payload.AgentID == "" {
-		http.Error(w, "agentId is required", http.StatusBadRequest)
+	previous, err := s.hub.BotRules(agency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	if payload.DisplayName == "" {
-		payload.DisplayName = payload.AgentID
-	}
-
-	participant, err := s.hub.AssignAgent(roomID, payload.AgentID, payload.DisplayName)
+	bot, err := s.hub.SetBotWebhook(agency, payload.WebhookURL, payload.RotateToken)
 	if err != nil {
-		if errors.Is(err, ws.ErrRoomNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if errors.Is(err, ws.ErrInvalidBotWebhook) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	s.writeJSON(w, participant, http.StatusOK)
+	s.writeJSON(w, newBotSettings(bot, bot.Token != previous.Token), http.StatusOK)
 }
 
-func (s *Server) handleRoomMessages(roomID string, w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// handleBotReply accepts an external bot's reply for a room it handles. The
+// bot authenticates with its agency's bot token instead of an account.
+func (s *Server) handleBotReply(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/bot/rooms/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "messages" {
+		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-
-	if _, ok := s.requireAdmin(w, r); !ok {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-
-	sinceParam := r.URL.Query().Get("since")
-	var since int64
-	if sinceParam != "" {
-		value, err := strconv.ParseInt(sinceParam, 10, 64)
-		if err != nil {
-			http.Error(w, "invalid since parameter", http.StatusBadRequest)
-			return
-		}
-		if value > 0 {
-			since = value
-		}
+	var payload struct {
+		Content string `json:"content"`
+		Handoff bool   `json:"handoff"`
 	}
-
-	history, nextSeq, err := s.hub.MessagesSince(roomID, since)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	summary, err := s.hub.BotReply(parts[0], s.readToken(r), payload.Content, payload.Handoff)
 	if err != nil {
-		if errors.Is(err, ws.ErrRoomNotFound) {
+		switch {
+		case errors.Is(err, ws.ErrBotUnauthorized):
+			s.writeError(w, http.StatusUnauthorized, "unauthorized")
+		case errors.Is(err, ws.ErrRoomNotFound):
 			http.Error(w, err.Error(), http.StatusNotFound)
-			return
+		case errors.Is(err, ws.ErrBotInactive):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
 		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, summary, http.StatusOK)
+}
+
+// cannedPayload is the editable part of a canned response.
+type cannedPayload struct {
+	Agency   string `json:"agency"`
+	Personal bool   `json:"personal"`
+	Shortcut string `json:"shortcut"`
+	Category string `json:"category"`
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+}
+
+func decodeCannedPayload(w http.ResponseWriter, r *http.Request) (cannedPayload, bool) {
+	var payload cannedPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return payload, false
+	}
+	if strings.TrimSpace(payload.Shortcut) == "" || strings.TrimSpace(payload.Content) == "" {
+		http.Error(w, "shortcut and content are required", http.StatusBadRequest)
+		return payload, false
+	}
+	return payload, true
+}
+
+// handleCannedResponses lists the canned responses available to the calling
+// admin, shared ones of their agency plus their personal ones, or creates one.
+func (s *Server) handleCannedResponses(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		agency := adminScope(account)
+		if agency == "" {
+			agency = r.URL.Query().Get("agency")
+		}
+		items, err := s.hub.CannedResponses(ws.CannedResponseFilter{
+			Agency:   agency,
+			OwnerID:  account.Username,
+			Category: r.URL.Query().Get("category"),
+		})
+		if err != nil {
+			writeCannedError(w, err)
+			return
+		}
+		s.writeJSON(w, items, http.StatusOK)
+	case http.MethodPost:
+		payload, ok := decodeCannedPayload(w, r)
+		if !ok {
+			return
+		}
+		response := ws.CannedResponse{
+			Agency:    account.Agency,
+			Shortcut:  payload.Shortcut,
+			Category:  payload.Category,
+			Title:     payload.Title,
+			Content:   payload.Content,
+			UpdatedBy: account.Username,
+		}
+		if payload.Personal {
+			response.OwnerID = account.Username
+		} else if adminScope(account) == "" {
+			response.Agency = strings.TrimSpace(payload.Agency)
+			if response.Agency == "" {
+				http.Error(w, "agency is required", http.StatusBadRequest)
+				return
+			}
+		}
+		saved, err := s.hub.SaveCannedResponse(response)
+		if err != nil {
+			writeCannedError(w, err)
+			return
+		}
+		s.writeJSON(w, saved, http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCannedResponse reads, updates or deletes one canned response. Personal
+// responses may only be managed by their owner and shared ones by admins of
+// the same agency.
+func (s *Server) handleCannedResponse(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/canned-responses/"), "/"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid canned response id", http.StatusBadRequest)
+		return
+	}
+	existing, err := s.hub.CannedResponse(id)
+	if err != nil {
+		writeCannedError(w, err)
+		return
+	}
+	if !canManageCanned(account, existing) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, existing, http.StatusOK)
+	case http.MethodPut, http.MethodPost:
+		payload, ok := decodeCannedPayload(w, r)
+		if !ok {
+			return
+		}
+		existing.Shortcut = payload.Shortcut
+		existing.Category = payload.Category
+		existing.Title = payload.Title
+		existing.Content = payload.Content
+		existing.UpdatedBy = account.Username
+		saved, err := s.hub.SaveCannedResponse(existing)
+		if err != nil {
+			writeCannedError(w, err)
+			return
+		}
+		s.writeJSON(w, saved, http.StatusOK)
+	case http.MethodDelete:
+		if err := s.hub.DeleteCannedResponse(id); err != nil {
+			writeCannedError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func canManageCanned(account *auth.Account, response ws.CannedResponse) bool {
+	if response.Personal() {
+		return response.OwnerID == account.Username
+	}
+	scope := adminScope(account)
+	return scope == "" || strings.EqualFold(scope, response.Agency)
+}
+
+func writeCannedError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ws.ErrCannedResponseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ws.ErrCannedShortcutTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ws.ErrCannedResponsesDisabled):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleAnnouncements lists the announcements still sent to connecting
+// players, or pushes a new one to the connected players of the admin's agency.
+// Master admins may target one agency or, with an empty agency, all of them.
+func (s *Server) handleAnnouncements(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		agency := adminScope(account)
+		if agency == "" {
+			agency = r.URL.Query().Get("agency")
+		}
+		s.writeJSON(w, s.hub.Announcements(agency), http.StatusOK)
+	case http.MethodPost:
+		var payload struct {
+			Agency     string `json:"agency"`
+			Content    string `json:"content"`
+			TTLSeconds int    `json:"ttlSeconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if payload.TTLSeconds < 0 {
+			http.Error(w, "ttlSeconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		announcement := ws.Announcement{
+			Agency:    adminScope(account),
+			Content:   payload.Content,
+			CreatedBy: account.Username,
+		}
+		if announcement.Agency == "" {
+			announcement.Agency = strings.TrimSpace(payload.Agency)
+		}
+		if payload.TTLSeconds > 0 {
+			announcement.ExpiresAt = time.Now().Add(time.Duration(payload.TTLSeconds) * time.Second)
+		}
+		saved, delivered, err := s.hub.Announce(announcement)
+		if err != nil {
+			if errors.Is(err, ws.ErrInvalidAnnouncement) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSON(w, struct {
+			Announcement ws.Announcement `json:"announcement"`
+			Delivered    int             `json:"delivered"`
+		}{Announcement: saved, Delivered: delivered}, http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAnnouncement cancels an announcement so players who connect later no
+// longer receive it. Announcements for every agency belong to master admins.
+func (s *Server) handleAnnouncement(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/announcements/"), "/")
+	announcement, err := s.hub.Announcement(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if scope := adminScope(account); scope != "" && !strings.EqualFold(scope, announcement.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if err := s.hub.CancelAnnouncement(id); err != nil {
+		if errors.Is(err, ws.ErrAnnouncementNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webhookPayload is the editable part of a webhook subscription.
+type webhookPayload struct {
+	Agency string   `json:"agency"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// handleWebhooks lists or creates the event webhooks of the admin's agency.
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if s.webhooks == nil {
+		http.Error(w, "webhooks not configured", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		agency := adminScope(account)
+		if agency == "" {
+			agency = r.URL.Query().Get("agency")
+		}
+		webhooks, err := s.webhooks.Webhooks(r.Context(), agency)
+		if err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		s.writeJSON(w, webhooks, http.StatusOK)
+	case http.MethodPost:
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		subscription := &storage.WebhookSubscription{
+			Agency:    payload.Agency,
+			URL:       payload.URL,
+			Secret:    payload.Secret,
+			Events:    payload.Events,
+			Active:    payload.Active == nil || *payload.Active,
+			CreatedBy: account.Username,
+		}
+		if scope := adminScope(account); scope != "" {
+			subscription.Agency = scope
+		}
+		if err := s.webhooks.SaveWebhook(r.Context(), subscription); err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		s.writeJSON(w, subscription, http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWebhook manages one webhook subscription: read, update and delete it
+// at /api/webhooks/{id}, list its deliveries at /api/webhooks/{id}/deliveries
+// and redeliver one at /api/webhooks/{id}/deliveries/{deliveryId}/redeliver.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if s.webhooks == nil {
+		http.Error(w, "webhooks not configured", http.StatusServiceUnavailable)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/webhooks/"), "/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	existing, err := s.webhooks.Webhook(r.Context(), id)
+	if err != nil {
+		writeWebhookError(w, err)
+		return
+	}
+	if scope := adminScope(account); scope != "" && !strings.EqualFold(scope, existing.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		s.handleWebhookSubscription(existing, w, r)
+	case len(parts) == 2 && parts[1] == "deliveries":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		deliveries, err := s.webhooks.Deliveries(r.Context(), existing.ID, r.URL.Query().Get("status"), limit)
+		if err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		s.writeJSON(w, deliveries, http.StatusOK)
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "redeliver":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deliveryID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || deliveryID <= 0 {
+			http.Error(w, "invalid delivery id", http.StatusBadRequest)
+			return
+		}
+		delivery, err := s.webhooks.Redeliver(r.Context(), existing.ID, deliveryID)
+		if err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		s.writeJSON(w, delivery, http.StatusAccepted)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// handleWebhookSubscription reads, updates or deletes a subscription. The
+// signing secret is only returned when the subscription is created.
+func (s *Server) handleWebhookSubscription(existing *storage.WebhookSubscription, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		existing.Secret = ""
+		s.writeJSON(w, existing, http.StatusOK)
+	case http.MethodPut, http.MethodPost:
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		existing.URL = payload.URL
+		existing.Events = payload.Events
+		if payload.Secret != "" {
+			existing.Secret = payload.Secret
+		}
+		if payload.Active != nil {
+			existing.Active = *payload.Active
+		}
+		if err := s.webhooks.SaveWebhook(r.Context(), existing); err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		existing.Secret = ""
+		s.writeJSON(w, existing, http.StatusOK)
+	case http.MethodDelete:
+		if err := s.webhooks.DeleteWebhook(r.Context(), existing); err != nil {
+			writeWebhookError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound), errors.Is(err, storage.ErrWebhookDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, webhook.ErrInvalidWebhook):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "observe" {
+		s.handleObserveWebSocket(w, r)
+		return
+	}
+
+	params, err := parseClientParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.authorizeClient(w, r, &params) {
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	socket := ws.NewSocketConn(conn)
+	client := ws.NewClient(s.hub, socket, params.roomID, params.id, params.role, params.displayName)
+	s.configureClient(client, r, params)
+	if _, err := s.hub.Register(client); err != nil {
+		_ = conn.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	socket.Serve(client)
+}
+
+// clientParams are the connection parameters shared by the WebSocket and HTTP
+// fallback transports.
+type clientParams struct {
+	roomID      string
+	id          string
+	role        string
+	displayName string
+	multiplexed bool
+}
+
+func parseClientParams(r *http.Request) (clientParams, error) {
+	query := r.URL.Query()
+	params := clientParams{
+		roomID:      query.Get("roomId"),
+		id:          query.Get("id"),
+		role:        query.Get("role"),
+		displayName: query.Get("name"),
+		multiplexed: query.Get("mode") == "multi",
+	}
+
+	if params.role == "" {
+		params.role = ws.RolePlayer
+	}
+	if params.role != ws.RoleAgent && params.role != ws.RolePlayer {
+		return params, errors.New("invalid role")
+	}
+	if params.multiplexed && params.role != ws.RoleAgent {
+		return params, errors.New("multiplexed connections are for agents only")
+	}
+	if params.roomID == "" && !params.multiplexed {
+		return params, errors.New("roomId is required")
+	}
+	if params.id == "" {
+		return params, errors.New("id is required")
+	}
+	if params.displayName == "" {
+		params.displayName = fmt.Sprintf("%s-%s", params.role, params.id)
+	}
+	return params, nil
+}
+
+// authorizeClient ties a connection to the authenticated account. The client
+// ID is always the account username, agents must be admins of the room's
+// agency and players may only join rooms created for them through
+// POST /api/rooms. Unknown rooms are refused.
+func (s *Server) authorizeClient(w http.ResponseWriter, r *http.Request, params *clientParams) bool {
+	account, err := s.currentAccount(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	params.id = account.Username
+
+	if params.role == ws.RoleAgent {
+		if account.Role != auth.RoleAdmin {
+			s.writeError(w, http.StatusForbidden, "forbidden")
+			return false
+		}
+		if params.multiplexed {
+			// rooms are authorized one by one as the agent subscribes
+			return true
+		}
+		return s.writeRoomAuthorization(w, s.hub.AuthorizeAgent(params.roomID, adminScope(account)))
+	}
+	return s.writeRoomAuthorization(w, s.hub.AuthorizeRoom(params.roomID, account.Agency, account.Username))
+}
+
+// writeRoomAuthorization reports whether a room authorization passed, writing
+// the error response when it did not.
+func (s *Server) writeRoomAuthorization(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ws.ErrRoomNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ws.ErrRoomForbidden):
+		s.writeError(w, http.StatusForbidden, "forbidden")
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+func (s *Server) configureClient(client *ws.Client, r *http.Request, params clientParams) {
+	if account, err := s.currentAccount(r); err == nil {
+		client.Agency = account.Agency
+		if client.Role == ws.RoleAgent {
+			client.Agency = adminScope(account)
+		}
+	}
+	if params.multiplexed {
+		client.RoomID = ""
+		client.Multiplexed = true
+	}
+}
+
+// handleObserveWebSocket lets an admin monitor a room silently. The observer
+// identity comes from the authenticated account rather than query parameters.
+func (s *Server) handleObserveWebSocket(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	roomID := r.URL.Query().Get("roomId")
+	if roomID == "" {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+	if !s.writeRoomAuthorization(w, s.hub.AuthorizeAgent(roomID, adminScope(account))) {
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	socket := ws.NewSocketConn(conn)
+	client := ws.NewClient(s.hub, socket, roomID, account.Username, ws.RoleAgent, account.DisplayName)
+	client.Agency = adminScope(account)
+	client.Observer = true
+	if _, err := s.hub.Register(client); err != nil {
+		_ = conn.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	socket.Serve(client)
+}
+
+// handleAdminEvents streams hub events to the admin console over a WebSocket,
+// limited to the admin's agency unless the admin belongs to the master agency.
+func (s *Server) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.hub.ServeEventStream(conn, s.hub.SubscribeEvents(adminScope(account)))
+}
+
+const (
+	// longPollWait bounds how long a long-poll request waits for messages.
+	longPollWait = 25 * time.Second
+	// sseHeartbeat is how often an idle event stream sends a comment line to
+	// keep proxies from closing it.
+	sseHeartbeat = 20 * time.Second
+	// maxSendSize matches the WebSocket read limit for posted envelopes.
+	maxSendSize = 8192
+)
+
+// openSession registers an HTTP fallback client using the same parameters as
+// the WebSocket endpoint. Observer mode is only offered over WebSocket.
+func (s *Server) openSession(w http.ResponseWriter, r *http.Request) (*ws.Session, bool) {
+	if r.URL.Query().Get("mode") == "observe" {
+		http.Error(w, "observer mode requires a WebSocket connection", http.StatusBadRequest)
+		return nil, false
+	}
+
+	params, err := parseClientParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if !s.authorizeClient(w, r, &params) {
+		return nil, false
+	}
+
+	client := ws.NewSessionClient(s.hub, params.roomID, params.id, params.role, params.displayName)
+	s.configureClient(client, r, params)
+	session, err := s.hub.OpenSession(client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *Server) lookupSession(w http.ResponseWriter, r *http.Request) (*ws.Session, bool) {
+	session, err := s.hub.Session(r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+// handleEventSource is the Server-Sent Events fallback for clients whose
+// network blocks WebSocket upgrades. The first event carries the session ID
+// used to post messages to /ws/send; the session closes with the stream.
+func (s *Server) handleEventSource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.openSession(w, r)
+	if !ok {
+		return
+	}
+	defer s.hub.CloseSession(session.ID)
+
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "event: session\ndata: {\"sessionId\":%q}\n\n", session.ID); err != nil {
+		return
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	for {
+		messages, err := session.Poll(r.Context(), sseHeartbeat)
+		if err != nil {
+			return
+		}
+		if len(messages) == 0 {
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		for _, message := range messages {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// handleSession opens (POST) or closes (DELETE) a long-polling session.
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		session, ok := s.openSession(w, r)
+		if !ok {
+			return
+		}
+		s.writeJSON(w, map[string]string{"sessionId": session.ID}, http.StatusCreated)
+	case http.MethodDelete:
+		s.hub.CloseSession(r.URL.Query().Get("session"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePoll waits for messages on a long-polling session. An empty list
+// means the wait timed out and the client should poll again.
+func (s *Server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(longPollWait + 10*time.Second))
+	messages, err := session.Poll(r.Context(), longPollWait)
+	if errors.Is(err, ws.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if messages == nil {
+		messages = []json.RawMessage{}
+	}
+	s.writeJSON(w, map[string]any{"messages": messages}, http.StatusOK)
+}
+
+// handleSessionSend accepts an upstream envelope for an SSE or long-polling
+// session and processes it exactly like a WebSocket frame.
+func (s *Server) handleSessionSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.lookupSession(w, r)
+	if !ok {
+		return
+	}
+
+	var envelope ws.Envelope
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSendSize)).Decode(&envelope); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := session.Handle(envelope); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// adminScope returns the agency an admin may see, or empty for every agency.
+func adminScope(account *auth.Account) string {
+	if account.Agency == auth.MasterAgency {
+		return ""
+	}
+	return account.Agency
+}
+
+// requireAgencyScope checks that the admin may manage the agency, writing the
+// error response when they may not.
+func (s *Server) requireAgencyScope(w http.ResponseWriter, account *auth.Account, agency string) bool {
+	if scope := adminScope(account); scope != "" && !strings.EqualFold(scope, agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// requireAgentScope checks that the agent's account belongs to an agency the
+// admin may manage, writing the error response when it does not.
+func (s *Server) requireAgentScope(w http.ResponseWriter, r *http.Request, account *auth.Account, agentID string) bool {
+	if adminScope(account) == "" {
+		return true
+	}
+	agent, err := s.auth.Account(r.Context(), agentID)
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountNotFound) {
+			http.Error(w, "agent not found", http.StatusNotFound)
+			return false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return s.requireAgencyScope(w, account, agent.Agency)
+}
+
+// requireRoomScope checks that the room exists and belongs to an agency the
+// admin may manage, writing the error response when it does not.
+func (s *Server) requireRoomScope(w http.ResponseWriter, account *auth.Account, roomID string) bool {
+	snapshot, err := s.hub.RoomSnapshot(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if scope := adminScope(account); scope != "" && !strings.EqualFold(scope, snapshot.Summary.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := s.requireAdmin(w, r); !ok {
+			return
+		}
+		rooms := s.hub.Rooms()
+		if statusParam := r.URL.Query().Get("status"); statusParam != "" {
+			statuses := make(map[string]bool)
+			for _, status := range strings.Split(statusParam, ",") {
+				status = strings.ToLower(strings.TrimSpace(status))
+				if !ws.ValidRoomStatus(status) {
+					http.Error(w, "invalid status parameter", http.StatusBadRequest)
+					return
+				}
+				statuses[status] = true
+			}
+			filtered := make([]ws.RoomSummary, 0, len(rooms))
+			for _, room := range rooms {
+				if statuses[room.Status] {
+					filtered = append(filtered, room)
+				}
+			}
+			rooms = filtered
+		}
+		s.writeJSON(w, rooms, http.StatusOK)
+	case http.MethodPost:
+		s.handleCreateRoom(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOutreach lets an agent start a conversation with a player account. The
+// opening message waits in the player's room until they next connect.
+func (s *Server) handleOutreach(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		PlayerID string `json:"playerId"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	playerID := strings.ToLower(strings.TrimSpace(payload.PlayerID))
+	if playerID == "" {
+		http.Error(w, "playerId is required", http.StatusBadRequest)
+		return
+	}
+	player, err := s.auth.Account(r.Context(), playerID)
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if player.Role != auth.RolePlayer {
+		http.Error(w, "account is not a player", http.StatusBadRequest)
+		return
+	}
+	if scope := adminScope(account); scope != "" && !strings.EqualFold(scope, player.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	room, message, err := s.hub.Outreach(player.Agency, player.Username, account.Username, account.DisplayName, payload.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, ws.ErrRoomAssignedToOther), errors.Is(err, ws.ErrAgentUnavailable),
+			errors.Is(err, ws.ErrAgentOverCapacity), errors.Is(err, ws.ErrAgentMissingSkill):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	s.writeJSON(w, struct {
+		Room    ws.RoomSummary `json:"room"`
+		Message ws.ChatMessage `json:"message"`
+	}{Room: room, Message: message}, http.StatusCreated)
+}
+
+// handleCreateRoom returns the caller's active room in their agency, creating
+// it with a server-generated ID when there is none.
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	account, err := s.currentAccount(r)
+	if err != nil {
+		s.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	summary, created, err := s.hub.CreateRoom(account.Agency, account.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	s.writeJSON(w, summary, status)
+}
+
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 0 || parts[0] == "" {
+		http.Error(w, "room id missing", http.StatusBadRequest)
+		return
+	}
+
+	roomID := parts[0]
+
+	if len(parts) > 1 {
+		switch parts[1] {
+		case "assign":
+			if r.Method == http.MethodPost {
+				s.handleAssign(roomID, w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case "messages":
+			s.handleRoomMessages(roomID, w, r)
+			return
+		case "status":
+			if r.Method == http.MethodPost {
+				s.handleRoomStatus(roomID, w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case "transfer":
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if len(parts) > 2 && parts[2] == "accept" {
+				s.handleAcceptTransfer(roomID, w, r)
+				return
+			}
+			s.handleTransfer(roomID, w, r)
+			return
+		case "assignments":
+			if r.Method == http.MethodGet {
+				s.handleAssignments(roomID, w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case "skills":
+			if r.Method == http.MethodPost {
+				s.handleRoomSkills(roomID, w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case "priority":
+			if r.Method == http.MethodPost {
+				s.handleRoomPriority(roomID, w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		case "scheduled-messages":
+			if len(parts) > 2 {
+				s.handleScheduledMessage(roomID, parts[2], w, r)
+				return
+			}
+			s.handleScheduledMessages(roomID, w, r)
+			return
+		default:
+			http.Error(w, "unknown action", http.StatusNotFound)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := s.requireAdmin(w, r); !ok {
+			return
+		}
+		snapshot, err := s.hub.RoomSnapshot(roomID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeJSON(w, snapshot, http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleAssign(roomID string, w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+	var payload struct {
+		AgentID     string `json:"agentId"`
+		DisplayName string `json:"displayName"`
+		Force       bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if payload.AgentID == "" {
+		http.Error(w, "agentId is required", http.StatusBadRequest)
+		return
+	}
+
+	if payload.DisplayName == "" {
+		payload.DisplayName = payload.AgentID
+	}
+
+	var (
+		participant *ws.Participant
+		warnings    []string
+		err         error
+	)
+	if payload.Force {
+		participant, warnings, err = s.hub.ForceAssignAgent(roomID, payload.AgentID, payload.DisplayName)
+	} else {
+		participant, err = s.hub.AssignAgent(roomID, payload.AgentID, payload.DisplayName)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ws.ErrRoomNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ws.ErrAgentUnavailable), errors.Is(err, ws.ErrAgentOverCapacity), errors.Is(err, ws.ErrAgentMissingSkill):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	s.writeJSON(w, struct {
+		*ws.Participant
+		Warnings []string `json:"warnings,omitempty"`
+	}{participant, warnings}, http.StatusOK)
+}
+
+// handleQueue lists the rooms waiting for an agent in the order they should
+// be served, limited to the admin's agency.
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	s.writeJSON(w, s.hub.Queue(adminScope(account)), http.StatusOK)
+}
+
+func (s *Server) handleRoomPriority(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	var payload struct {
+		Priority int `json:"priority"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := s.hub.SetRoomPriority(roomID, payload.Priority)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, summary, http.StatusOK)
+}
+
+func (s *Server) handleRoomSkills(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	var payload struct {
+		Skills []string `json:"skills"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := s.hub.SetRoomSkills(roomID, payload.Skills)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, summary, http.StatusOK)
+}
+
+func (s *Server) handleTransfer(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	var payload struct {
+		ToAgentID string `json:"toAgentId"`
+		ToAgent   string `json:"toAgent"`
+		Note      string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	toAgentID := strings.TrimSpace(payload.ToAgentID)
+	if toAgentID != "" && !s.requireAgentScope(w, r, account, toAgentID) {
+		return
+	}
+	transfer, err := s.hub.TransferRoom(roomID, ws.TransferRequest{
+		ToAgentID:   toAgentID,
+		ToAgent:     strings.TrimSpace(payload.ToAgent),
+		Note:        strings.TrimSpace(payload.Note),
+		RequestedBy: account.DisplayName,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ws.ErrRoomNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ws.ErrAgentUnavailable), errors.Is(err, ws.ErrAgentOverCapacity), errors.Is(err, ws.ErrAgentMissingSkill):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	if transfer == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(w, transfer, http.StatusAccepted)
+}
+
+// handleAcceptTransfer accepts a pending transfer as the caller, or on behalf
+// of the agent named in the payload when that agent belongs to the caller's
+// agency.
+func (s *Server) handleAcceptTransfer(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	var payload struct {
+		AgentID string `json:"agentId"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	agentID := strings.TrimSpace(payload.AgentID)
+	if agentID == "" {
+		agentID = account.Username
+	} else if agentID != account.Username && !s.requireAgentScope(w, r, account, agentID) {
+		return
+	}
+
+	participant, err := s.hub.AcceptTransfer(roomID, agentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ws.ErrRoomNotFound), errors.Is(err, ws.ErrNoPendingTransfer):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ws.ErrTransferNotForAgent):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ws.ErrAgentUnavailable), errors.Is(err, ws.ErrAgentOverCapacity), errors.Is(err, ws.ErrAgentMissingSkill):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	s.writeJSON(w, participant, http.StatusOK)
+}
+
+func (s *Server) handleAssignments(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	assignments, err := s.hub.Assignments(roomID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, assignments, http.StatusOK)
+}
+
+func (s *Server) handleRoomStatus(roomID string, w http.ResponseWriter, r *http.Request) {
+	account, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if !s.requireRoomScope(w, account, roomID) {
+		return
+	}
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	status := strings.ToLower(strings.TrimSpace(payload.Status))
+	summary, err := s.hub.SetRoomStatus(roomID, status, account.Username, string(account.Role), account.DisplayName)
+	if err != nil {
+		switch {
+		case errors.Is(err, ws.ErrRoomNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, ws.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	s.writeJSON(w, summary, http.StatusOK)
+}
+
+func (s *Server) handleRoomMessages(roomID string, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handlePostRoomMessage(roomID, w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	if beforeParam := r.URL.Query().Get("before"); beforeParam != "" {
+		s.handleRoomMessagesBefore(roomID, beforeParam, w, r)
+		return
+	}
+
+	sinceParam := r.URL.Query().Get("since")
+	var since int64
+	if sinceParam != "" {
+		value, err := strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since parameter", http.StatusBadRequest)
+			return
+		}
+		if value > 0 {
+			since = value
+		}
+	}
+
+	history, nextSeq, err := s.hub.MessagesSince(roomID, since)
+	if err != nil {
+		if errors.Is(err, ws.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -501,6 +2075,231 @@ func (s *Server) handleRoomMessages(roomID string, w http.ResponseWriter, r *htt
 	}{Messages: history, NextSeq: nextSeq}, http.StatusOK)
 }
 
+// handlePostRoomMessage appends a chat message or system notice to a room for
+// admins and backends, returning the stored message.
+func (s *Server) handlePostRoomMessage(roomID string, w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.requireAdminOrAPIKey(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Type        string            `json:"type"`
+		Content     string            `json:"content"`
+		DisplayName string            `json:"displayName"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	snapshot, err := s.hub.RoomSnapshot(roomID)
+	if err != nil {
+		if errors.Is(err, ws.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !caller.canAccess(snapshot.Summary.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	displayName := strings.TrimSpace(payload.DisplayName)
+	if displayName == "" {
+		displayName = caller.DisplayName
+	}
+	stored, err := s.hub.PostMessage(roomID, ws.ChatMessage{
+		Type:        strings.TrimSpace(payload.Type),
+		SenderID:    caller.ID,
+		DisplayName: displayName,
+		Content:     payload.Content,
+		Metadata:    postedMetadata(payload.Metadata),
+	})
+	if err != nil {
+		if errors.Is(err, ws.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, stored, http.StatusCreated)
+}
+
+// postedMetadataKeys lists the metadata admins and backends may attach to the
+// messages they post. Flags such as greeting, announcement or outreach are only
+// set by the hub.
+var postedMetadataKeys = []string{"source", "reference"}
+
+func postedMetadata(metadata map[string]string) map[string]string {
+	var allowed map[string]string
+	for _, key := range postedMetadataKeys {
+		if value := strings.TrimSpace(metadata[key]); value != "" {
+			if allowed == nil {
+				allowed = make(map[string]string)
+			}
+			allowed[key] = value
+		}
+	}
+	return allowed
+}
+
+// handleScheduledMessages lists the scheduled messages of a room or schedules
+// a new one, sent as the caller at sendAt or after delaySeconds.
+func (s *Server) handleScheduledMessages(roomID string, w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.requireAdminOrAPIKey(w, r)
+	if !ok {
+		return
+	}
+	snapshot, err := s.hub.RoomSnapshot(roomID)
+	if err != nil {
+		if errors.Is(err, ws.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !caller.canAccess(snapshot.Summary.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = storage.ScheduledMessagePending
+		} else if status == "all" {
+			status = ""
+		}
+		messages, err := s.scheduler.Scheduled(r.Context(), roomID, status)
+		if err != nil {
+			writeScheduledError(w, err)
+			return
+		}
+		s.writeJSON(w, messages, http.StatusOK)
+	case http.MethodPost:
+		var payload struct {
+			Content      string    `json:"content"`
+			DisplayName  string    `json:"displayName"`
+			SendAt       time.Time `json:"sendAt"`
+			DelaySeconds int       `json:"delaySeconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		sendAt := payload.SendAt
+		if sendAt.IsZero() && payload.DelaySeconds > 0 {
+			sendAt = time.Now().Add(time.Duration(payload.DelaySeconds) * time.Second)
+		}
+		displayName := strings.TrimSpace(payload.DisplayName)
+		if displayName == "" {
+			displayName = caller.DisplayName
+		}
+		message := &storage.ScheduledMessage{
+			RoomID:      roomID,
+			Agency:      snapshot.Summary.Agency,
+			SenderID:    caller.ID,
+			DisplayName: displayName,
+			Content:     payload.Content,
+			SendAt:      sendAt,
+		}
+		if err := s.scheduler.Schedule(r.Context(), message); err != nil {
+			writeScheduledError(w, err)
+			return
+		}
+		s.writeJSON(w, message, http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScheduledMessage reads or cancels one scheduled message of a room.
+func (s *Server) handleScheduledMessage(roomID, idParam string, w http.ResponseWriter, r *http.Request) {
+	caller, ok := s.requireAdminOrAPIKey(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid scheduled message id", http.StatusBadRequest)
+		return
+	}
+	message, err := s.scheduler.Message(r.Context(), roomID, id)
+	if err != nil {
+		writeScheduledError(w, err)
+		return
+	}
+	if !caller.canAccess(message.Agency) {
+		s.writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, message, http.StatusOK)
+	case http.MethodDelete:
+		if err := s.scheduler.Cancel(r.Context(), message); err != nil {
+			writeScheduledError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeScheduledError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrScheduledMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrScheduledMessageNotPending):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, scheduler.ErrInvalidScheduledMessage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleRoomMessagesBefore(roomID, beforeParam string, w http.ResponseWriter, r *http.Request) {
+	before, err := strconv.ParseInt(beforeParam, 10, 64)
+	if err != nil || before <= 0 {
+		http.Error(w, "invalid before parameter", http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		value, err := strconv.Atoi(limitParam)
+		if err != nil || value <= 0 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = value
+	}
+
+	history, hasMore, nextSeq, err := s.hub.MessagesBefore(roomID, before, limit)
+	if err != nil {
+		if errors.Is(err, ws.ErrRoomNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, struct {
+		Messages []ws.ChatMessage `json:"messages"`
+		NextSeq  int64            `json:"nextSeq"`
+		HasMore  bool             `json:"hasMore"`
+	}{Messages: history, NextSeq: nextSeq, HasMore: hasMore}, http.StatusOK)
+}
+
 func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
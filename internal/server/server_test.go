@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"im/internal/auth"
+	"im/internal/ws"
+)
+
+type memoryAccountRepo struct {
+	mu       sync.RWMutex
+	accounts map[string]auth.AccountRecord
+}
+
+func (r *memoryAccountRepo) CreateAccount(_ context.Context, record *auth.AccountRecord) (*auth.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.accounts[record.Username]; exists {
+		return nil, auth.ErrAccountExists
+	}
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	r.accounts[record.Username] = *record
+	account := record.Account
+	return &account, nil
+}
+
+func (r *memoryAccountRepo) FindByUsername(_ context.Context, username string) (*auth.AccountRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	record, ok := r.accounts[strings.ToLower(strings.TrimSpace(username))]
+	if !ok {
+		return nil, auth.ErrAccountNotFound
+	}
+	return &record, nil
+}
+
+func (r *memoryAccountRepo) ListAccounts(_ context.Context, role auth.Role) ([]auth.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	accounts := make([]auth.Account, 0, len(r.accounts))
+	for _, record := range r.accounts {
+		if role == "" || record.Role == role {
+			accounts = append(accounts, record.Account)
+		}
+	}
+	return accounts, nil
+}
+
+type memoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func (s *memoryTokenStore) SaveToken(_ context.Context, token, subject string, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = subject
+	return nil
+}
+
+func (s *memoryTokenStore) DeleteToken(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
+
+func (s *memoryTokenStore) LookupSubject(_ context.Context, token string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subject, ok := s.tokens[token]
+	if !ok {
+		return "", errors.New("token missing")
+	}
+	return subject, nil
+}
+
+type testServer struct {
+	hub     *ws.Hub
+	auth    *auth.Manager
+	handler http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	manager, err := auth.NewManager(
+		&memoryAccountRepo{accounts: make(map[string]auth.AccountRecord)},
+		&memoryTokenStore{tokens: make(map[string]string)},
+		"test-secret", "test-suite", time.Hour,
+	)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	hub := ws.NewHub()
+	return &testServer{hub: hub, auth: manager, handler: New(hub, manager, nil, nil, nil, nil, t.TempDir()).Handler()}
+}
+
+// createAdmin creates an admin account in the agency, created by the
+// bootstrap admin, and returns its token.
+func (s *testServer) createAdmin(t *testing.T, agency, username string) string {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.auth.CreateAccount(ctx, "admin01", auth.RoleAdmin, agency, username, "pass", username); err != nil {
+		t.Fatalf("create admin %s failed: %v", username, err)
+	}
+	return s.login(t, username, "pass")
+}
+
+func (s *testServer) login(t *testing.T, username, password string) string {
+	t.Helper()
+	token, _, err := s.auth.Login(context.Background(), username, password)
+	if err != nil {
+		t.Fatalf("login %s failed: %v", username, err)
+	}
+	return token
+}
+
+func (s *testServer) do(t *testing.T, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) connectAgent(t *testing.T, id, agency string) {
+	t.Helper()
+	agent := ws.NewClient(s.hub, ws.NewMemoryConn(10), "", id, ws.RoleAgent, id)
+	agent.Agency = agency
+	agent.Multiplexed = true
+	if _, err := s.hub.Register(agent); err != nil {
+		t.Fatalf("register agent %s failed: %v", id, err)
+	}
+}
+
+func TestAgentStatusScopedByAgency(t *testing.T) {
+	server := newTestServer(t)
+	leadA := server.createAdmin(t, "agency-a", "lead-a")
+	server.createAdmin(t, "agency-a", "agent-a")
+	server.createAdmin(t, "agency-b", "agent-b")
+	master := server.login(t, "admin01", "admin01pass")
+	server.connectAgent(t, "agent-a", "agency-a")
+	server.connectAgent(t, "agent-b", "agency-b")
+
+	if rec := server.do(t, http.MethodPost, "/api/agents/agent-a/status", leadA, `{"status":"away"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected an admin to set the status of their agency's agent, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := server.do(t, http.MethodPost, "/api/agents/agent-b/status", leadA, `{"status":"away"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another agency's agent to be refused, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := server.do(t, http.MethodGet, "/api/agents/agent-b/status", leadA, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another agency's agent status to be hidden, got %d %s", rec.Code, rec.Body)
+	}
+	if status := server.hub.AgentStatus("agent-b"); status.Status == ws.AgentStatusAway {
+		t.Fatalf("expected the refused change not to apply, got %+v", status)
+	}
+	if rec := server.do(t, http.MethodPost, "/api/agents/agent-b/status", master, `{"status":"busy"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the master admin to reach every agency, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec := server.do(t, http.MethodGet, "/api/agents/online", leadA, "")
+	var online []ws.AgentPresence
+	if err := json.Unmarshal(rec.Body.Bytes(), &online); err != nil {
+		t.Fatalf("decode online agents: %v (%s)", err, rec.Body)
+	}
+	if len(online) != 1 || online[0].ID != "agent-a" {
+		t.Fatalf("expected only the admin's agency to be listed, got %+v", online)
+	}
+	rec = server.do(t, http.MethodGet, "/api/agents/online", master, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &online); err != nil || len(online) != 2 {
+		t.Fatalf("expected the master admin to see every agent, got %+v %v", online, err)
+	}
+}
+
+func TestAgentProfilesAndRoomSkillsScopedByAgency(t *testing.T) {
+	server := newTestServer(t)
+	leadA := server.createAdmin(t, "agency-a", "lead-a")
+	server.createAdmin(t, "agency-a", "agent-a")
+	server.createAdmin(t, "agency-b", "agent-b")
+	for _, agentID := range []string{"agent-a", "agent-b"} {
+		if _, err := server.hub.SetAgentProfile(ws.AgentProfile{AgentID: agentID, MaxConcurrent: 3}); err != nil {
+			t.Fatalf("set profile failed: %v", err)
+		}
+	}
+
+	if rec := server.do(t, http.MethodPut, "/api/agents/agent-a/profile", leadA, `{"maxConcurrent":5,"skills":["vip"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected an admin to edit their agency's agent, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := server.do(t, http.MethodPut, "/api/agents/agent-b/profile", leadA, `{"maxConcurrent":1}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another agency's agent to be refused, got %d %s", rec.Code, rec.Body)
+	}
+	if profile, _ := server.hub.AgentProfile("agent-b"); profile.MaxConcurrent != 3 {
+		t.Fatalf("expected the refused change not to apply, got %+v", profile)
+	}
+	rec := server.do(t, http.MethodGet, "/api/agents/profiles", leadA, "")
+	var profiles []ws.AgentProfile
+	if err := json.Unmarshal(rec.Body.Bytes(), &profiles); err != nil || len(profiles) != 1 || profiles[0].AgentID != "agent-a" {
+		t.Fatalf("expected only the admin's agency profiles, got %+v %v", profiles, err)
+	}
+
+	roomB, _, err := server.hub.CreateRoom("agency-b", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if rec := server.do(t, http.MethodPost, "/api/rooms/"+roomB.RoomID+"/skills", leadA, `{"skills":["vip"]}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another agency's room to be refused, got %d %s", rec.Code, rec.Body)
+	}
+	if snapshot, _ := server.hub.RoomSnapshot(roomB.RoomID); len(snapshot.Summary.RequiredSkills) != 0 {
+		t.Fatalf("expected the refused change not to apply, got %+v", snapshot.Summary)
+	}
+}
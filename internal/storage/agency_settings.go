@@ -3,9 +3,12 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"strings"
 	"time"
+
+	"im/internal/ws"
 )
 
 type AgencyAPISettings struct {
@@ -94,3 +97,109 @@ func (r *AgencySettingsRepository) Upsert(ctx context.Context, settings *AgencyA
 	)
 	return err
 }
+
+// SaveGreeting stores the agency's greeting, away message and pre-chat menu in
+// the same row as its API settings.
+func (r *AgencySettingsRepository) SaveGreeting(ctx context.Context, greeting ws.AgencyGreeting) error {
+	if r.db == nil {
+		return errors.New("agency settings repository: db is nil")
+	}
+	normalized := strings.TrimSpace(strings.ToLower(greeting.Agency))
+	if normalized == "" {
+		return errors.New("agency is required")
+	}
+	menu, err := json.Marshal(greeting.Menu)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO agency_settings (agency, charge_api, withdraw_api, bet_api, player_info_api, greeting, away_message, pre_chat_menu)
+        VALUES (?, '', '', '', '', ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            greeting = VALUES(greeting),
+            away_message = VALUES(away_message),
+            pre_chat_menu = VALUES(pre_chat_menu)`,
+		normalized,
+		greeting.Greeting,
+		greeting.AwayMessage,
+		string(menu),
+	)
+	return err
+}
+
+// SaveBotRules stores the agency's auto-reply rules as JSON, and its external
+// bot registration, in the same row as its API settings.
+func (r *AgencySettingsRepository) SaveBotRules(ctx context.Context, bot ws.AgencyBot) error {
+	if r.db == nil {
+		return errors.New("agency settings repository: db is nil")
+	}
+	normalized := strings.TrimSpace(strings.ToLower(bot.Agency))
+	if normalized == "" {
+		return errors.New("agency is required")
+	}
+	rules, err := json.Marshal(bot.Rules)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO agency_settings (agency, charge_api, withdraw_api, bet_api, player_info_api, bot_rules, bot_webhook_url, bot_token)
+        VALUES (?, '', '', '', '', ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            bot_rules = VALUES(bot_rules),
+            bot_webhook_url = VALUES(bot_webhook_url),
+            bot_token = VALUES(bot_token)`,
+		normalized,
+		string(rules),
+		bot.WebhookURL,
+		bot.Token,
+	)
+	return err
+}
+
+func (r *AgencySettingsRepository) LoadBotRules(ctx context.Context, agency string) (*ws.AgencyBot, error) {
+	if r.db == nil {
+		return nil, errors.New("agency settings repository: db is nil")
+	}
+	normalized := strings.TrimSpace(strings.ToLower(agency))
+	row := r.db.QueryRowContext(ctx, `SELECT agency, bot_rules, bot_webhook_url, bot_token, updated_at FROM agency_settings WHERE agency = ? LIMIT 1`, normalized)
+	var bot ws.AgencyBot
+	var rules, webhookURL, token sql.NullString
+	if err := row.Scan(&bot.Agency, &rules, &webhookURL, &token, &bot.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	bot.WebhookURL = webhookURL.String
+	bot.Token = token.String
+	bot.Rules = []ws.BotRule{}
+	if rules.Valid && rules.String != "" {
+		if err := json.Unmarshal([]byte(rules.String), &bot.Rules); err != nil {
+			return nil, err
+		}
+	}
+	return &bot, nil
+}
+
+func (r *AgencySettingsRepository) LoadGreeting(ctx context.Context, agency string) (*ws.AgencyGreeting, error) {
+	if r.db == nil {
+		return nil, errors.New("agency settings repository: db is nil")
+	}
+	normalized := strings.TrimSpace(strings.ToLower(agency))
+	row := r.db.QueryRowContext(ctx, `SELECT agency, greeting, away_message, pre_chat_menu, updated_at FROM agency_settings WHERE agency = ? LIMIT 1`, normalized)
+	var greeting ws.AgencyGreeting
+	var text, away, menu sql.NullString
+	if err := row.Scan(&greeting.Agency, &text, &away, &menu, &greeting.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	greeting.Greeting = text.String
+	greeting.AwayMessage = away.String
+	greeting.Menu = []ws.PreChatOption{}
+	if menu.Valid && menu.String != "" {
+		if err := json.Unmarshal([]byte(menu.String), &greeting.Menu); err != nil {
+			return nil, err
+		}
+	}
+	return &greeting, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"im/internal/ws"
+)
+
+type AgentProfileRepository struct {
+	db *sql.DB
+}
+
+func NewAgentProfileRepository(db *sql.DB) *AgentProfileRepository {
+	return &AgentProfileRepository{db: db}
+}
+
+func (r *AgentProfileRepository) SaveAgentProfile(ctx context.Context, profile ws.AgentProfile) error {
+	if r.db == nil {
+		return errors.New("agent profile repository: db is nil")
+	}
+	languages, err := json.Marshal(profile.Languages)
+	if err != nil {
+		return err
+	}
+	skills, err := json.Marshal(profile.Skills)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO agent_profiles (agent_id, max_concurrent, languages, skills, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            max_concurrent = VALUES(max_concurrent),
+            languages = VALUES(languages),
+            skills = VALUES(skills),
+            updated_at = VALUES(updated_at)`,
+		profile.AgentID,
+		profile.MaxConcurrent,
+		string(languages),
+		string(skills),
+		profile.UpdatedAt,
+	)
+	return err
+}
+
+func (r *AgentProfileRepository) LoadAgentProfile(ctx context.Context, agentID string) (*ws.AgentProfile, error) {
+	if r.db == nil {
+		return nil, errors.New("agent profile repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT agent_id, max_concurrent, languages, skills, updated_at FROM agent_profiles WHERE agent_id = ? LIMIT 1`, agentID)
+	profile, err := scanAgentProfile(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (r *AgentProfileRepository) ListAgentProfiles(ctx context.Context) ([]ws.AgentProfile, error) {
+	if r.db == nil {
+		return nil, errors.New("agent profile repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT agent_id, max_concurrent, languages, skills, updated_at FROM agent_profiles ORDER BY agent_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := make([]ws.AgentProfile, 0)
+	for rows.Next() {
+		profile, err := scanAgentProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+	return profiles, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAgentProfile(row rowScanner) (*ws.AgentProfile, error) {
+	var profile ws.AgentProfile
+	var languages, skills sql.NullString
+	if err := row.Scan(&profile.AgentID, &profile.MaxConcurrent, &languages, &skills, &profile.UpdatedAt); err != nil {
+		return nil, err
+	}
+	profile.Languages = []string{}
+	profile.Skills = []string{}
+	if languages.Valid && languages.String != "" {
+		if err := json.Unmarshal([]byte(languages.String), &profile.Languages); err != nil {
+			return nil, err
+		}
+	}
+	if skills.Valid && skills.String != "" {
+		if err := json.Unmarshal([]byte(skills.String), &profile.Skills); err != nil {
+			return nil, err
+		}
+	}
+	return &profile, nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"im/internal/ws"
+)
+
+type AgentStatusRepository struct {
+	db *sql.DB
+}
+
+func NewAgentStatusRepository(db *sql.DB) *AgentStatusRepository {
+	return &AgentStatusRepository{db: db}
+}
+
+// SaveAgentStatus stores the agent's current status and appends the change to
+// agent_status_log for availability reporting.
+func (r *AgentStatusRepository) SaveAgentStatus(ctx context.Context, status ws.AgentStatus) error {
+	if r.db == nil {
+		return errors.New("agent status repository: db is nil")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO agent_status (agent_id, status, changed_by, changed_at)
+        VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            status = VALUES(status),
+            changed_by = VALUES(changed_by),
+            changed_at = VALUES(changed_at)`,
+		status.AgentID,
+		status.Status,
+		status.ChangedBy,
+		status.ChangedAt,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO agent_status_log (agent_id, status, changed_by, changed_at) VALUES (?, ?, ?, ?)`,
+		status.AgentID,
+		status.Status,
+		status.ChangedBy,
+		status.ChangedAt,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *AgentStatusRepository) LoadAgentStatus(ctx context.Context, agentID string) (*ws.AgentStatus, error) {
+	if r.db == nil {
+		return nil, errors.New("agent status repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT agent_id, status, changed_by, changed_at FROM agent_status WHERE agent_id = ? LIMIT 1`, agentID)
+	var status ws.AgentStatus
+	if err := row.Scan(&status.AgentID, &status.Status, &status.ChangedBy, &status.ChangedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &status, nil
+}
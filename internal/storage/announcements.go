@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"im/internal/ws"
+)
+
+type AnnouncementRepository struct {
+	db *sql.DB
+}
+
+func NewAnnouncementRepository(db *sql.DB) *AnnouncementRepository {
+	return &AnnouncementRepository{db: db}
+}
+
+func (r *AnnouncementRepository) SaveAnnouncement(ctx context.Context, announcement ws.Announcement) error {
+	if r.db == nil {
+		return errors.New("announcement repository: db is nil")
+	}
+	_, err := r.db.ExecContext(ctx, `INSERT INTO announcements (id, agency, content, created_by, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		announcement.ID,
+		announcement.Agency,
+		announcement.Content,
+		announcement.CreatedBy,
+		announcement.CreatedAt,
+		announcement.ExpiresAt,
+	)
+	return err
+}
+
+func (r *AnnouncementRepository) LoadActiveAnnouncements(ctx context.Context, now time.Time) ([]ws.Announcement, error) {
+	if r.db == nil {
+		return nil, errors.New("announcement repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id, agency, content, created_by, created_at, expires_at FROM announcements
+        WHERE expires_at > ?
+        ORDER BY created_at`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := make([]ws.Announcement, 0)
+	for rows.Next() {
+		var announcement ws.Announcement
+		if err := rows.Scan(&announcement.ID, &announcement.Agency, &announcement.Content, &announcement.CreatedBy, &announcement.CreatedAt, &announcement.ExpiresAt); err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, announcement)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return announcements, nil
+}
+
+func (r *AnnouncementRepository) DeleteAnnouncement(ctx context.Context, id string) error {
+	if r.db == nil {
+		return errors.New("announcement repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `DELETE FROM announcements WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ws.ErrAnnouncementNotFound
+	}
+	return nil
+}
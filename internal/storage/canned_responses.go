@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"im/internal/ws"
+)
+
+type CannedResponseRepository struct {
+	db *sql.DB
+}
+
+func NewCannedResponseRepository(db *sql.DB) *CannedResponseRepository {
+	return &CannedResponseRepository{db: db}
+}
+
+func (r *CannedResponseRepository) SaveCannedResponse(ctx context.Context, response ws.CannedResponse) (ws.CannedResponse, error) {
+	if r.db == nil {
+		return ws.CannedResponse{}, errors.New("canned response repository: db is nil")
+	}
+	if response.ID == 0 {
+		result, err := r.db.ExecContext(ctx, `INSERT INTO canned_responses (agency, owner_id, shortcut, category, title, content, updated_by, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			response.Agency,
+			response.OwnerID,
+			response.Shortcut,
+			response.Category,
+			response.Title,
+			response.Content,
+			response.UpdatedBy,
+			response.UpdatedAt,
+		)
+		if err != nil {
+			if isDuplicateErr(err) {
+				return ws.CannedResponse{}, ws.ErrCannedShortcutTaken
+			}
+			return ws.CannedResponse{}, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return ws.CannedResponse{}, err
+		}
+		response.ID = id
+		return response, nil
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE canned_responses
+        SET agency = ?, owner_id = ?, shortcut = ?, category = ?, title = ?, content = ?, updated_by = ?, updated_at = ?
+        WHERE id = ?`,
+		response.Agency,
+		response.OwnerID,
+		response.Shortcut,
+		response.Category,
+		response.Title,
+		response.Content,
+		response.UpdatedBy,
+		response.UpdatedAt,
+		response.ID,
+	)
+	if err != nil {
+		if isDuplicateErr(err) {
+			return ws.CannedResponse{}, ws.ErrCannedShortcutTaken
+		}
+		return ws.CannedResponse{}, err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		existing, err := r.LoadCannedResponse(ctx, response.ID)
+		if err != nil {
+			return ws.CannedResponse{}, err
+		}
+		if existing == nil {
+			return ws.CannedResponse{}, ws.ErrCannedResponseNotFound
+		}
+	}
+	return response, nil
+}
+
+func (r *CannedResponseRepository) LoadCannedResponse(ctx context.Context, id int64) (*ws.CannedResponse, error) {
+	if r.db == nil {
+		return nil, errors.New("canned response repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT id, agency, owner_id, shortcut, category, title, content, updated_by, updated_at FROM canned_responses WHERE id = ? LIMIT 1`, id)
+	response, err := scanCannedResponse(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return response, nil
+}
+
+func (r *CannedResponseRepository) ListCannedResponses(ctx context.Context, filter ws.CannedResponseFilter) ([]ws.CannedResponse, error) {
+	if r.db == nil {
+		return nil, errors.New("canned response repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id, agency, owner_id, shortcut, category, title, content, updated_by, updated_at FROM canned_responses
+        WHERE ((owner_id = '' AND (? = '' OR agency = ?)) OR (owner_id <> '' AND owner_id = ?))
+            AND (? = '' OR category = ?)
+        ORDER BY category, shortcut, id`,
+		filter.Agency, filter.Agency,
+		filter.OwnerID,
+		filter.Category, filter.Category,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	responses := make([]ws.CannedResponse, 0)
+	for rows.Next() {
+		response, err := scanCannedResponse(rows)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, *response)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return responses, nil
+}
+
+func (r *CannedResponseRepository) DeleteCannedResponse(ctx context.Context, id int64) error {
+	if r.db == nil {
+		return errors.New("canned response repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `DELETE FROM canned_responses WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ws.ErrCannedResponseNotFound
+	}
+	return nil
+}
+
+func scanCannedResponse(row rowScanner) (*ws.CannedResponse, error) {
+	var response ws.CannedResponse
+	if err := row.Scan(&response.ID, &response.Agency, &response.OwnerID, &response.Shortcut, &response.Category, &response.Title, &response.Content, &response.UpdatedBy, &response.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
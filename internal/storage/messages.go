@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"im/internal/ws"
+)
+
+type MessageRepository struct {
+	db *sql.DB
+}
+
+func NewMessageRepository(db *sql.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+func (r *MessageRepository) SaveMessage(ctx context.Context, msg ws.ChatMessage) error {
+	if r.db == nil {
+		return errors.New("message repository: db is nil")
+	}
+	metadata, err := encodeMetadata(msg.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO chat_messages (room_id, sequence, message_type, sender_id, sender_role, display_name, content, metadata, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            content = VALUES(content),
+            metadata = VALUES(metadata)`,
+		msg.RoomID,
+		msg.Sequence,
+		msg.Type,
+		msg.SenderID,
+		msg.SenderRole,
+		msg.DisplayName,
+		msg.Content,
+		metadata,
+		msg.Timestamp,
+	)
+	return err
+}
+
+// MessagesBefore returns up to limit messages older than the sequence, oldest first.
+func (r *MessageRepository) MessagesBefore(ctx context.Context, roomID string, before int64, limit int) ([]ws.ChatMessage, error) {
+	if r.db == nil {
+		return nil, errors.New("message repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT room_id, sequence, message_type, sender_id, sender_role, display_name, content, metadata, created_at
+        FROM chat_messages WHERE room_id = ? AND sequence < ? ORDER BY sequence DESC LIMIT ?`, roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []ws.ChatMessage
+	for rows.Next() {
+		var msg ws.ChatMessage
+		var metadata sql.NullString
+		if err := rows.Scan(&msg.RoomID, &msg.Sequence, &msg.Type, &msg.SenderID, &msg.SenderRole, &msg.DisplayName, &msg.Content, &metadata, &msg.Timestamp); err != nil {
+			return nil, err
+		}
+		if metadata.Valid && metadata.String != "" {
+			if err := json.Unmarshal([]byte(metadata.String), &msg.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (r *MessageRepository) LatestSequence(ctx context.Context, roomID string) (int64, error) {
+	if r.db == nil {
+		return 0, errors.New("message repository: db is nil")
+	}
+	var latest sql.NullInt64
+	row := r.db.QueryRowContext(ctx, `SELECT MAX(sequence) FROM chat_messages WHERE room_id = ?`, roomID)
+	if err := row.Scan(&latest); err != nil {
+		return 0, err
+	}
+	return latest.Int64, nil
+}
+
+func encodeMetadata(metadata map[string]string) (sql.NullString, error) {
+	if len(metadata) == 0 {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
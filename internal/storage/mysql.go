@@ -62,7 +62,134 @@ func (m *MySQL) migrate(ctx context.Context) error {
             withdraw_api TEXT,
             bet_api TEXT,
             player_info_api TEXT,
+            greeting TEXT,
+            away_message TEXT,
+            pre_chat_menu TEXT,
+            bot_rules TEXT,
+            bot_webhook_url TEXT,
+            bot_token VARCHAR(128),
             updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS chat_messages (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            room_id VARCHAR(191) NOT NULL,
+            sequence BIGINT NOT NULL,
+            message_type VARCHAR(32) NOT NULL DEFAULT 'chat.message',
+            sender_id VARCHAR(191) NOT NULL,
+            sender_role VARCHAR(32) NOT NULL,
+            display_name VARCHAR(255) NOT NULL,
+            content TEXT NOT NULL,
+            metadata TEXT,
+            created_at TIMESTAMP(3) NOT NULL,
+            UNIQUE KEY uniq_room_sequence (room_id, sequence)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS chat_rooms (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            room_id VARCHAR(191) NOT NULL UNIQUE,
+            agency VARCHAR(64) NOT NULL DEFAULT '',
+            owner_id VARCHAR(191) NOT NULL DEFAULT '',
+            created_at TIMESTAMP(3) NOT NULL,
+            last_activity TIMESTAMP(3) NOT NULL,
+            next_sequence BIGINT NOT NULL DEFAULT 0,
+            assigned_agent_id VARCHAR(191) NOT NULL DEFAULT '',
+            assigned_agent_name VARCHAR(255) NOT NULL DEFAULT '',
+            status VARCHAR(16) NOT NULL DEFAULT 'open',
+            status_changed_at TIMESTAMP(3) NULL,
+            assignments TEXT,
+            required_skills TEXT,
+            priority INT NOT NULL DEFAULT 0,
+            priority_override TINYINT(1) NOT NULL DEFAULT 0,
+            queued_at TIMESTAMP(3) NULL,
+            left_message_at TIMESTAMP(3) NULL,
+            pending_outreach INT NOT NULL DEFAULT 0,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+            KEY idx_owner (agency, owner_id, status)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS agent_status (
+            agent_id VARCHAR(191) NOT NULL PRIMARY KEY,
+            status VARCHAR(16) NOT NULL,
+            changed_by VARCHAR(255) NOT NULL DEFAULT '',
+            changed_at TIMESTAMP(3) NOT NULL
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS agent_profiles (
+            agent_id VARCHAR(191) NOT NULL PRIMARY KEY,
+            max_concurrent INT NOT NULL DEFAULT 0,
+            languages TEXT,
+            skills TEXT,
+            updated_at TIMESTAMP(3) NOT NULL
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS agent_status_log (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            agent_id VARCHAR(191) NOT NULL,
+            status VARCHAR(16) NOT NULL,
+            changed_by VARCHAR(255) NOT NULL DEFAULT '',
+            changed_at TIMESTAMP(3) NOT NULL,
+            KEY idx_agent_changed (agent_id, changed_at)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS canned_responses (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            agency VARCHAR(64) NOT NULL DEFAULT '',
+            owner_id VARCHAR(191) NOT NULL DEFAULT '',
+            shortcut VARCHAR(64) NOT NULL,
+            category VARCHAR(64) NOT NULL DEFAULT '',
+            title VARCHAR(255) NOT NULL DEFAULT '',
+            content TEXT NOT NULL,
+            updated_by VARCHAR(191) NOT NULL DEFAULT '',
+            updated_at TIMESTAMP(3) NOT NULL,
+            UNIQUE KEY uniq_scope_shortcut (agency, owner_id, shortcut),
+            KEY idx_owner (owner_id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS announcements (
+            id VARCHAR(32) NOT NULL PRIMARY KEY,
+            agency VARCHAR(64) NOT NULL DEFAULT '',
+            content TEXT NOT NULL,
+            created_by VARCHAR(191) NOT NULL DEFAULT '',
+            created_at TIMESTAMP(3) NOT NULL,
+            expires_at TIMESTAMP(3) NOT NULL,
+            KEY idx_expires (expires_at)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            agency VARCHAR(64) NOT NULL,
+            url TEXT NOT NULL,
+            secret VARCHAR(128) NOT NULL,
+            events VARCHAR(255) NOT NULL,
+            active TINYINT(1) NOT NULL DEFAULT 1,
+            created_by VARCHAR(191) NOT NULL DEFAULT '',
+            created_at TIMESTAMP(3) NOT NULL,
+            updated_at TIMESTAMP(3) NOT NULL,
+            KEY idx_agency (agency)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            subscription_id BIGINT NOT NULL,
+            event_type VARCHAR(64) NOT NULL,
+            payload MEDIUMTEXT NOT NULL,
+            status VARCHAR(16) NOT NULL,
+            attempts INT NOT NULL DEFAULT 0,
+            response_status INT NOT NULL DEFAULT 0,
+            last_error TEXT NOT NULL,
+            next_attempt_at TIMESTAMP(3) NOT NULL,
+            created_at TIMESTAMP(3) NOT NULL,
+            delivered_at TIMESTAMP(3) NULL,
+            KEY idx_due (status, next_attempt_at),
+            KEY idx_subscription (subscription_id, status)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+            id BIGINT AUTO_INCREMENT PRIMARY KEY,
+            room_id VARCHAR(191) NOT NULL,
+            agency VARCHAR(64) NOT NULL DEFAULT '',
+            sender_id VARCHAR(191) NOT NULL,
+            display_name VARCHAR(255) NOT NULL DEFAULT '',
+            content TEXT NOT NULL,
+            send_at TIMESTAMP(3) NOT NULL,
+            status VARCHAR(16) NOT NULL,
+            last_error TEXT NOT NULL,
+            sequence BIGINT NOT NULL DEFAULT 0,
+            created_at TIMESTAMP(3) NOT NULL,
+            sent_at TIMESTAMP(3) NULL,
+            KEY idx_due (status, send_at),
+            KEY idx_room (room_id, send_at)
         ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
 	}
 
@@ -71,5 +198,44 @@ func (m *MySQL) migrate(ctx context.Context) error {
 			return fmt.Errorf("mysql migrate: %w", err)
 		}
 	}
+
+	// CREATE TABLE IF NOT EXISTS leaves tables from earlier releases as they
+	// were, so columns added since are created here when missing.
+	columns := []tableColumn{
+		{"agency_settings", "greeting", "TEXT"},
+		{"agency_settings", "away_message", "TEXT"},
+		{"agency_settings", "pre_chat_menu", "TEXT"},
+		{"agency_settings", "bot_rules", "TEXT"},
+		{"agency_settings", "bot_webhook_url", "TEXT"},
+		{"agency_settings", "bot_token", "VARCHAR(128)"},
+	}
+	for _, column := range columns {
+		if err := m.addColumn(ctx, column); err != nil {
+			return fmt.Errorf("mysql migrate: %w", err)
+		}
+	}
 	return nil
 }
+
+type tableColumn struct {
+	table      string
+	name       string
+	definition string
+}
+
+// addColumn adds the column unless the table already has it.
+func (m *MySQL) addColumn(ctx context.Context, column tableColumn) error {
+	var count int
+	err := m.DB.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`,
+		column.table, column.name).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err = m.DB.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.name, column.definition))
+	return err
+}
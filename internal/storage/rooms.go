@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"im/internal/ws"
+)
+
+type RoomRepository struct {
+	db *sql.DB
+}
+
+func NewRoomRepository(db *sql.DB) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+func (r *RoomRepository) SaveRoomState(ctx context.Context, state ws.RoomState) error {
+	if r.db == nil {
+		return errors.New("room repository: db is nil")
+	}
+	assignments, err := json.Marshal(state.Assignments)
+	if err != nil {
+		return err
+	}
+	skills, err := json.Marshal(state.RequiredSkills)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO chat_rooms (room_id, agency, owner_id, created_at, last_activity, next_sequence, assigned_agent_id, assigned_agent_name, status, status_changed_at, assignments, required_skills, priority, priority_override, queued_at, left_message_at, pending_outreach)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            agency = VALUES(agency),
+            owner_id = VALUES(owner_id),
+            last_activity = VALUES(last_activity),
+            next_sequence = VALUES(next_sequence),
+            assigned_agent_id = VALUES(assigned_agent_id),
+            assigned_agent_name = VALUES(assigned_agent_name),
+            status = VALUES(status),
+            status_changed_at = VALUES(status_changed_at),
+            assignments = VALUES(assignments),
+            required_skills = VALUES(required_skills),
+            priority = VALUES(priority),
+            priority_override = VALUES(priority_override),
+            queued_at = VALUES(queued_at),
+            left_message_at = VALUES(left_message_at),
+            pending_outreach = VALUES(pending_outreach)`,
+		state.RoomID,
+		state.Agency,
+		state.OwnerID,
+		state.CreatedAt,
+		state.LastActivity,
+		state.NextSequence,
+		state.AssignedAgentID,
+		state.AssignedAgentName,
+		state.Status,
+		state.StatusChangedAt,
+		string(assignments),
+		string(skills),
+		state.Priority,
+		state.PriorityOverride,
+		nullTime(state.QueuedAt),
+		nullTime(state.LeftMessageAt),
+		state.PendingOutreach,
+	)
+	return err
+}
+
+func (r *RoomRepository) LoadRoomState(ctx context.Context, roomID string) (*ws.RoomState, error) {
+	if r.db == nil {
+		return nil, errors.New("room repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT `+roomStateColumns+` FROM chat_rooms WHERE room_id = ? LIMIT 1`, roomID)
+	state, err := scanRoomState(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return state, err
+}
+
+func (r *RoomRepository) LoadActiveRoomState(ctx context.Context, agency, ownerID string) (*ws.RoomState, error) {
+	if r.db == nil {
+		return nil, errors.New("room repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT `+roomStateColumns+` FROM chat_rooms WHERE agency = ? AND owner_id = ? AND status <> ? ORDER BY last_activity DESC LIMIT 1`, agency, ownerID, ws.RoomStatusClosed)
+	state, err := scanRoomState(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return state, err
+}
+
+func (r *RoomRepository) LoadInactiveRoomStates(ctx context.Context, before time.Time, limit int) ([]ws.RoomState, error) {
+	if r.db == nil {
+		return nil, errors.New("room repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+roomStateColumns+` FROM chat_rooms WHERE status <> ? AND last_activity < ? ORDER BY last_activity LIMIT ?`, ws.RoomStatusClosed, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make([]ws.RoomState, 0)
+	for rows.Next() {
+		state, err := scanRoomState(rows)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, *state)
+	}
+	return states, rows.Err()
+}
+
+func (r *RoomRepository) ActiveRoomIDs(ctx context.Context, agentID string) ([]string, error) {
+	if r.db == nil {
+		return nil, errors.New("room repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT room_id FROM chat_rooms WHERE assigned_agent_id = ? AND status IN (?, ?)`, agentID, ws.RoomStatusOpen, ws.RoomStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const roomStateColumns = `room_id, agency, owner_id, created_at, last_activity, next_sequence, assigned_agent_id, assigned_agent_name, status, status_changed_at, assignments, required_skills, priority, priority_override, queued_at, left_message_at, pending_outreach`
+
+func scanRoomState(row rowScanner) (*ws.RoomState, error) {
+	var state ws.RoomState
+	var statusChangedAt sql.NullTime
+	var assignments sql.NullString
+	var skills sql.NullString
+	var queuedAt sql.NullTime
+	var leftMessageAt sql.NullTime
+	if err := row.Scan(&state.RoomID, &state.Agency, &state.OwnerID, &state.CreatedAt, &state.LastActivity, &state.NextSequence, &state.AssignedAgentID, &state.AssignedAgentName, &state.Status, &statusChangedAt, &assignments, &skills, &state.Priority, &state.PriorityOverride, &queuedAt, &leftMessageAt, &state.PendingOutreach); err != nil {
+		return nil, err
+	}
+	if statusChangedAt.Valid {
+		state.StatusChangedAt = statusChangedAt.Time
+	}
+	if queuedAt.Valid {
+		state.QueuedAt = queuedAt.Time
+	}
+	if leftMessageAt.Valid {
+		state.LeftMessageAt = leftMessageAt.Time
+	}
+	if assignments.Valid && assignments.String != "" {
+		if err := json.Unmarshal([]byte(assignments.String), &state.Assignments); err != nil {
+			return nil, err
+		}
+	}
+	if skills.Valid && skills.String != "" {
+		if err := json.Unmarshal([]byte(skills.String), &state.RequiredSkills); err != nil {
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Scheduled message states. A message leaves the pending state exactly once,
+// so a message is never both cancelled and sent. It is sending from the moment
+// the scheduler claims it until the outcome of posting it is recorded.
+const (
+	ScheduledMessagePending   = "pending"
+	ScheduledMessageSending   = "sending"
+	ScheduledMessageSent      = "sent"
+	ScheduledMessageCancelled = "cancelled"
+	ScheduledMessageFailed    = "failed"
+)
+
+var (
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+	// ErrScheduledMessageNotPending is returned when a scheduled message was
+	// already sent, cancelled or failed.
+	ErrScheduledMessageNotPending = errors.New("scheduled message is not pending")
+)
+
+// ScheduledMessage is a chat message an agent wrote to be sent into a room at
+// SendAt.
+type ScheduledMessage struct {
+	ID          int64      `json:"id"`
+	RoomID      string     `json:"roomId"`
+	Agency      string     `json:"agency"`
+	SenderID    string     `json:"senderId"`
+	DisplayName string     `json:"displayName"`
+	Content     string     `json:"content"`
+	SendAt      time.Time  `json:"sendAt"`
+	Status      string     `json:"status"`
+	LastError   string     `json:"lastError,omitempty"`
+	Sequence    int64      `json:"sequence,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	SentAt      *time.Time `json:"sentAt,omitempty"`
+}
+
+type ScheduledMessageStore interface {
+	CreateScheduledMessage(ctx context.Context, message *ScheduledMessage) error
+	GetScheduledMessage(ctx context.Context, id int64) (*ScheduledMessage, error)
+	// ListScheduledMessages lists the messages of a room by send time,
+	// optionally only those in the given status.
+	ListScheduledMessages(ctx context.Context, roomID, status string) ([]ScheduledMessage, error)
+	// DueScheduledMessages lists pending messages whose send time has come.
+	DueScheduledMessages(ctx context.Context, now time.Time, limit int) ([]ScheduledMessage, error)
+	// SendingScheduledMessages lists messages left in the sending state,
+	// which happens when the server stopped while posting them.
+	SendingScheduledMessages(ctx context.Context, limit int) ([]ScheduledMessage, error)
+	// FinishScheduledMessage stores the new status of a pending message. It
+	// returns ErrScheduledMessageNotPending when the message already left the
+	// pending state.
+	FinishScheduledMessage(ctx context.Context, message *ScheduledMessage) error
+	// UpdateScheduledMessage records the outcome of a message that has
+	// already left the pending state.
+	UpdateScheduledMessage(ctx context.Context, message *ScheduledMessage) error
+}
+
+type ScheduledMessageRepository struct {
+	db *sql.DB
+}
+
+func NewScheduledMessageRepository(db *sql.DB) *ScheduledMessageRepository {
+	return &ScheduledMessageRepository{db: db}
+}
+
+const scheduledMessageColumns = `id, room_id, agency, sender_id, display_name, content, send_at, status, last_error, sequence, created_at, sent_at`
+
+func (r *ScheduledMessageRepository) CreateScheduledMessage(ctx context.Context, message *ScheduledMessage) error {
+	if r.db == nil {
+		return errors.New("scheduled message repository: db is nil")
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	result, err := r.db.ExecContext(ctx, `INSERT INTO scheduled_messages (room_id, agency, sender_id, display_name, content, send_at, status, last_error, sequence, created_at, sent_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		message.RoomID,
+		message.Agency,
+		message.SenderID,
+		message.DisplayName,
+		message.Content,
+		message.SendAt,
+		message.Status,
+		message.LastError,
+		message.Sequence,
+		message.CreatedAt,
+		message.SentAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	message.ID = id
+	return nil
+}
+
+func (r *ScheduledMessageRepository) GetScheduledMessage(ctx context.Context, id int64) (*ScheduledMessage, error) {
+	if r.db == nil {
+		return nil, errors.New("scheduled message repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT `+scheduledMessageColumns+` FROM scheduled_messages WHERE id = ? LIMIT 1`, id)
+	message, err := scanScheduledMessage(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduledMessageNotFound
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+func (r *ScheduledMessageRepository) ListScheduledMessages(ctx context.Context, roomID, status string) ([]ScheduledMessage, error) {
+	if r.db == nil {
+		return nil, errors.New("scheduled message repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+        WHERE room_id = ? AND (? = '' OR status = ?)
+        ORDER BY send_at, id`,
+		roomID,
+		status, status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectScheduledMessages(rows)
+}
+
+func (r *ScheduledMessageRepository) DueScheduledMessages(ctx context.Context, now time.Time, limit int) ([]ScheduledMessage, error) {
+	if r.db == nil {
+		return nil, errors.New("scheduled message repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+        WHERE status = ? AND send_at <= ?
+        ORDER BY send_at, id
+        LIMIT ?`,
+		ScheduledMessagePending,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectScheduledMessages(rows)
+}
+
+func (r *ScheduledMessageRepository) SendingScheduledMessages(ctx context.Context, limit int) ([]ScheduledMessage, error) {
+	if r.db == nil {
+		return nil, errors.New("scheduled message repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+        WHERE status = ?
+        ORDER BY send_at, id
+        LIMIT ?`,
+		ScheduledMessageSending,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectScheduledMessages(rows)
+}
+
+func (r *ScheduledMessageRepository) FinishScheduledMessage(ctx context.Context, message *ScheduledMessage) error {
+	if r.db == nil {
+		return errors.New("scheduled message repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE scheduled_messages
+        SET status = ?, last_error = ?, sequence = ?, sent_at = ?
+        WHERE id = ? AND status = ?`,
+		message.Status,
+		message.LastError,
+		message.Sequence,
+		message.SentAt,
+		message.ID,
+		ScheduledMessagePending,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := r.GetScheduledMessage(ctx, message.ID); err != nil {
+			return err
+		}
+		return ErrScheduledMessageNotPending
+	}
+	return nil
+}
+
+func (r *ScheduledMessageRepository) UpdateScheduledMessage(ctx context.Context, message *ScheduledMessage) error {
+	if r.db == nil {
+		return errors.New("scheduled message repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE scheduled_messages
+        SET status = ?, last_error = ?, sequence = ?, sent_at = ?
+        WHERE id = ?`,
+		message.Status,
+		message.LastError,
+		message.Sequence,
+		message.SentAt,
+		message.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		if _, err := r.GetScheduledMessage(ctx, message.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectScheduledMessages(rows *sql.Rows) ([]ScheduledMessage, error) {
+	defer rows.Close()
+
+	messages := make([]ScheduledMessage, 0)
+	for rows.Next() {
+		message, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func scanScheduledMessage(row rowScanner) (*ScheduledMessage, error) {
+	var message ScheduledMessage
+	var sentAt sql.NullTime
+	if err := row.Scan(&message.ID, &message.RoomID, &message.Agency, &message.SenderID, &message.DisplayName, &message.Content, &message.SendAt, &message.Status, &message.LastError, &message.Sequence, &message.CreatedAt, &sentAt); err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		sent := sentAt.Time
+		message.SentAt = &sent
+	}
+	return &message, nil
+}
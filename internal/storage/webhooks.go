@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Webhook delivery states. Failed deliveries have used up their retries and
+// form the dead-letter log until they are redelivered.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// WebhookSubscription sends the listed hub events of an agency to URL, signed
+// with Secret.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	Agency    string    `json:"agency"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Accepts reports whether the subscription wants the event type.
+func (s WebhookSubscription) Accepts(eventType string) bool {
+	for _, event := range s.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a subscription.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscriptionId"`
+	EventType      string     `json:"eventType"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+type WebhookStore interface {
+	// ListWebhooks lists the subscriptions of an agency, or of every agency
+	// when the agency is empty.
+	ListWebhooks(ctx context.Context, agency string) ([]WebhookSubscription, error)
+	GetWebhook(ctx context.Context, id int64) (*WebhookSubscription, error)
+	// SaveWebhook inserts the subscription when its ID is zero and updates it
+	// otherwise.
+	SaveWebhook(ctx context.Context, webhook *WebhookSubscription) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error)
+	// ListDeliveries lists the latest deliveries of a subscription, optionally
+	// only those in the given status.
+	ListDeliveries(ctx context.Context, subscriptionID int64, status string, limit int) ([]WebhookDelivery, error)
+	// DueDeliveries lists pending deliveries whose next attempt is due.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+}
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const webhookColumns = `id, agency, url, secret, events, active, created_by, created_at, updated_at`
+
+func (r *WebhookRepository) ListWebhooks(ctx context.Context, agency string) ([]WebhookSubscription, error) {
+	if r.db == nil {
+		return nil, errors.New("webhook repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions
+        WHERE (? = '' OR agency = ?)
+        ORDER BY agency, id`,
+		agency, agency,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := make([]WebhookSubscription, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) GetWebhook(ctx context.Context, id int64) (*WebhookSubscription, error) {
+	if r.db == nil {
+		return nil, errors.New("webhook repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = ? LIMIT 1`, id)
+	webhook, err := scanWebhook(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (r *WebhookRepository) SaveWebhook(ctx context.Context, webhook *WebhookSubscription) error {
+	if r.db == nil {
+		return errors.New("webhook repository: db is nil")
+	}
+	now := time.Now()
+	webhook.UpdatedAt = now
+	events := strings.Join(webhook.Events, ",")
+	if webhook.ID == 0 {
+		webhook.CreatedAt = now
+		result, err := r.db.ExecContext(ctx, `INSERT INTO webhook_subscriptions (agency, url, secret, events, active, created_by, created_at, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			webhook.Agency,
+			webhook.URL,
+			webhook.Secret,
+			events,
+			webhook.Active,
+			webhook.CreatedBy,
+			webhook.CreatedAt,
+			webhook.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		webhook.ID = id
+		return nil
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE webhook_subscriptions SET url = ?, secret = ?, events = ?, active = ?, updated_at = ? WHERE id = ?`,
+		webhook.URL,
+		webhook.Secret,
+		events,
+		webhook.Active,
+		webhook.UpdatedAt,
+		webhook.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		if _, err := r.GetWebhook(ctx, webhook.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *WebhookRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	if r.db == nil {
+		return errors.New("webhook repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebhookNotFound
+	}
+	_, err = r.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE subscription_id = ?`, id)
+	return err
+}
+
+const deliveryColumns = `id, subscription_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, delivered_at`
+
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if r.db == nil {
+		return errors.New("webhook repository: db is nil")
+	}
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now()
+	}
+	result, err := r.db.ExecContext(ctx, `INSERT INTO webhook_deliveries (subscription_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, created_at, delivered_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		delivery.SubscriptionID,
+		delivery.EventType,
+		delivery.Payload,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+		delivery.DeliveredAt,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	return nil
+}
+
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if r.db == nil {
+		return errors.New("webhook repository: db is nil")
+	}
+	result, err := r.db.ExecContext(ctx, `UPDATE webhook_deliveries
+        SET status = ?, attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+        WHERE id = ?`,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.LastError,
+		delivery.NextAttemptAt,
+		delivery.DeliveredAt,
+		delivery.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		if _, err := r.GetDelivery(ctx, delivery.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	if r.db == nil {
+		return nil, errors.New("webhook repository: db is nil")
+	}
+	row := r.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ? LIMIT 1`, id)
+	delivery, err := scanDelivery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, subscriptionID int64, status string, limit int) ([]WebhookDelivery, error) {
+	if r.db == nil {
+		return nil, errors.New("webhook repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+        WHERE subscription_id = ? AND (? = '' OR status = ?)
+        ORDER BY id DESC
+        LIMIT ?`,
+		subscriptionID,
+		status, status,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectDeliveries(rows)
+}
+
+func (r *WebhookRepository) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	if r.db == nil {
+		return nil, errors.New("webhook repository: db is nil")
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+        WHERE status = ? AND next_attempt_at <= ?
+        ORDER BY next_attempt_at, id
+        LIMIT ?`,
+		WebhookDeliveryPending,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return collectDeliveries(rows)
+}
+
+func collectDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func scanWebhook(row rowScanner) (*WebhookSubscription, error) {
+	var webhook WebhookSubscription
+	var events string
+	if err := row.Scan(&webhook.ID, &webhook.Agency, &webhook.URL, &webhook.Secret, &events, &webhook.Active, &webhook.CreatedBy, &webhook.CreatedAt, &webhook.UpdatedAt); err != nil {
+		return nil, err
+	}
+	webhook.Events = make([]string, 0)
+	for _, event := range strings.Split(events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+	return &webhook, nil
+}
+
+func scanDelivery(row rowScanner) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var deliveredAt sql.NullTime
+	if err := row.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventType, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.ResponseStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt, &deliveredAt); err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		delivered := deliveredAt.Time
+		delivery.DeliveredAt = &delivered
+	}
+	return &delivery, nil
+}
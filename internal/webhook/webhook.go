@@ -0,0 +1,440 @@
+// Package webhook delivers hub events to the HTTP endpoints agencies subscribe,
+// signing each request and retrying failed deliveries with backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"im/internal/storage"
+	"im/internal/ws"
+)
+
+// Request headers sent with every delivery. The signature is
+// "sha256=" followed by the hex HMAC-SHA256 of "{timestamp}.{body}" keyed by
+// the subscription secret.
+const (
+	EventHeader     = "X-IM-Event"
+	DeliveryHeader  = "X-IM-Delivery"
+	TimestampHeader = "X-IM-Timestamp"
+	SignatureHeader = "X-IM-Signature"
+)
+
+const (
+	// MaxAttempts is the number of attempts before a delivery is moved to
+	// the dead-letter log.
+	MaxAttempts = 6
+
+	baseBackoff     = 30 * time.Second
+	maxBackoff      = 30 * time.Minute
+	pollInterval    = 10 * time.Second
+	deliveryTimeout = 10 * time.Second
+	storeTimeout    = 5 * time.Second
+	dueBatchSize    = 50
+)
+
+// Events lists the hub events agencies can subscribe to.
+var Events = []string{
+	ws.EventRoomCreated,
+	ws.EventMessageCreated,
+	ws.EventRoomAssigned,
+	ws.EventRoomClosed,
+}
+
+// ErrInvalidWebhook is returned when a subscription has no valid URL or
+// events.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Dispatcher turns hub events into stored deliveries and sends them. Because
+// deliveries are stored before they are attempted, pending retries resume
+// after a restart.
+type Dispatcher struct {
+	store  storage.WebhookStore
+	client *http.Client
+	wake   chan struct{}
+
+	mu       sync.RWMutex
+	webhooks map[string][]storage.WebhookSubscription
+}
+
+func NewDispatcher(store storage.WebhookStore) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		client:   &http.Client{Timeout: deliveryTimeout},
+		wake:     make(chan struct{}, 1),
+		webhooks: make(map[string][]storage.WebhookSubscription),
+	}
+}
+
+// eventPayload is the JSON body of a delivery.
+type eventPayload struct {
+	Event     string             `json:"event"`
+	Agency    string             `json:"agency"`
+	RoomID    string             `json:"roomId,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+	Room      *ws.RoomSummary    `json:"room,omitempty"`
+	Message   *ws.MessageSummary `json:"message,omitempty"`
+}
+
+// Run delivers the hub's events until ctx is cancelled. It subscribes to the
+// hub's event queue so no event is dropped while deliveries are stored.
+func (d *Dispatcher) Run(ctx context.Context, hub *ws.Hub) {
+	sub := hub.SubscribeEventQueue("")
+	defer hub.UnsubscribeEvents(sub)
+	go d.deliverLoop(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			d.enqueue(ctx, evt)
+		}
+	}
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, evt ws.HubEvent) {
+	if !supportedEvent(evt.Type) || evt.Agency == "" || !externalEvent(evt) {
+		return
+	}
+	webhooks, err := d.subscriptions(ctx, evt.Agency)
+	if err != nil {
+		log.Printf("load agency %s webhooks: %v", evt.Agency, err)
+		return
+	}
+	var body []byte
+	for _, webhook := range webhooks {
+		if !webhook.Active || !webhook.Accepts(evt.Type) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(eventPayload{
+				Event:     evt.Type,
+				Agency:    evt.Agency,
+				RoomID:    evt.RoomID,
+				Timestamp: evt.Timestamp,
+				Room:      evt.Room,
+				Message:   evt.Message,
+			})
+			if err != nil {
+				log.Printf("encode %s webhook payload: %v", evt.Type, err)
+				return
+			}
+		}
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		err := d.store.CreateDelivery(storeCtx, &storage.WebhookDelivery{
+			SubscriptionID: webhook.ID,
+			EventType:      evt.Type,
+			Payload:        string(body),
+			Status:         storage.WebhookDeliveryPending,
+			NextAttemptAt:  time.Now(),
+		})
+		cancel()
+		if err != nil {
+			log.Printf("store webhook %d delivery: %v", webhook.ID, err)
+		}
+	}
+	if body != nil {
+		d.notify()
+	}
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) deliverLoop(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	d.deliverDue(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		d.deliverDue(ctx)
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		due, err := d.store.DueDeliveries(storeCtx, time.Now(), dueBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("load due webhook deliveries: %v", err)
+			return
+		}
+		for i := range due {
+			if err := d.attempt(ctx, &due[i]); err != nil {
+				// leave the rest for the next poll rather than retry the
+				// same due rows while the store is failing
+				log.Printf("webhook delivery %d: %v", due[i].ID, err)
+				return
+			}
+		}
+		if len(due) < dueBatchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and records the outcome. Failures are retried
+// with exponential backoff until MaxAttempts is reached. It returns the store
+// errors that left the delivery unchanged.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *storage.WebhookDelivery) error {
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	webhook, err := d.store.GetWebhook(storeCtx, delivery.SubscriptionID)
+	cancel()
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		return d.finish(ctx, delivery, storage.WebhookDeliveryFailed, "webhook removed")
+	case err != nil:
+		return fmt.Errorf("load webhook %d: %w", delivery.SubscriptionID, err)
+	case !webhook.Active:
+		return d.finish(ctx, delivery, storage.WebhookDeliveryFailed, "webhook inactive")
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	status, sendErr := d.send(ctx, webhook, delivery, now)
+	delivery.ResponseStatus = status
+	if sendErr == nil {
+		delivery.DeliveredAt = &now
+		return d.finish(ctx, delivery, storage.WebhookDeliveryDelivered, "")
+	}
+	if delivery.Attempts >= MaxAttempts {
+		return d.finish(ctx, delivery, storage.WebhookDeliveryFailed, sendErr.Error())
+	}
+	delivery.NextAttemptAt = now.Add(backoff(delivery.Attempts))
+	return d.finish(ctx, delivery, storage.WebhookDeliveryPending, sendErr.Error())
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook *storage.WebhookSubscription, delivery *storage.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := now.Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) finish(ctx context.Context, delivery *storage.WebhookDelivery, status, lastError string) error {
+	delivery.Status = status
+	delivery.LastError = lastError
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := d.store.UpdateDelivery(storeCtx, delivery); err != nil {
+		return fmt.Errorf("update delivery: %w", err)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a request body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// Webhooks lists the subscriptions of an agency, or of every agency when the
+// agency is empty.
+func (d *Dispatcher) Webhooks(ctx context.Context, agency string) ([]storage.WebhookSubscription, error) {
+	return d.store.ListWebhooks(ctx, normalizeAgency(agency))
+}
+
+// Webhook returns one subscription.
+func (d *Dispatcher) Webhook(ctx context.Context, id int64) (*storage.WebhookSubscription, error) {
+	return d.store.GetWebhook(ctx, id)
+}
+
+// SaveWebhook validates and stores a subscription. A signing secret is
+// generated when none is given.
+func (d *Dispatcher) SaveWebhook(ctx context.Context, webhook *storage.WebhookSubscription) error {
+	webhook.Agency = normalizeAgency(webhook.Agency)
+	webhook.URL = strings.TrimSpace(webhook.URL)
+	webhook.Secret = strings.TrimSpace(webhook.Secret)
+	if webhook.Agency == "" {
+		return fmt.Errorf("%w: agency is required", ErrInvalidWebhook)
+	}
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) url", ErrInvalidWebhook)
+	}
+	events := make([]string, 0, len(webhook.Events))
+	seen := make(map[string]struct{}, len(webhook.Events))
+	for _, event := range webhook.Events {
+		event = strings.TrimSpace(event)
+		if !supportedEvent(event) {
+			return fmt.Errorf("%w: unsupported event %q", ErrInvalidWebhook, event)
+		}
+		if _, ok := seen[event]; ok {
+			continue
+		}
+		seen[event] = struct{}{}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("%w: at least one event is required", ErrInvalidWebhook)
+	}
+	webhook.Events = events
+	if webhook.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			return err
+		}
+		webhook.Secret = secret
+	}
+
+	if err := d.store.SaveWebhook(ctx, webhook); err != nil {
+		return err
+	}
+	d.forget(webhook.Agency)
+	return nil
+}
+
+// DeleteWebhook removes a subscription together with its deliveries.
+func (d *Dispatcher) DeleteWebhook(ctx context.Context, webhook *storage.WebhookSubscription) error {
+	if err := d.store.DeleteWebhook(ctx, webhook.ID); err != nil {
+		return err
+	}
+	d.forget(webhook.Agency)
+	return nil
+}
+
+// Deliveries lists the latest deliveries of a subscription. Passing
+// storage.WebhookDeliveryFailed lists its dead-letter log.
+func (d *Dispatcher) Deliveries(ctx context.Context, subscriptionID int64, status string, limit int) ([]storage.WebhookDelivery, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	return d.store.ListDeliveries(ctx, subscriptionID, status, limit)
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts.
+func (d *Dispatcher) Redeliver(ctx context.Context, subscriptionID, deliveryID int64) (*storage.WebhookDelivery, error) {
+	delivery, err := d.store.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.SubscriptionID != subscriptionID {
+		return nil, storage.ErrWebhookDeliveryNotFound
+	}
+	delivery.Status = storage.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.LastError = ""
+	delivery.NextAttemptAt = time.Now()
+	if err := d.store.UpdateDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	d.notify()
+	return delivery, nil
+}
+
+// subscriptions returns the cached subscriptions of an agency.
+func (d *Dispatcher) subscriptions(ctx context.Context, agency string) ([]storage.WebhookSubscription, error) {
+	agency = normalizeAgency(agency)
+	d.mu.RLock()
+	webhooks, ok := d.webhooks[agency]
+	d.mu.RUnlock()
+	if ok {
+		return webhooks, nil
+	}
+
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	webhooks, err := d.store.ListWebhooks(storeCtx, agency)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.webhooks[agency] = webhooks
+	d.mu.Unlock()
+	return webhooks, nil
+}
+
+func (d *Dispatcher) forget(agency string) {
+	d.mu.Lock()
+	delete(d.webhooks, normalizeAgency(agency))
+	d.mu.Unlock()
+}
+
+func supportedEvent(event string) bool {
+	for _, supported := range Events {
+		if event == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// externalEvent reports whether an event may be sent to external receivers.
+// Internal notes are meant for agents only and never leave the server.
+func externalEvent(evt ws.HubEvent) bool {
+	return evt.Message == nil || evt.Message.Type != ws.MessageTypeInternal
+}
+
+func normalizeAgency(agency string) string {
+	return strings.ToLower(strings.TrimSpace(agency))
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,316 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"im/internal/storage"
+	"im/internal/ws"
+)
+
+// memoryWebhookStore keeps subscriptions and deliveries in memory. Setting
+// failLoad makes GetWebhook fail like an unreachable database.
+type memoryWebhookStore struct {
+	mu         sync.Mutex
+	nextID     int64
+	webhooks   map[int64]storage.WebhookSubscription
+	deliveries map[int64]storage.WebhookDelivery
+	failLoad   error
+	dueCalls   int
+}
+
+func newMemoryWebhookStore() *memoryWebhookStore {
+	return &memoryWebhookStore{
+		webhooks:   make(map[int64]storage.WebhookSubscription),
+		deliveries: make(map[int64]storage.WebhookDelivery),
+	}
+}
+
+func (s *memoryWebhookStore) ListWebhooks(_ context.Context, agency string) ([]storage.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var webhooks []storage.WebhookSubscription
+	for _, webhook := range s.webhooks {
+		if agency == "" || webhook.Agency == agency {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *memoryWebhookStore) GetWebhook(_ context.Context, id int64) (*storage.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failLoad != nil {
+		return nil, s.failLoad
+	}
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return nil, storage.ErrWebhookNotFound
+	}
+	return &webhook, nil
+}
+
+func (s *memoryWebhookStore) SaveWebhook(_ context.Context, webhook *storage.WebhookSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if webhook.ID == 0 {
+		s.nextID++
+		webhook.ID = s.nextID
+	}
+	s.webhooks[webhook.ID] = *webhook
+	return nil
+}
+
+func (s *memoryWebhookStore) DeleteWebhook(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *memoryWebhookStore) CreateDelivery(_ context.Context, delivery *storage.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	delivery.ID = s.nextID
+	s.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *memoryWebhookStore) UpdateDelivery(_ context.Context, delivery *storage.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		return storage.ErrWebhookDeliveryNotFound
+	}
+	s.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (s *memoryWebhookStore) GetDelivery(_ context.Context, id int64) (*storage.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return nil, storage.ErrWebhookDeliveryNotFound
+	}
+	return &delivery, nil
+}
+
+func (s *memoryWebhookStore) ListDeliveries(_ context.Context, subscriptionID int64, status string, limit int) ([]storage.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []storage.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID == subscriptionID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (s *memoryWebhookStore) DueDeliveries(_ context.Context, now time.Time, limit int) ([]storage.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dueCalls++
+	var due []storage.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.Status == storage.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"room.created"}`)
+	want := "sha256=3396a49250e94813e9313d8f20262aa9d25eaace544d0e22c3f077b2c91789b1"
+	if got := Sign("secret", 1700000000, body); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+	if Sign("other", 1700000000, body) == want || Sign("secret", 1700000001, body) == want {
+		t.Fatal("expected the signature to depend on the secret and timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, maxBackoff},
+		{20, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Fatalf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetryUntilFailedAndRedeliver(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	requests := make(chan *http.Request, MaxAttempts+1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	store := newMemoryWebhookStore()
+	dispatcher := NewDispatcher(store)
+	ctx := context.Background()
+	webhook := &storage.WebhookSubscription{
+		Agency: "Agency-A",
+		URL:    server.URL,
+		Secret: "secret",
+		Events: []string{"room.created"},
+		Active: true,
+	}
+	if err := dispatcher.SaveWebhook(ctx, webhook); err != nil {
+		t.Fatalf("save webhook failed: %v", err)
+	}
+	if webhook.Agency != "agency-a" {
+		t.Fatalf("expected agency to be normalized, got %q", webhook.Agency)
+	}
+	delivery := &storage.WebhookDelivery{
+		SubscriptionID: webhook.ID,
+		EventType:      "room.created",
+		Payload:        `{"event":"room.created"}`,
+		Status:         storage.WebhookDeliveryPending,
+		NextAttemptAt:  time.Now(),
+	}
+	if err := store.CreateDelivery(ctx, delivery); err != nil {
+		t.Fatalf("create delivery failed: %v", err)
+	}
+
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		before := time.Now()
+		if err := dispatcher.attempt(ctx, delivery); err != nil {
+			t.Fatalf("attempt %d failed: %v", attempt, err)
+		}
+		req := <-requests
+		timestamp, _ := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+		if req.Header.Get(SignatureHeader) != Sign("secret", timestamp, []byte(delivery.Payload)) {
+			t.Fatalf("unexpected signature %q", req.Header.Get(SignatureHeader))
+		}
+		stored, _ := store.GetDelivery(ctx, delivery.ID)
+		if stored.Attempts != attempt || stored.ResponseStatus != http.StatusInternalServerError {
+			t.Fatalf("unexpected delivery after attempt %d: %+v", attempt, stored)
+		}
+		if attempt < MaxAttempts {
+			if stored.Status != storage.WebhookDeliveryPending || stored.NextAttemptAt.Before(before.Add(backoff(attempt))) {
+				t.Fatalf("expected a retry after backoff, got %+v", stored)
+			}
+			continue
+		}
+		if stored.Status != storage.WebhookDeliveryFailed || stored.LastError == "" {
+			t.Fatalf("expected the delivery to fail after %d attempts, got %+v", MaxAttempts, stored)
+		}
+	}
+	failed, _ := dispatcher.Deliveries(ctx, webhook.ID, storage.WebhookDeliveryFailed, 0)
+	if len(failed) != 1 {
+		t.Fatalf("expected the delivery in the dead-letter log, got %+v", failed)
+	}
+
+	if _, err := dispatcher.Redeliver(ctx, webhook.ID+100, delivery.ID); !errors.Is(err, storage.ErrWebhookDeliveryNotFound) {
+		t.Fatalf("expected redelivery through another webhook to be refused, got %v", err)
+	}
+	redelivered, err := dispatcher.Redeliver(ctx, webhook.ID, delivery.ID)
+	if err != nil || redelivered.Status != storage.WebhookDeliveryPending || redelivered.Attempts != 0 || redelivered.LastError != "" {
+		t.Fatalf("unexpected redelivery %+v %v", redelivered, err)
+	}
+	status.Store(http.StatusNoContent)
+	dispatcher.deliverDue(ctx)
+	<-requests
+	stored, _ := store.GetDelivery(ctx, delivery.ID)
+	if stored.Status != storage.WebhookDeliveryDelivered || stored.Attempts != 1 || stored.DeliveredAt == nil {
+		t.Fatalf("expected the redelivery to succeed, got %+v", stored)
+	}
+}
+
+func TestEnqueueSkipsInternalNotes(t *testing.T) {
+	store := newMemoryWebhookStore()
+	dispatcher := NewDispatcher(store)
+	ctx := context.Background()
+	webhook := &storage.WebhookSubscription{
+		Agency: "agency-a",
+		URL:    "https://example.com/hook",
+		Secret: "secret",
+		Events: []string{ws.EventMessageCreated},
+		Active: true,
+	}
+	if err := dispatcher.SaveWebhook(ctx, webhook); err != nil {
+		t.Fatalf("save webhook failed: %v", err)
+	}
+
+	dispatcher.enqueue(ctx, ws.HubEvent{
+		Type:    ws.EventMessageCreated,
+		Agency:  "agency-a",
+		RoomID:  "room-1",
+		Message: &ws.MessageSummary{Type: ws.MessageTypeInternal, Preview: "玩家疑似洗碼"},
+	})
+	if deliveries, _ := store.ListDeliveries(ctx, webhook.ID, "", 10); len(deliveries) != 0 {
+		t.Fatalf("expected internal notes to stay on the server, got %+v", deliveries)
+	}
+
+	dispatcher.enqueue(ctx, ws.HubEvent{
+		Type:    ws.EventMessageCreated,
+		Agency:  "agency-a",
+		RoomID:  "room-1",
+		Message: &ws.MessageSummary{Type: ws.MessageTypeChat, Preview: "您好"},
+	})
+	if deliveries, _ := store.ListDeliveries(ctx, webhook.ID, "", 10); len(deliveries) != 1 {
+		t.Fatalf("expected chat messages to be delivered, got %+v", deliveries)
+	}
+}
+
+func TestDeliverDueStopsOnStoreError(t *testing.T) {
+	store := newMemoryWebhookStore()
+	store.failLoad = errors.New("database unavailable")
+	for i := 0; i < dueBatchSize; i++ {
+		store.CreateDelivery(context.Background(), &storage.WebhookDelivery{
+			SubscriptionID: 1,
+			Status:         storage.WebhookDeliveryPending,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	dispatcher := NewDispatcher(store)
+
+	done := make(chan struct{})
+	go func() {
+		dispatcher.deliverDue(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected deliverDue to stop while the store fails")
+	}
+	if store.dueCalls != 1 {
+		t.Fatalf("expected one pass over due deliveries, got %d", store.dueCalls)
+	}
+}
@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidAnnouncement is returned when an announcement has no content
+	// or an expiry in the past.
+	ErrInvalidAnnouncement = errors.New("invalid announcement")
+	// ErrAnnouncementNotFound is returned when an announcement is unknown or
+	// has already expired.
+	ErrAnnouncementNotFound = errors.New("announcement not found")
+)
+
+// Announcement is a notice pushed to every connected player of an agency, or
+// of every agency when Agency is empty. Announcements with an ExpiresAt are
+// kept and also sent once to each player who connects before they expire.
+type Announcement struct {
+	ID        string    `json:"id"`
+	Agency    string    `json:"agency,omitempty"`
+	Content   string    `json:"content"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Persistent reports whether the announcement is kept for later connections.
+func (a Announcement) Persistent() bool {
+	return !a.ExpiresAt.IsZero()
+}
+
+func (a Announcement) activeAt(now time.Time) bool {
+	return a.Persistent() && now.Before(a.ExpiresAt)
+}
+
+func (a Announcement) reaches(agency string) bool {
+	return a.Agency == "" || sameAgency(a.Agency, agency)
+}
+
+// WithAnnouncementStore keeps persistent announcements across restarts.
+func WithAnnouncementStore(store AnnouncementStore) HubOption {
+	return func(h *Hub) {
+		h.announcementStore = store
+	}
+}
+
+// Announce pushes an announcement to the connected players it reaches and,
+// when it has an expiry, keeps it for players who connect later. It returns the
+// stored announcement and the number of player connections it was sent to.
+func (h *Hub) Announce(a Announcement) (Announcement, int, error) {
+	now := time.Now()
+	a.Content = strings.TrimSpace(a.Content)
+	if a.Content == "" {
+		return Announcement{}, 0, ErrInvalidAnnouncement
+	}
+	if a.Persistent() && !a.ExpiresAt.After(now) {
+		return Announcement{}, 0, ErrInvalidAnnouncement
+	}
+	id, err := newAnnouncementID()
+	if err != nil {
+		return Announcement{}, 0, err
+	}
+	a.ID = id
+	a.Agency = normalizeAgency(a.Agency)
+	a.CreatedAt = now
+
+	if a.Persistent() {
+		h.loadAnnouncements()
+		if h.announcementStore != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			err := h.announcementStore.SaveAnnouncement(ctx, a)
+			cancel()
+			if err != nil {
+				return Announcement{}, 0, err
+			}
+		}
+		h.announcementsMu.Lock()
+		h.announcements = append(h.announcements, a)
+		h.announcementsMu.Unlock()
+	}
+
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		if a.reaches(room.Agency()) {
+			rooms = append(rooms, room)
+		}
+	}
+	h.mu.RUnlock()
+
+	delivered := 0
+	for _, room := range rooms {
+		h.broadcastTo(room, announcementEnvelope(room, a), func(c *Client) bool {
+			if c.Role != RolePlayer {
+				return false
+			}
+			if a.Persistent() {
+				h.markAnnounced(a.ID, c)
+			}
+			delivered++
+			return true
+		})
+	}
+	return a, delivered, nil
+}
+
+// Announcements lists the persistent announcements that have not expired and
+// reach the agency, or every one of them when agency is empty.
+func (h *Hub) Announcements(agency string) []Announcement {
+	h.loadAnnouncements()
+	now := time.Now()
+
+	h.announcementsMu.Lock()
+	defer h.announcementsMu.Unlock()
+
+	active := h.announcements[:0]
+	for _, a := range h.announcements {
+		if a.activeAt(now) {
+			active = append(active, a)
+		} else {
+			delete(h.announcementsSeen, a.ID)
+		}
+	}
+	h.announcements = active
+
+	list := make([]Announcement, 0, len(active))
+	for _, a := range active {
+		if agency == "" || a.reaches(agency) {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// Announcement returns an active persistent announcement.
+func (h *Hub) Announcement(id string) (Announcement, error) {
+	for _, a := range h.Announcements("") {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return Announcement{}, ErrAnnouncementNotFound
+}
+
+// CancelAnnouncement stops a persistent announcement from being sent to
+// players who connect later.
+func (h *Hub) CancelAnnouncement(id string) error {
+	if _, err := h.Announcement(id); err != nil {
+		return err
+	}
+	if h.announcementStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		err := h.announcementStore.DeleteAnnouncement(ctx, id)
+		cancel()
+		if err != nil && !errors.Is(err, ErrAnnouncementNotFound) {
+			return err
+		}
+	}
+
+	h.announcementsMu.Lock()
+	defer h.announcementsMu.Unlock()
+	for i, a := range h.announcements {
+		if a.ID == id {
+			h.announcements = append(h.announcements[:i], h.announcements[i+1:]...)
+			break
+		}
+	}
+	delete(h.announcementsSeen, id)
+	return nil
+}
+
+// sendAnnouncements delivers the active announcements of the room's agency
+// that a player who just connected has not received yet.
+func (h *Hub) sendAnnouncements(room *Room, c *Client) {
+	agency := room.Agency()
+	for _, a := range h.Announcements("") {
+		if a.reaches(agency) && h.markAnnounced(a.ID, c) {
+			_ = c.SendEnvelope(announcementEnvelope(room, a))
+		}
+	}
+}
+
+// markAnnounced records that the announcement reached the player, reporting
+// whether it is the first time.
+func (h *Hub) markAnnounced(id string, c *Client) bool {
+	player := normalizeAgency(c.Agency) + "/" + c.ID
+
+	h.announcementsMu.Lock()
+	defer h.announcementsMu.Unlock()
+	seen := h.announcementsSeen[id]
+	if seen == nil {
+		seen = make(map[string]bool)
+		h.announcementsSeen[id] = seen
+	}
+	if seen[player] {
+		return false
+	}
+	seen[player] = true
+	return true
+}
+
+// loadAnnouncements reads the active announcements from the store once.
+func (h *Hub) loadAnnouncements() {
+	h.announcementsMu.Lock()
+	defer h.announcementsMu.Unlock()
+
+	if h.announcementsLoaded || h.announcementStore == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	stored, err := h.announcementStore.LoadActiveAnnouncements(ctx, time.Now())
+	if err != nil {
+		log.Printf("load announcements: %v", err)
+		return
+	}
+	known := make(map[string]bool, len(h.announcements))
+	for _, a := range h.announcements {
+		known[a.ID] = true
+	}
+	for _, a := range stored {
+		if !known[a.ID] {
+			h.announcements = append(h.announcements, a)
+		}
+	}
+	h.announcementsLoaded = true
+}
+
+func announcementEnvelope(room *Room, a Announcement) Envelope {
+	metadata := map[string]string{"announcement": a.ID}
+	if a.Persistent() {
+		metadata["expiresAt"] = a.ExpiresAt.Format(time.RFC3339)
+	}
+	return Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Timestamp: a.CreatedAt,
+		Content:   a.Content,
+		Metadata:  metadata,
+	}
+}
+
+func newAnnouncementID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
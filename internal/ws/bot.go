@@ -0,0 +1,476 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidBotRule is returned when an auto-reply rule is malformed.
+	ErrInvalidBotRule = errors.New("invalid bot rule")
+	// ErrInvalidBotWebhook is returned when an external bot webhook URL is
+	// not an absolute http(s) URL.
+	ErrInvalidBotWebhook = errors.New("invalid bot webhook url")
+	// ErrBotUnauthorized is returned when an external bot reply carries the
+	// wrong token for the room's agency.
+	ErrBotUnauthorized = errors.New("bot token rejected")
+	// ErrBotInactive is returned when an external bot replies in a room that
+	// has already been handed off to an agent.
+	ErrBotInactive = errors.New("room is not handled by the bot")
+)
+
+const (
+	botSenderID        = "bot"
+	botDisplayName     = "智能客服"
+	botDispatchTimeout = 5 * time.Second
+	// botHandoffAction is the action offered with every bot reply. Players
+	// choose it by sending a chat message with metadata.handoff set to it.
+	botHandoffAction = "human"
+	botHandoffLabel  = "轉接真人客服"
+)
+
+// handoffKeywords are phrases that ask for an agent without using the
+// handoff action.
+var handoffKeywords = []string{"真人", "人工", "轉接客服", "human"}
+
+// AgencyBot holds the auto-reply rules of an agency and its optional external
+// bot. The bot answers new rooms of agencies that have at least one rule or a
+// webhook.
+type AgencyBot struct {
+	Agency string    `json:"agency"`
+	Rules  []BotRule `json:"rules"`
+	// WebhookURL receives the player messages no rule answers. The external
+	// bot answers in its response or later through Hub.BotReply,
+	// authenticated by Token.
+	WebhookURL string    `json:"webhookUrl,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// BotDispatcher forwards player messages to an agency's external bot. The
+// returned answer is empty when the bot replies later through Hub.BotReply.
+type BotDispatcher interface {
+	DispatchBotMessage(ctx context.Context, bot AgencyBot, room RoomSummary, msg ChatMessage) (BotAnswer, error)
+}
+
+// BotAnswer is what an external bot answers directly in its webhook response,
+// with the same meaning as the content and handoff of Hub.BotReply.
+type BotAnswer struct {
+	Content string `json:"content"`
+	Handoff bool   `json:"handoff"`
+}
+
+// BotRule answers player messages that contain one of its keywords or match
+// its pattern. Keywords are matched case-insensitively; rules are tried in
+// order.
+type BotRule struct {
+	ID       string   `json:"id"`
+	Keywords []string `json:"keywords,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Reply    string   `json:"reply"`
+
+	pattern *regexp.Regexp
+}
+
+// Enabled reports whether the agency's bot answers new rooms.
+func (b AgencyBot) Enabled() bool {
+	return len(b.Rules) > 0 || b.WebhookURL != ""
+}
+
+// Match returns the first rule that answers the content.
+func (b AgencyBot) Match(content string) (BotRule, bool) {
+	lower := strings.ToLower(content)
+	for _, rule := range b.Rules {
+		if rule.matches(content, lower) {
+			return rule, true
+		}
+	}
+	return BotRule{}, false
+}
+
+func (r BotRule) matches(content, lower string) bool {
+	for _, keyword := range r.Keywords {
+		if strings.Contains(lower, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return r.pattern != nil && r.pattern.MatchString(content)
+}
+
+// compileBotRules validates rules and compiles their patterns.
+func compileBotRules(rules []BotRule) ([]BotRule, error) {
+	seen := make(map[string]struct{}, len(rules))
+	compiled := make([]BotRule, 0, len(rules))
+	for i, rule := range rules {
+		rule.ID = strings.TrimSpace(rule.ID)
+		if rule.ID == "" {
+			rule.ID = fmt.Sprintf("rule-%d", i+1)
+		}
+		if _, ok := seen[rule.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate rule %q", ErrInvalidBotRule, rule.ID)
+		}
+		seen[rule.ID] = struct{}{}
+
+		keywords := make([]string, 0, len(rule.Keywords))
+		for _, keyword := range rule.Keywords {
+			if keyword = strings.TrimSpace(keyword); keyword != "" {
+				keywords = append(keywords, keyword)
+			}
+		}
+		rule.Keywords = keywords
+		rule.Pattern = strings.TrimSpace(rule.Pattern)
+		rule.Reply = strings.TrimSpace(rule.Reply)
+		if rule.Reply == "" {
+			return nil, fmt.Errorf("%w: rule %q requires a reply", ErrInvalidBotRule, rule.ID)
+		}
+		if len(rule.Keywords) == 0 && rule.Pattern == "" {
+			return nil, fmt.Errorf("%w: rule %q requires keywords or a pattern", ErrInvalidBotRule, rule.ID)
+		}
+		rule.pattern = nil
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: rule %q: %v", ErrInvalidBotRule, rule.ID, err)
+			}
+			rule.pattern = pattern
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// WithBotRuleStore persists the auto-reply rules. Without a store, rules only
+// live for the lifetime of the hub.
+func WithBotRuleStore(store BotRuleStore) HubOption {
+	return func(h *Hub) {
+		h.botStore = store
+	}
+}
+
+// WithBotDispatcher forwards unanswered player messages to the external bot of
+// agencies that registered a webhook.
+func WithBotDispatcher(dispatcher BotDispatcher) HubOption {
+	return func(h *Hub) {
+		h.botDispatcher = dispatcher
+	}
+}
+
+// SetBotRules replaces an agency's auto-reply rules. An empty rule set
+// disables the bot for the agency unless it has an external bot.
+func (h *Hub) SetBotRules(agency string, rules []BotRule) (AgencyBot, error) {
+	agency = normalizeAgency(agency)
+	if agency == "" {
+		return AgencyBot{}, errors.New("agency is required")
+	}
+	compiled, err := compileBotRules(rules)
+	if err != nil {
+		return AgencyBot{}, err
+	}
+	bot, err := h.BotRules(agency)
+	if err != nil {
+		return AgencyBot{}, err
+	}
+	bot.Rules = compiled
+	return h.saveBot(bot)
+}
+
+// SetBotWebhook registers the agency's external bot. A token for the bot
+// reply API is generated on first registration, or again when rotateToken is
+// set. An empty URL removes the external bot.
+func (h *Hub) SetBotWebhook(agency, webhookURL string, rotateToken bool) (AgencyBot, error) {
+	agency = normalizeAgency(agency)
+	if agency == "" {
+		return AgencyBot{}, errors.New("agency is required")
+	}
+	webhookURL = strings.TrimSpace(webhookURL)
+	if webhookURL != "" {
+		target, err := url.Parse(webhookURL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return AgencyBot{}, ErrInvalidBotWebhook
+		}
+	}
+	bot, err := h.BotRules(agency)
+	if err != nil {
+		return AgencyBot{}, err
+	}
+	bot.WebhookURL = webhookURL
+	switch {
+	case webhookURL == "":
+		bot.Token = ""
+	case bot.Token == "" || rotateToken:
+		token, err := newBotToken()
+		if err != nil {
+			return AgencyBot{}, err
+		}
+		bot.Token = token
+	}
+	return h.saveBot(bot)
+}
+
+func (h *Hub) saveBot(bot AgencyBot) (AgencyBot, error) {
+	bot.UpdatedAt = time.Now()
+	if h.botStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := h.botStore.SaveBotRules(ctx, bot); err != nil {
+			return AgencyBot{}, err
+		}
+	}
+
+	h.botsMu.Lock()
+	h.bots[bot.Agency] = bot
+	h.botsMu.Unlock()
+	return bot, nil
+}
+
+// BotRules returns an agency's auto-reply rules, or an empty rule set when
+// none were configured.
+func (h *Hub) BotRules(agency string) (AgencyBot, error) {
+	agency = normalizeAgency(agency)
+	h.botsMu.RLock()
+	bot, ok := h.bots[agency]
+	h.botsMu.RUnlock()
+	if ok {
+		return bot, nil
+	}
+
+	bot = AgencyBot{Agency: agency, Rules: []BotRule{}}
+	if h.botStore != nil && agency != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		stored, err := h.botStore.LoadBotRules(ctx, agency)
+		if err != nil {
+			return AgencyBot{}, err
+		}
+		if stored != nil {
+			compiled, err := compileBotRules(stored.Rules)
+			if err != nil {
+				return AgencyBot{}, err
+			}
+			bot = *stored
+			bot.Rules = compiled
+		}
+	}
+
+	h.botsMu.Lock()
+	h.bots[agency] = bot
+	h.botsMu.Unlock()
+	return bot, nil
+}
+
+// startBot lets the agency's bot answer a new room before it joins the
+// waiting queue.
+func (h *Hub) startBot(room *Room) {
+	bot, err := h.BotRules(room.Agency())
+	if err != nil {
+		log.Printf("load agency %s bot rules: %v", room.Agency(), err)
+		return
+	}
+	if !bot.Enabled() || !room.startBot() {
+		return
+	}
+	h.publishRoomEvent(EventRoomUpdated, room)
+}
+
+// runBot answers a player message in a room handled by the bot. Messages no
+// rule answers go to the agency's external bot, if any. Players who ask for an
+// agent, or whose question nobody answers, are handed off to the waiting
+// queue.
+func (h *Hub) runBot(room *Room, env Envelope, msg ChatMessage) {
+	if !room.BotActive() {
+		return
+	}
+	if env.Metadata["handoff"] == botHandoffAction || wantsHuman(env.Content) {
+		h.handOffToAgent(room)
+		return
+	}
+	bot, err := h.BotRules(room.Agency())
+	if err != nil {
+		log.Printf("load agency %s bot rules: %v", room.Agency(), err)
+		h.handOffToAgent(room)
+		return
+	}
+	rule, ok := bot.Match(env.Content)
+	if !ok {
+		if bot.WebhookURL != "" && h.botDispatcher != nil {
+			go h.dispatchBotMessage(bot, room, msg)
+			return
+		}
+		h.handOffToAgent(room)
+		return
+	}
+
+	stored := room.AddMessage(ChatMessage{
+		RoomID:      room.ID(),
+		Type:        MessageTypeChat,
+		SenderID:    botSenderID,
+		SenderRole:  RoleBot,
+		DisplayName: botDisplayName,
+		Content:     rule.Reply,
+		Timestamp:   time.Now(),
+		Metadata:    map[string]string{"botRule": rule.ID},
+	})
+	h.persistMessage(stored)
+	h.broadcast(room, Envelope{
+		Cmd:         MessageTypeChat,
+		Type:        MessageTypeChat,
+		RoomID:      room.ID(),
+		SenderID:    botSenderID,
+		SenderRole:  RoleBot,
+		DisplayName: botDisplayName,
+		Content:     stored.Content,
+		Timestamp:   stored.Timestamp,
+		Metadata:    stored.Metadata,
+		Seq:         stored.Sequence,
+		Ack:         stored.Sequence,
+		Payload:     botActions(),
+	})
+	h.publishMessageEvent(room, stored)
+}
+
+// dispatchBotMessage hands a player message to the external bot and posts the
+// answer it returns, if any. Rooms whose bot cannot be reached are handed off
+// to an agent.
+func (h *Hub) dispatchBotMessage(bot AgencyBot, room *Room, msg ChatMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), botDispatchTimeout)
+	defer cancel()
+	answer, err := h.botDispatcher.DispatchBotMessage(ctx, bot, room.Summary(), msg)
+	if err != nil {
+		log.Printf("dispatch room %s message to bot: %v", room.ID(), err)
+		h.handOffToAgent(room)
+		return
+	}
+	if strings.TrimSpace(answer.Content) == "" && !answer.Handoff {
+		return
+	}
+	if err := h.answerBot(room, answer.Content, answer.Handoff); err != nil {
+		log.Printf("post room %s bot answer: %v", room.ID(), err)
+	}
+}
+
+// BotReply posts an external bot's reply into a room through HandleIncoming,
+// as if the bot were connected to it, and hands the room off to an agent
+// afterwards when handoff is set. Either content or handoff is required. The
+// token is checked before the room is looked up, so callers without a valid
+// token cannot tell which rooms exist.
+func (h *Hub) BotReply(roomID, token, content string, handoff bool) (RoomSummary, error) {
+	room := h.getRoom(roomID)
+	if room == nil {
+		if !h.knownBotToken(token) {
+			return RoomSummary{}, ErrBotUnauthorized
+		}
+		return RoomSummary{}, ErrRoomNotFound
+	}
+	bot, err := h.BotRules(room.Agency())
+	if err != nil {
+		return RoomSummary{}, err
+	}
+	if !botTokenMatches(bot, token) {
+		return RoomSummary{}, ErrBotUnauthorized
+	}
+	if err := h.answerBot(room, content, handoff); err != nil {
+		return RoomSummary{}, err
+	}
+	return room.Summary(), nil
+}
+
+// answerBot posts an external bot's answer into a room while the bot still
+// handles it.
+func (h *Hub) answerBot(room *Room, content string, handoff bool) error {
+	if !room.BotActive() {
+		return ErrBotInactive
+	}
+	content = strings.TrimSpace(content)
+	if content == "" && !handoff {
+		return errors.New("content is required")
+	}
+
+	if content != "" {
+		client := NewClient(h, nil, room.ID(), botSenderID, RoleBot, botDisplayName)
+		client.Agency = room.Agency()
+		env := Envelope{Cmd: MessageTypeChat, Type: MessageTypeChat, Content: content}
+		if !handoff {
+			env.Payload = botActions()
+		}
+		if err := h.HandleIncoming(client, env); err != nil {
+			return err
+		}
+	}
+	if handoff {
+		h.handOffToAgent(room)
+	}
+	return nil
+}
+
+// botActions offers players the handoff to an agent.
+func botActions() map[string]any {
+	return map[string]any{
+		"actions": []map[string]string{{"id": botHandoffAction, "label": botHandoffLabel}},
+	}
+}
+
+// handOffToAgent ends the bot conversation and queues the room for an agent.
+func (h *Hub) handOffToAgent(room *Room) {
+	now := time.Now()
+	if !room.stopBot(now) {
+		return
+	}
+	h.broadcastToPlayers(room, Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Timestamp: now,
+		Content:   "已為您轉接真人客服，請稍候",
+		Metadata:  map[string]string{"handoff": "true"},
+	})
+	h.publishRoomEvent(EventRoomUpdated, room)
+	if h.queueNoticeInterval > 0 && h.SupportOffline(room.Agency(), now) == "" {
+		if position, _, _, ok := h.QueuePosition(room.ID(), now); ok {
+			h.sendQueueNotice(room, position, now, nil)
+		}
+	}
+}
+
+// knownBotToken reports whether the token belongs to one of the loaded
+// external bots.
+func (h *Hub) knownBotToken(token string) bool {
+	h.botsMu.RLock()
+	defer h.botsMu.RUnlock()
+	for _, bot := range h.bots {
+		if botTokenMatches(bot, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func botTokenMatches(bot AgencyBot, token string) bool {
+	return bot.Token != "" && subtle.ConstantTimeCompare([]byte(bot.Token), []byte(token)) == 1
+}
+
+func newBotToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func wantsHuman(content string) bool {
+	lower := strings.ToLower(content)
+	for _, keyword := range handoffKeywords {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrCannedResponseNotFound is returned when a canned response is unknown.
+	ErrCannedResponseNotFound = errors.New("canned response not found")
+	// ErrCannedShortcutTaken is returned when another canned response in the
+	// same scope already uses the shortcut.
+	ErrCannedShortcutTaken = errors.New("canned response shortcut already exists")
+	// ErrCannedResponsesDisabled is returned when the hub has no canned
+	// response store.
+	ErrCannedResponsesDisabled = errors.New("canned responses are not configured")
+)
+
+// CannedResponse is a reusable reply. Responses without an OwnerID are shared
+// by every agent of the agency; the others are personal shortcuts. Content may
+// use the {{playerName}} and {{agentName}} variables.
+type CannedResponse struct {
+	ID        int64     `json:"id"`
+	Agency    string    `json:"agency"`
+	OwnerID   string    `json:"ownerId,omitempty"`
+	Shortcut  string    `json:"shortcut"`
+	Category  string    `json:"category,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Content   string    `json:"content"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CannedResponseFilter selects the shared responses of an agency (every
+// agency when empty) together with the personal responses of an owner.
+type CannedResponseFilter struct {
+	Agency   string
+	OwnerID  string
+	Category string
+}
+
+// Personal reports whether the response belongs to a single agent.
+func (c CannedResponse) Personal() bool {
+	return c.OwnerID != ""
+}
+
+// Render substitutes the template variables.
+func (c CannedResponse) Render(playerName, agentName string) string {
+	return strings.NewReplacer(
+		"{{playerName}}", playerName,
+		"{{agentName}}", agentName,
+	).Replace(c.Content)
+}
+
+// WithCannedResponseStore enables the canned responses library.
+func WithCannedResponseStore(store CannedResponseStore) HubOption {
+	return func(h *Hub) {
+		h.cannedStore = store
+	}
+}
+
+// CannedResponses lists the responses matching the filter.
+func (h *Hub) CannedResponses(filter CannedResponseFilter) ([]CannedResponse, error) {
+	if h.cannedStore == nil {
+		return nil, ErrCannedResponsesDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.cannedStore.ListCannedResponses(ctx, filter)
+}
+
+// CannedResponse returns a single response.
+func (h *Hub) CannedResponse(id int64) (CannedResponse, error) {
+	if h.cannedStore == nil {
+		return CannedResponse{}, ErrCannedResponsesDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	response, err := h.cannedStore.LoadCannedResponse(ctx, id)
+	if err != nil {
+		return CannedResponse{}, err
+	}
+	if response == nil {
+		return CannedResponse{}, ErrCannedResponseNotFound
+	}
+	return *response, nil
+}
+
+// SaveCannedResponse creates the response when its ID is zero and replaces
+// it otherwise.
+func (h *Hub) SaveCannedResponse(response CannedResponse) (CannedResponse, error) {
+	if h.cannedStore == nil {
+		return CannedResponse{}, ErrCannedResponsesDisabled
+	}
+	response.Agency = strings.TrimSpace(response.Agency)
+	response.Shortcut = strings.TrimSpace(response.Shortcut)
+	response.Category = strings.TrimSpace(response.Category)
+	response.Title = strings.TrimSpace(response.Title)
+	response.Content = strings.TrimSpace(response.Content)
+	if response.Shortcut == "" || response.Content == "" {
+		return CannedResponse{}, errors.New("shortcut and content are required")
+	}
+	if !response.Personal() && response.Agency == "" {
+		return CannedResponse{}, errors.New("agency is required")
+	}
+	response.UpdatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.cannedStore.SaveCannedResponse(ctx, response)
+}
+
+// DeleteCannedResponse removes a response.
+func (h *Hub) DeleteCannedResponse(id int64) error {
+	if h.cannedStore == nil {
+		return ErrCannedResponsesDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.cannedStore.DeleteCannedResponse(ctx, id)
+}
+
+// renderCannedCommand turns a canned.send envelope into the chat message it
+// stands for. Agents may send their personal responses and the shared
+// responses of the room's agency.
+func (h *Hub) renderCannedCommand(c *Client, room *Room, env *Envelope) error {
+	if c.Role != RoleAgent {
+		return ErrNotPermitted
+	}
+	id, err := strconv.ParseInt(env.Metadata["cannedId"], 10, 64)
+	if err != nil || id <= 0 {
+		return errors.New("metadata.cannedId is required")
+	}
+	response, err := h.CannedResponse(id)
+	if err != nil {
+		return err
+	}
+	if response.Personal() {
+		if response.OwnerID != c.ID {
+			return ErrNotPermitted
+		}
+	} else if !strings.EqualFold(response.Agency, room.Agency()) {
+		return ErrNotPermitted
+	}
+
+	env.Cmd = MessageTypeChat
+	env.Type = MessageTypeChat
+	env.Content = response.Render(room.playerName(), c.DisplayName)
+	env.Metadata = map[string]string{"cannedId": strconv.FormatInt(response.ID, 10)}
+	return nil
+}
@@ -2,38 +2,37 @@ package ws
 
 import (
 	"encoding/json"
-	"errors"
-	"log"
 	"sync"
 	"time"
-
-	"im/internal/simplews"
-)
-
-const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 8192
 )
 
-// Client represents a connected WebSocket participant.
+// Client represents a connected participant. Its transport is abstracted by
+// Conn, so WebSockets, HTTP fallbacks and in-process bots share one code path.
 type Client struct {
 	hub         *Hub
-	conn        *simplews.Conn
-	send        chan []byte
+	conn        Conn
 	ID          string
 	DisplayName string
 	Role        string
 	RoomID      string
-	closeOnce   sync.Once
+	// Agency scopes the client's rooms for agency-level features such as the
+	// admin event stream. It is taken from the player's account when known.
+	// Agents without an agency serve every agency.
+	Agency string
+	// Observer marks a supervisor that joins the room silently: it receives
+	// room traffic but is not listed as a participant until it barges in.
+	Observer bool
+	// Multiplexed marks an agent connection that is not bound to RoomID and
+	// instead subscribes to any number of rooms.
+	Multiplexed   bool
+	subscriptions map[string]struct{}
+	subMu         sync.RWMutex
 }
 
-func NewClient(hub *Hub, conn *simplews.Conn, roomID, id, role, displayName string) *Client {
+func NewClient(hub *Hub, conn Conn, roomID, id, role, displayName string) *Client {
 	return &Client{
 		hub:         hub,
 		conn:        conn,
-		send:        make(chan []byte, 16),
 		ID:          id,
 		DisplayName: displayName,
 		Role:        role,
@@ -41,69 +40,61 @@ func NewClient(hub *Hub, conn *simplews.Conn, roomID, id, role, displayName stri
 	}
 }
 
-func (c *Client) ReadPump() {
-	defer func() {
-		c.hub.Unregister(c)
-		c.Close()
-		_ = c.conn.Close()
-	}()
-
-	c.conn.SetReadLimit(maxMessageSize)
-	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	})
+// Subscriptions lists the rooms a multiplexed client is subscribed to.
+func (c *Client) Subscriptions() []string {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
 
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if simplews.IsUnexpectedCloseError(err, simplews.CloseGoingAway, simplews.CloseAbnormalClosure) {
-				log.Printf("websocket unexpected close: %v", err)
-			}
-			break
-		}
-
-		var envelope Envelope
-		if err := json.Unmarshal(message, &envelope); err != nil {
-			c.sendSystemError("格式錯誤，請重新傳送")
-			continue
-		}
-
-		envelope.Normalize()
-
-		if err := c.hub.HandleIncoming(c, envelope); err != nil {
-			c.sendSystemError(err.Error())
-		}
+	rooms := make([]string, 0, len(c.subscriptions))
+	for roomID := range c.subscriptions {
+		rooms = append(rooms, roomID)
 	}
+	return rooms
+}
+
+func (c *Client) IsSubscribed(roomID string) bool {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+
+	_, ok := c.subscriptions[roomID]
+	return ok
 }
 
-func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
-	defer func() {
-		ticker.Stop()
-		c.Close()
-		_ = c.conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				_ = c.conn.WriteMessage(simplews.CloseMessage, []byte{})
-				return
-			}
-
-			if err := c.conn.WriteMessage(simplews.TextMessage, message); err != nil {
-				return
-			}
-		case <-ticker.C:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(simplews.PingMessage, nil); err != nil {
-				return
-			}
-		}
+func (c *Client) addSubscription(roomID string) bool {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if _, ok := c.subscriptions[roomID]; ok {
+		return false
+	}
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]struct{})
 	}
+	c.subscriptions[roomID] = struct{}{}
+	return true
+}
+
+func (c *Client) removeSubscription(roomID string) bool {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if _, ok := c.subscriptions[roomID]; !ok {
+		return false
+	}
+	delete(c.subscriptions, roomID)
+	return true
+}
+
+// Receive processes an envelope sent by the client over its transport,
+// reporting failures back to the client as a system notice.
+func (c *Client) Receive(env Envelope) error {
+	env.Normalize()
+
+	if err := c.hub.HandleIncoming(c, env); err != nil {
+		c.sendSystemError(err.Error())
+		return err
+	}
+	return nil
 }
 
 func (c *Client) SendEnvelope(env Envelope) error {
@@ -118,12 +109,13 @@ func (c *Client) SendEnvelope(env Envelope) error {
 		return err
 	}
 
-	select {
-	case c.send <- payload:
-		return nil
-	default:
-		return errors.New("send buffer full")
-	}
+	return c.conn.Send(payload)
+}
+
+// deliver hands an already encoded envelope to the client's transport,
+// dropping it if the transport cannot accept it without blocking.
+func (c *Client) deliver(payload []byte) {
+	_ = c.conn.Send(payload)
 }
 
 func (c *Client) sendSystemError(message string) {
@@ -136,9 +128,7 @@ func (c *Client) sendSystemError(message string) {
 	})
 }
 
-// Close safely closes the outgoing channel once.
+// Close closes the client's transport.
 func (c *Client) Close() {
-	c.closeOnce.Do(func() {
-		close(c.send)
-	})
+	_ = c.conn.Close()
 }
@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrConnClosed is returned when sending on a connection that was closed.
+	ErrConnClosed = errors.New("connection closed")
+	// ErrSendBufferFull is returned when a connection cannot accept more
+	// outgoing messages without blocking.
+	ErrSendBufferFull = errors.New("send buffer full")
+)
+
+// Conn is the transport a Client delivers outgoing envelopes through. The hub
+// only ever hands a Conn encoded envelopes, so WebSockets, HTTP fallbacks and
+// in-process bots are interchangeable.
+type Conn interface {
+	// Send queues an encoded envelope for delivery. It must not block; a
+	// connection that cannot keep up returns ErrSendBufferFull.
+	Send(payload []byte) error
+	// Close stops delivery. It is safe to call more than once.
+	Close() error
+}
+
+// MemoryConn is an in-process Conn that buffers outgoing envelopes in a
+// channel. It backs the HTTP fallback sessions and suits server-side bots and
+// tests that need a client without a socket.
+type MemoryConn struct {
+	mu       sync.Mutex
+	messages chan []byte
+	closed   bool
+}
+
+// NewMemoryConn creates an in-memory connection that buffers up to size
+// outgoing messages.
+func NewMemoryConn(size int) *MemoryConn {
+	if size <= 0 {
+		size = 16
+	}
+	return &MemoryConn{messages: make(chan []byte, size)}
+}
+
+func (m *MemoryConn) Send(payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrConnClosed
+	}
+	select {
+	case m.messages <- payload:
+		return nil
+	default:
+		return ErrSendBufferFull
+	}
+}
+
+func (m *MemoryConn) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.messages)
+	}
+	return nil
+}
+
+// Messages returns the channel of delivered payloads, which is closed when
+// the connection is closed.
+func (m *MemoryConn) Messages() <-chan []byte {
+	return m.messages
+}
+
+// Receive waits for the next envelope delivered to the connection.
+func (m *MemoryConn) Receive(ctx context.Context) (Envelope, error) {
+	select {
+	case payload, ok := <-m.messages:
+		if !ok {
+			return Envelope{}, ErrConnClosed
+		}
+		var env Envelope
+		if err := json.Unmarshal(payload, &env); err != nil {
+			return Envelope{}, err
+		}
+		env.Normalize()
+		return env, nil
+	case <-ctx.Done():
+		return Envelope{}, ctx.Err()
+	}
+}
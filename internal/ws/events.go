@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"im/internal/simplews"
+)
+
+// Hub event types pushed to the admin event stream.
+const (
+	EventRoomCreated    = "room.created"
+	EventRoomUpdated    = "room.updated"
+	EventRoomClosed     = "room.closed"
+	EventRoomAssigned   = "room.assigned"
+	EventMessageCreated = "message.created"
+	EventAgentPresence  = "agent.presence"
+)
+
+const (
+	eventBufferSize   = 64
+	messagePreviewLen = 80
+)
+
+// HubEvent describes a change in hub state for real-time dashboards.
+type HubEvent struct {
+	Type      string          `json:"type"`
+	RoomID    string          `json:"roomId,omitempty"`
+	Agency    string          `json:"agency,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Room      *RoomSummary    `json:"room,omitempty"`
+	Message   *MessageSummary `json:"message,omitempty"`
+	Agent     *AgentPresence  `json:"agent,omitempty"`
+	Online    *bool           `json:"online,omitempty"`
+}
+
+// MessageSummary is a trimmed down view of a chat message for event feeds.
+type MessageSummary struct {
+	Type        string    `json:"type"`
+	Sequence    int64     `json:"sequence"`
+	SenderID    string    `json:"senderId"`
+	SenderRole  string    `json:"senderRole"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Preview     string    `json:"preview"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// EventSubscription receives hub events for one agency, or for every agency
+// when the agency is empty.
+type EventSubscription struct {
+	agency string
+	events chan HubEvent
+	once   sync.Once
+
+	// queued subscriptions keep every event in pending until the subscriber
+	// reads it instead of dropping events when it falls behind.
+	queued  bool
+	mu      sync.Mutex
+	pending []HubEvent
+	ready   chan struct{}
+	done    chan struct{}
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription is cancelled.
+func (s *EventSubscription) Events() <-chan HubEvent {
+	return s.events
+}
+
+func (s *EventSubscription) accepts(evt HubEvent) bool {
+	return s.agency == "" || s.agency == evt.Agency
+}
+
+// SubscribeEvents registers a listener for hub events scoped to an agency.
+func (h *Hub) SubscribeEvents(agency string) *EventSubscription {
+	sub := &EventSubscription{
+		agency: agency,
+		events: make(chan HubEvent, eventBufferSize),
+	}
+	h.eventsMu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.eventsMu.Unlock()
+	return sub
+}
+
+// SubscribeEventQueue registers a listener like SubscribeEvents that never
+// drops events: they queue in memory until the subscriber reads them. It suits
+// consumers that must see every event, such as outbound webhooks.
+func (h *Hub) SubscribeEventQueue(agency string) *EventSubscription {
+	sub := &EventSubscription{
+		agency: agency,
+		events: make(chan HubEvent),
+		queued: true,
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	h.eventsMu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.eventsMu.Unlock()
+	go sub.forward()
+	return sub
+}
+
+// UnsubscribeEvents stops delivery to the subscription and closes its channel.
+func (h *Hub) UnsubscribeEvents(sub *EventSubscription) {
+	h.eventsMu.Lock()
+	delete(h.subscribers, sub)
+	h.eventsMu.Unlock()
+	sub.once.Do(func() {
+		if sub.queued {
+			// forward closes the channel once it stops sending on it
+			close(sub.done)
+			return
+		}
+		close(sub.events)
+	})
+}
+
+func (s *EventSubscription) push(evt HubEvent) {
+	s.mu.Lock()
+	s.pending = append(s.pending, evt)
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// forward hands the queued events to the subscriber in order.
+func (s *EventSubscription) forward() {
+	defer close(s.events)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+		for _, evt := range batch {
+			select {
+			case s.events <- evt:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (h *Hub) publish(evt HubEvent) {
+	if evt.Timestamp.IsZero() {
+		evt.Timestamp = time.Now()
+	}
+
+	h.eventsMu.RLock()
+	defer h.eventsMu.RUnlock()
+
+	for sub := range h.subscribers {
+		if !sub.accepts(evt) {
+			continue
+		}
+		if sub.queued {
+			sub.push(evt)
+			continue
+		}
+		select {
+		case sub.events <- evt:
+		default:
+			// drop the event for slow subscribers rather than block the hub
+		}
+	}
+}
+
+func (h *Hub) publishRoomEvent(eventType string, room *Room) {
+	summary := room.Summary()
+	h.publish(HubEvent{
+		Type:   eventType,
+		RoomID: summary.RoomID,
+		Agency: summary.Agency,
+		Room:   &summary,
+	})
+}
+
+func (h *Hub) publishMessageEvent(room *Room, msg ChatMessage) {
+	summary := room.Summary()
+	h.publish(HubEvent{
+		Type:   EventMessageCreated,
+		RoomID: summary.RoomID,
+		Agency: summary.Agency,
+		Room:   &summary,
+		Message: &MessageSummary{
+			Type:        msg.Type,
+			Sequence:    msg.Sequence,
+			SenderID:    msg.SenderID,
+			SenderRole:  msg.SenderRole,
+			DisplayName: msg.DisplayName,
+			Preview:     previewContent(msg.Content),
+			Timestamp:   msg.Timestamp,
+		},
+	})
+}
+
+func (h *Hub) publishAgentPresence(c *Client, online bool) {
+	presence := h.agentPresence(c.ID)
+	if presence == nil {
+		presence = &AgentPresence{
+			ID:          c.ID,
+			DisplayName: c.DisplayName,
+			Rooms:       make([]string, 0),
+			LastSeen:    time.Now(),
+		}
+	}
+	online = online || len(presence.Rooms) > 0
+	h.publish(HubEvent{
+		Type:   EventAgentPresence,
+		Agency: c.Agency,
+		Agent:  presence,
+		Online: &online,
+	})
+}
+
+func previewContent(content string) string {
+	if utf8.RuneCountInString(content) <= messagePreviewLen {
+		return content
+	}
+	runes := []rune(content)
+	return string(runes[:messagePreviewLen]) + "…"
+}
+
+// ServeEventStream writes the subscription's events to an admin WebSocket
+// until either side goes away. It unsubscribes before returning.
+func (h *Hub) ServeEventStream(conn *simplews.Conn, sub *EventSubscription) {
+	defer func() {
+		h.UnsubscribeEvents(sub)
+		_ = conn.Close()
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(maxMessageSize)
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case evt, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(evt)
+			if err != nil {
+				continue
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(simplews.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(simplews.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// HubMetrics reports how much state the hub currently keeps in memory.
+type HubMetrics struct {
+	ResidentRooms    int   `json:"residentRooms"`
+	ResidentMessages int   `json:"residentMessages"`
+	ConnectedClients int   `json:"connectedClients"`
+	EvictedRooms     int64 `json:"evictedRooms"`
+}
+
+// Run performs periodic maintenance such as closing inactive rooms, expiring
+// abandoned fallback sessions, evicting idle rooms and sending queue notices
+// until the context is cancelled, then saves the state of every resident room.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.evictionInterval)
+	defer ticker.Stop()
+
+	var queueTicks <-chan time.Time
+	if h.queueNoticeInterval > 0 {
+		queueTicker := time.NewTicker(h.queueNoticeInterval)
+		defer queueTicker.Stop()
+		queueTicks = queueTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.SaveRooms()
+			return
+		case now := <-ticker.C:
+			h.CloseInactiveRooms(now)
+			h.ExpireSessions(now)
+			h.EvictIdleRooms(now)
+		case now := <-queueTicks:
+			h.NotifyQueue(now)
+		}
+	}
+}
+
+// EvictIdleRooms unloads rooms without connected clients whose idle time has
+// exceeded the configured TTL, persisting their state first. It returns the
+// number of rooms removed from memory. Without a room store nothing is
+// evicted, since the state of an evicted room could not be restored.
+func (h *Hub) EvictIdleRooms(now time.Time) int {
+	if h.roomIdleTTL <= 0 || h.roomStore == nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	candidates := make([]*Room, 0)
+	for _, room := range h.rooms {
+		if room.idleFor(now) >= h.roomIdleTTL {
+			candidates = append(candidates, room)
+		}
+	}
+	h.mu.RUnlock()
+
+	evicted := 0
+	for _, room := range candidates {
+		if err := h.saveRoomState(room); err != nil {
+			log.Printf("persist room %s before eviction: %v", room.ID(), err)
+			continue
+		}
+
+		h.mu.Lock()
+		if h.rooms[room.ID()] == room && room.idleFor(now) >= h.roomIdleTTL {
+			delete(h.rooms, room.ID())
+			h.evictedRooms++
+			evicted++
+		}
+		h.mu.Unlock()
+	}
+	return evicted
+}
+
+// SaveRooms persists the state of every resident room, so that nothing kept
+// only in memory is lost on shutdown.
+func (h *Hub) SaveRooms() {
+	if h.roomStore == nil {
+		return
+	}
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.RUnlock()
+
+	for _, room := range rooms {
+		h.persistRoom(room)
+	}
+}
+
+// saveRoomState writes the room's state to the room store, if configured.
+func (h *Hub) saveRoomState(room *Room) error {
+	if h.roomStore == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	return h.roomStore.SaveRoomState(ctx, room.State())
+}
+
+// persistRoom saves the room's state after a change such as a new status,
+// assignment or priority, so that it survives a restart.
+func (h *Hub) persistRoom(room *Room) {
+	if err := h.saveRoomState(room); err != nil {
+		log.Printf("persist room %s state: %v", room.ID(), err)
+	}
+}
+
+// Metrics returns counters for the rooms and messages resident in memory.
+func (h *Hub) Metrics() HubMetrics {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	metrics := HubMetrics{
+		ResidentRooms: len(h.rooms),
+		EvictedRooms:  h.evictedRooms,
+	}
+	for _, room := range h.rooms {
+		messages, clients := room.counts()
+		metrics.ResidentMessages += messages
+		metrics.ConnectedClients += clients
+	}
+	return metrics
+}
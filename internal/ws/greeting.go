@@ -0,0 +1,211 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrInvalidGreeting is returned when a pre-chat menu option is malformed.
+var ErrInvalidGreeting = errors.New("invalid greeting")
+
+// defaultMenuPrompt introduces a pre-chat menu when the agency has no
+// greeting text.
+const defaultMenuPrompt = "請選擇您要諮詢的問題"
+
+// AgencyGreeting is what an agency shows players: the greeting sent when a
+// player opens a new room, the away message used while support is offline,
+// and an optional pre-chat menu.
+type AgencyGreeting struct {
+	Agency      string          `json:"agency"`
+	Greeting    string          `json:"greeting"`
+	AwayMessage string          `json:"awayMessage"`
+	Menu        []PreChatOption `json:"menu"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// PreChatOption is one choice of the pre-chat menu. Choosing it sets the
+// room's required skills so that it is routed to a suitable agent.
+type PreChatOption struct {
+	ID     string   `json:"id"`
+	Label  string   `json:"label"`
+	Skills []string `json:"skills,omitempty"`
+}
+
+// Option returns the menu choice with the given id.
+func (g AgencyGreeting) Option(id string) (PreChatOption, bool) {
+	for _, option := range g.Menu {
+		if option.ID == id {
+			return option, true
+		}
+	}
+	return PreChatOption{}, false
+}
+
+// WithGreetingStore persists agency greetings. Without a store, greetings
+// only live for the lifetime of the hub.
+func WithGreetingStore(store GreetingStore) HubOption {
+	return func(h *Hub) {
+		h.greetingStore = store
+	}
+}
+
+// SetGreeting stores an agency's greeting, away message and pre-chat menu.
+func (h *Hub) SetGreeting(greeting AgencyGreeting) (AgencyGreeting, error) {
+	greeting.Agency = normalizeAgency(greeting.Agency)
+	if greeting.Agency == "" {
+		return AgencyGreeting{}, errors.New("agency is required")
+	}
+	greeting.Greeting = strings.TrimSpace(greeting.Greeting)
+	greeting.AwayMessage = strings.TrimSpace(greeting.AwayMessage)
+
+	seen := make(map[string]struct{}, len(greeting.Menu))
+	menu := make([]PreChatOption, 0, len(greeting.Menu))
+	for _, option := range greeting.Menu {
+		option.ID = strings.TrimSpace(option.ID)
+		option.Label = strings.TrimSpace(option.Label)
+		if option.ID == "" || option.Label == "" {
+			return AgencyGreeting{}, fmt.Errorf("%w: menu options require an id and a label", ErrInvalidGreeting)
+		}
+		if _, ok := seen[option.ID]; ok {
+			return AgencyGreeting{}, fmt.Errorf("%w: duplicate menu option %q", ErrInvalidGreeting, option.ID)
+		}
+		seen[option.ID] = struct{}{}
+		option.Skills = NormalizeTags(option.Skills)
+		menu = append(menu, option)
+	}
+	greeting.Menu = menu
+	greeting.UpdatedAt = time.Now()
+
+	if h.greetingStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := h.greetingStore.SaveGreeting(ctx, greeting); err != nil {
+			return AgencyGreeting{}, err
+		}
+	}
+
+	h.greetingsMu.Lock()
+	h.greetings[greeting.Agency] = greeting
+	h.greetingsMu.Unlock()
+	return greeting, nil
+}
+
+// Greeting returns an agency's greeting, or an empty greeting when none was
+// configured.
+func (h *Hub) Greeting(agency string) (AgencyGreeting, error) {
+	agency = normalizeAgency(agency)
+	h.greetingsMu.RLock()
+	greeting, ok := h.greetings[agency]
+	h.greetingsMu.RUnlock()
+	if ok {
+		return greeting, nil
+	}
+
+	greeting = AgencyGreeting{Agency: agency, Menu: []PreChatOption{}}
+	if h.greetingStore != nil && agency != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		stored, err := h.greetingStore.LoadGreeting(ctx, agency)
+		if err != nil {
+			return AgencyGreeting{}, err
+		}
+		if stored != nil {
+			greeting = *stored
+		}
+	}
+
+	h.greetingsMu.Lock()
+	h.greetings[agency] = greeting
+	h.greetingsMu.Unlock()
+	return greeting, nil
+}
+
+// sendGreeting posts the agency greeting into a room that has no messages
+// yet. The greeting is kept in history so that it is shown again on reconnect
+// and only sent once per room.
+func (h *Hub) sendGreeting(room *Room) {
+	if room.NextSequence() > 0 {
+		return
+	}
+	greeting, err := h.Greeting(room.Agency())
+	if err != nil {
+		log.Printf("load agency %s greeting: %v", room.Agency(), err)
+		return
+	}
+	content := greeting.Greeting
+	if content == "" && len(greeting.Menu) > 0 {
+		content = defaultMenuPrompt
+	}
+	if content == "" {
+		return
+	}
+
+	stored := room.AddMessage(ChatMessage{
+		RoomID:    room.ID(),
+		Type:      MessageTypeSystem,
+		Content:   content,
+		Timestamp: time.Now(),
+		Metadata:  map[string]string{"greeting": "true"},
+	})
+	h.persistMessage(stored)
+
+	env := Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Content:   content,
+		Timestamp: stored.Timestamp,
+		Metadata:  stored.Metadata,
+		Seq:       stored.Sequence,
+		Ack:       stored.Sequence,
+	}
+	if len(greeting.Menu) > 0 {
+		env.Payload = map[string]any{"menu": greeting.Menu}
+	}
+	h.broadcast(room, env)
+}
+
+// awayMessage returns the agency's away message, if any.
+func (h *Hub) awayMessage(agency string) string {
+	greeting, err := h.Greeting(agency)
+	if err != nil {
+		log.Printf("load agency %s greeting: %v", agency, err)
+		return ""
+	}
+	return greeting.AwayMessage
+}
+
+// applyMenuChoice routes a waiting room by the skills of the pre-chat option
+// the player picked.
+func (h *Hub) applyMenuChoice(room *Room, optionID string) {
+	if _, waiting := room.waiting(); !waiting {
+		return
+	}
+	greeting, err := h.Greeting(room.Agency())
+	if err != nil {
+		log.Printf("load agency %s greeting: %v", room.Agency(), err)
+		return
+	}
+	option, ok := greeting.Option(optionID)
+	if !ok || len(option.Skills) == 0 {
+		return
+	}
+	if _, err := h.SetRoomSkills(room.ID(), option.Skills); err != nil {
+		log.Printf("apply menu choice to room %s: %v", room.ID(), err)
+	}
+}
+
+// normalizeAgency matches the lower-cased agency codes used by the agency
+// settings.
+func normalizeAgency(agency string) string {
+	return strings.ToLower(strings.TrimSpace(agency))
+}
+
+// sameAgency compares agency codes the way normalizeAgency stores them.
+func sameAgency(a, b string) bool {
+	return normalizeAgency(a) == normalizeAgency(b)
+}
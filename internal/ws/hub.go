@@ -1,11 +1,14 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,34 +22,186 @@ var (
 
 // AgentPresence represents an online agent and the rooms they are active in.
 type AgentPresence struct {
-	ID          string    `json:"id"`
-	DisplayName string    `json:"displayName"`
-	Rooms       []string  `json:"rooms"`
-	LastSeen    time.Time `json:"lastSeen"`
+	ID              string    `json:"id"`
+	DisplayName     string    `json:"displayName"`
+	Rooms           []string  `json:"rooms"`
+	LastSeen        time.Time `json:"lastSeen"`
+	Status          string    `json:"status"`
+	StatusChangedAt time.Time `json:"statusChangedAt,omitempty"`
+	// Agency is the agency of the agent's connections; empty for agents who
+	// serve every agency.
+	Agency string `json:"agency,omitempty"`
 }
 
+const (
+	// DefaultHistoryPageSize is the number of messages returned by a history
+	// page, including the initial history sent on connect.
+	DefaultHistoryPageSize = 50
+	// MaxHistoryPageSize caps the number of messages a client may request.
+	MaxHistoryPageSize = 200
+	// DefaultEvictionInterval is how often idle rooms are checked for eviction.
+	DefaultEvictionInterval = time.Minute
+
+	storeTimeout = 3 * time.Second
+)
+
 // Hub coordinates rooms and broadcasts messages to connected clients.
 type Hub struct {
-	mu    sync.RWMutex
-	rooms map[string]*Room
+	mu                  sync.RWMutex
+	rooms               map[string]*Room
+	createMu            sync.Mutex
+	store               MessageStore
+	roomStore           RoomStore
+	initialHistory      int
+	historyLimit        int
+	roomIdleTTL         time.Duration
+	evictionInterval    time.Duration
+	evictedRooms        int64
+	autoCloseAfter      time.Duration
+	multiplexed         map[*Client]struct{}
+	eventsMu            sync.RWMutex
+	subscribers         map[*EventSubscription]struct{}
+	sessionsMu          sync.Mutex
+	sessions            map[string]*Session
+	sessionTTL          time.Duration
+	statusStore         AgentStatusStore
+	statusMu            sync.RWMutex
+	agentStatuses       map[string]AgentStatus
+	profileStore        AgentProfileStore
+	profilesMu          sync.RWMutex
+	agentProfiles       map[string]AgentProfile
+	priorityResolver    PriorityResolver
+	queueNoticeInterval time.Duration
+	throughputMu        sync.Mutex
+	throughput          map[string][]time.Time
+	offlineMode         bool
+	openingHours        OpeningHours
+	greetingStore       GreetingStore
+	greetingsMu         sync.RWMutex
+	greetings           map[string]AgencyGreeting
+	cannedStore         CannedResponseStore
+	botStore            BotRuleStore
+	botDispatcher       BotDispatcher
+	botsMu              sync.RWMutex
+	bots                map[string]AgencyBot
+	announcementStore   AnnouncementStore
+	announcementsMu     sync.Mutex
+	announcements       []Announcement
+	announcementsLoaded bool
+	// announcementsSeen records, per persistent announcement, the players it
+	// already reached so it is only sent to them once.
+	announcementsSeen map[string]map[string]bool
+}
+
+// HubOption customises a Hub created by NewHub.
+type HubOption func(*Hub)
+
+// WithMessageStore persists chat messages and serves older history pages from
+// the store once they are no longer held in memory.
+func WithMessageStore(store MessageStore) HubOption {
+	return func(h *Hub) {
+		h.store = store
+	}
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		rooms: make(map[string]*Room),
+// WithInitialHistoryLimit caps the number of messages sent to a client when it
+// connects to a room.
+func WithInitialHistoryLimit(limit int) HubOption {
+	return func(h *Hub) {
+		if limit > 0 {
+			h.initialHistory = limit
+		}
+	}
+}
+
+// WithRoomStore persists room state before idle rooms are evicted and restores
+// it when the room is loaded again.
+func WithRoomStore(store RoomStore) HubOption {
+	return func(h *Hub) {
+		h.roomStore = store
+	}
+}
+
+// WithHistoryLimit caps the number of messages each room keeps in memory.
+// Older messages remain reachable through the message store, if configured.
+func WithHistoryLimit(limit int) HubOption {
+	return func(h *Hub) {
+		if limit > 0 {
+			h.historyLimit = limit
+		}
 	}
 }
 
+// WithRoomEviction unloads rooms that have had no connected clients for ttl,
+// checking every interval.
+func WithRoomEviction(ttl, interval time.Duration) HubOption {
+	return func(h *Hub) {
+		h.roomIdleTTL = ttl
+		if interval > 0 {
+			h.evictionInterval = interval
+		}
+	}
+}
+
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		rooms:             make(map[string]*Room),
+		multiplexed:       make(map[*Client]struct{}),
+		subscribers:       make(map[*EventSubscription]struct{}),
+		sessions:          make(map[string]*Session),
+		agentStatuses:     make(map[string]AgentStatus),
+		agentProfiles:     make(map[string]AgentProfile),
+		throughput:        make(map[string][]time.Time),
+		greetings:         make(map[string]AgencyGreeting),
+		bots:              make(map[string]AgencyBot),
+		announcementsSeen: make(map[string]map[string]bool),
+		sessionTTL:        DefaultSessionTTL,
+		initialHistory:    DefaultHistoryPageSize,
+		evictionInterval:  DefaultEvictionInterval,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Register attaches a client to its room. Multiplexed clients start without a
+// room and join rooms later through room.subscribe commands.
 func (h *Hub) Register(c *Client) (*Room, error) {
+	if c.Role == RoleAgent {
+		h.loadAgentStatus(c.ID)
+	}
+	if c.Multiplexed {
+		h.mu.Lock()
+		h.multiplexed[c] = struct{}{}
+		h.mu.Unlock()
+		h.publishAgentPresence(c, true)
+		h.alertOfflineBacklog(c.ID, c.Agency)
+		return nil, nil
+	}
 	if c.RoomID == "" {
 		return nil, fmt.Errorf("room id is required")
 	}
+	room := h.attach(c, c.RoomID)
+	if c.Role == RoleAgent && !c.Observer {
+		h.alertOfflineBacklog(c.ID, c.Agency)
+	}
+	return room, nil
+}
 
-	room := h.getOrCreateRoom(c.RoomID)
-	participant := room.AddClient(c)
+// attach joins the client to a room, sends the recent history and announces
+// the client to the other participants.
+func (h *Hub) attach(c *Client, roomID string) *Room {
+	room, participant, created := h.joinRoom(c, roomID)
+	if created {
+		h.publishRoomEvent(EventRoomCreated, room)
+	} else {
+		h.publishRoomEvent(EventRoomUpdated, room)
+	}
 
-	history, nextSeq := room.MessagesSince(0)
+	history, hasMore := h.visibleMessagesBefore(c, room, 0, h.initialHistory)
 	if len(history) > 0 {
+		nextSeq := room.NextSequence()
 		_ = c.SendEnvelope(Envelope{
 			Cmd:       MessageTypeHistory,
 			Type:      MessageTypeHistory,
@@ -58,10 +213,16 @@ func (h *Hub) Register(c *Client) (*Room, error) {
 			Payload: map[string]any{
 				"messages": history,
 				"nextSeq":  nextSeq,
+				"hasMore":  hasMore,
 			},
 		})
 	}
 
+	if participant == nil {
+		// Observers monitor the room without announcing themselves.
+		return room
+	}
+
 	joinContent := fmt.Sprintf("%s (%s) 加入對話", participant.DisplayName, participant.Role)
 	h.broadcast(room, Envelope{
 		Cmd:         MessageTypeSystem,
@@ -73,21 +234,64 @@ func (h *Hub) Register(c *Client) (*Room, error) {
 		SenderRole:  c.Role,
 		DisplayName: c.DisplayName,
 	})
+	if participant.Role == RoleAgent {
+		h.publishAgentPresence(c, true)
+	}
+	if participant.Role == RolePlayer {
+		h.deliverOutreach(room)
+		fresh := room.NextSequence() == 0
+		h.sendGreeting(room)
+		if fresh {
+			h.startBot(room)
+		}
+		if h.priorityResolver != nil {
+			go h.resolvePriority(room, c.Agency, c.ID)
+		}
+		now := time.Now()
+		if reason := h.SupportOffline(room.Agency(), now); reason != "" {
+			h.sendOfflineNotice(room, c, reason, now)
+		} else if h.queueNoticeInterval > 0 {
+			if position, _, _, ok := h.QueuePosition(room.ID(), now); ok {
+				h.sendQueueNotice(room, position, now, c)
+			}
+		}
+		h.sendAnnouncements(room, c)
+	}
 
-	return room, nil
+	return room
 }
 
+// Unregister detaches a client from every room it belongs to.
 func (h *Hub) Unregister(c *Client) {
+	if c.Multiplexed {
+		h.mu.Lock()
+		delete(h.multiplexed, c)
+		h.mu.Unlock()
+		for _, roomID := range c.Subscriptions() {
+			h.detach(c, roomID)
+		}
+		h.publishAgentPresence(c, false)
+		return
+	}
 	if c.RoomID == "" {
 		return
 	}
+	h.detach(c, c.RoomID)
+}
 
-	room := h.getRoom(c.RoomID)
+func (h *Hub) detach(c *Client, roomID string) {
+	room := h.getRoom(roomID)
 	if room == nil {
 		return
 	}
 
-	room.RemoveClient(c)
+	if !room.RemoveClient(c) {
+		return
+	}
+	h.publishRoomEvent(EventRoomUpdated, room)
+	if c.Role == RoleAgent && !c.Multiplexed {
+		h.publishAgentPresence(c, false)
+	}
 
 	leaveContent := fmt.Sprintf("%s 離開對話", c.DisplayName)
 	h.broadcast(room, Envelope{
@@ -103,17 +307,48 @@ func (h *Hub) Unregister(c *Client) {
 }
 
 func (h *Hub) HandleIncoming(c *Client, env Envelope) error {
-	room := h.getRoom(c.RoomID)
-	if room == nil {
-		return ErrRoomNotFound
+	env.Normalize()
+
+	if env.Cmd == MessageTypeAgentStatus {
+		return h.handleAgentStatusCommand(c, env)
 	}
 
+	if c.Multiplexed {
+		switch env.Cmd {
+		case MessageTypeSubscribe:
+			return h.subscribe(c, env.RoomID)
+		case MessageTypeUnsubscribe:
+			return h.unsubscribe(c, env.RoomID)
+		}
+	}
+
+	room, err := h.roomFor(c, env)
+	if err != nil {
+		return err
+	}
+	roomID := room.ID()
+
 	now := time.Now()
 	if env.Timestamp.IsZero() {
 		env.Timestamp = now
 	}
 
-	env.Normalize()
+	if room.IsObserver(c) {
+		switch env.Cmd {
+		case MessageTypeHistory, MessageTypeInternal:
+		case MessageTypeBargeIn:
+			return h.bargeIn(room, c)
+		default:
+			return ErrNotPermitted
+		}
+	}
+
+	canned := env.Cmd == MessageTypeCannedSend
+	if canned {
+		if err := h.renderCannedCommand(c, room, &env); err != nil {
+			return err
+		}
+	}
 
 	switch env.Cmd {
 	case MessageTypeChat:
@@ -121,30 +356,65 @@ func (h *Hub) HandleIncoming(c *Client, env Envelope) error {
 			return errors.New("content is required")
 		}
 
-		chat := ChatMessage{
-			RoomID:      c.RoomID,
+		if c.Role == RolePlayer && room.Status() != RoomStatusOpen && !h.leavingMessage(room, env.Timestamp) {
+			if err := h.transitionRoom(room, RoomStatusOpen, c.ID, c.Role, c.DisplayName); err != nil {
+				return err
+			}
+		}
+
+		metadata := clientMetadata(c.Role, env.Metadata)
+		if canned {
+			metadata = env.Metadata
+		}
+		stored := h.appendMessage(room, ChatMessage{
+			RoomID:      roomID,
+			Type:        MessageTypeChat,
 			SenderID:    c.ID,
 			SenderRole:  c.Role,
 			DisplayName: c.DisplayName,
 			Content:     env.Content,
+			Metadata:    metadata,
 			Timestamp:   env.Timestamp,
+		}, env)
+		if c.Role == RolePlayer {
+			if option := env.Metadata["menuOption"]; option != "" {
+				h.applyMenuChoice(room, option)
+			}
+			h.runBot(room, env, stored)
+			h.recordLeftMessage(room, stored.Timestamp)
+		}
+	case MessageTypeInternal:
+		if c.Role == RolePlayer {
+			return ErrNotPermitted
+		}
+		if env.Content == "" {
+			return errors.New("content is required")
 		}
-		stored := room.AddMessage(chat)
 
-		env.Cmd = MessageTypeChat
-		env.Type = MessageTypeChat
-		env.RoomID = c.RoomID
+		stored := room.AddMessage(ChatMessage{
+			RoomID:      roomID,
+			Type:        MessageTypeInternal,
+			SenderID:    c.ID,
+			SenderRole:  c.Role,
+			DisplayName: c.DisplayName,
+			Content:     env.Content,
+			Timestamp:   env.Timestamp,
+		})
+		h.persistMessage(stored)
+
+		env.RoomID = roomID
 		env.SenderID = c.ID
 		env.SenderRole = c.Role
 		env.DisplayName = c.DisplayName
 		env.Timestamp = stored.Timestamp
 		env.Seq = stored.Sequence
 		env.Ack = stored.Sequence
-		h.broadcast(room, env)
+		h.broadcastToAgents(room, env)
+		h.publishMessageEvent(room, stored)
 	case MessageTypeTyping:
 		env.Cmd = MessageTypeTyping
 		env.Type = MessageTypeTyping
-		env.RoomID = c.RoomID
+		env.RoomID = roomID
 		env.SenderID = c.ID
 		env.SenderRole = c.Role
 		env.DisplayName = c.DisplayName
@@ -152,20 +422,38 @@ func (h *Hub) HandleIncoming(c *Client, env Envelope) error {
 		env.Ack = room.NextSequence()
 		h.broadcast(room, env)
 	case MessageTypeHistory:
+		if before := metadataInt(env.Metadata, "before"); before > 0 {
+			limit := clampHistoryLimit(int(metadataInt(env.Metadata, "limit")))
+			history, hasMore := h.visibleMessagesBefore(c, room, before, limit)
+			nextSeq := room.NextSequence()
+			return c.SendEnvelope(Envelope{
+				Cmd:       MessageTypeHistory,
+				Type:      MessageTypeHistory,
+				RoomID:    roomID,
+				Timestamp: env.Timestamp,
+				History:   history,
+				Seq:       nextSeq,
+				Ack:       nextSeq,
+				Payload: map[string]any{
+					"messages": history,
+					"nextSeq":  nextSeq,
+					"before":   before,
+					"hasMore":  hasMore,
+				},
+			})
+		}
+
 		since := env.Seq
-		if since == 0 && env.Metadata != nil {
-			if value, ok := env.Metadata["since"]; ok {
-				if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
-					since = parsed
-				}
-			}
+		if since == 0 {
+			since = metadataInt(env.Metadata, "since")
 		}
 
-		history, nextSeq := room.MessagesSince(since)
+		history, nextSeq := h.messagesSince(room, since)
+		history = visibleHistory(c, history)
 		response := Envelope{
 			Cmd:       MessageTypeHistory,
 			Type:      MessageTypeHistory,
-			RoomID:    c.RoomID,
+			RoomID:    roomID,
 			Timestamp: env.Timestamp,
 			History:   history,
 			Seq:       nextSeq,
@@ -176,6 +464,10 @@ func (h *Hub) HandleIncoming(c *Client, env Envelope) error {
 			},
 		}
 		return c.SendEnvelope(response)
+	case MessageTypeTransfer, MessageTypeTransferAccept:
+		return h.handleTransferCommand(c, roomID, env)
+	case MessageTypeBargeIn:
+		return nil
 	default:
 		return ErrUnknownMessage
 	}
@@ -183,6 +475,33 @@ func (h *Hub) HandleIncoming(c *Client, env Envelope) error {
 	return nil
 }
 
+// bargeIn converts an observing supervisor into a visible agent of the room.
+func (h *Hub) bargeIn(room *Room, c *Client) error {
+	participant, ok := room.promoteObserver(c)
+	if !ok {
+		return ErrClientNotInRoom
+	}
+
+	h.broadcast(room, Envelope{
+		Cmd:         MessageTypeSystem,
+		Type:        MessageTypeSystem,
+		RoomID:      room.ID(),
+		Timestamp:   time.Now(),
+		Content:     fmt.Sprintf("%s (%s) 加入對話", participant.DisplayName, participant.Role),
+		SenderID:    c.ID,
+		SenderRole:  c.Role,
+		DisplayName: c.DisplayName,
+		Metadata: map[string]string{
+			"bargeIn": "true",
+		},
+	})
+	h.publishRoomEvent(EventRoomUpdated, room)
+	h.publishAgentPresence(c, true)
+	return nil
+}
+
+// Rooms lists the rooms held in memory, most recently active first. Evicted
+// rooms are left out until they are loaded back.
 func (h *Hub) Rooms() []RoomSummary {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -197,20 +516,58 @@ func (h *Hub) Rooms() []RoomSummary {
 	return summaries
 }
 
+// RoomSnapshot returns the state of a room. An evicted room is read from the
+// room store without being loaded back, so its snapshot carries no history;
+// older messages are paged through MessagesBefore.
 func (h *Hub) RoomSnapshot(roomID string) (RoomSnapshot, error) {
-	room := h.getRoom(roomID)
+	room := h.storedRoom(roomID)
 	if room == nil {
 		return RoomSnapshot{}, ErrRoomNotFound
 	}
 	return room.Snapshot(), nil
 }
 
+// AssignAgent assigns the room to an agent. It refuses agents who are not
+// available, are at their concurrent chat limit or lack a skill the room
+// requires.
 func (h *Hub) AssignAgent(roomID, agentID, displayName string) (*Participant, error) {
-	room := h.getRoom(roomID)
+	assigned, _, err := h.assignAgent(roomID, agentID, displayName, false, "")
+	return assigned, err
+}
+
+// ForceAssignAgent assigns the room even when the agent is over capacity or
+// lacks a required skill, returning those problems as warnings. Agents who are
+// not available are still refused.
+func (h *Hub) ForceAssignAgent(roomID, agentID, displayName string) (*Participant, []string, error) {
+	return h.assignAgent(roomID, agentID, displayName, true, "")
+}
+
+// assignAgent assigns the room after the availability, capacity and skill
+// checks. The note, if any, is recorded with the assignment ahead of the
+// warnings.
+func (h *Hub) assignAgent(roomID, agentID, displayName string, force bool, note string) (*Participant, []string, error) {
+	room := h.knownRoom(roomID)
 	if room == nil {
-		return nil, ErrRoomNotFound
+		return nil, nil, ErrRoomNotFound
 	}
 
+	if err := h.agentAvailable(agentID); err != nil {
+		return nil, nil, err
+	}
+	problems, err := h.assignmentProblems(room, agentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(problems) > 0 && !force {
+		return nil, nil, problems[0]
+	}
+	if _, waiting := room.waiting(); waiting {
+		h.recordThroughput(room.Agency(), time.Now())
+	}
+	warnings := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		warnings = append(warnings, problem.Error())
+	}
 	if presence := h.agentPresence(agentID); presence != nil && presence.DisplayName != "" {
 		displayName = presence.DisplayName
 	}
@@ -218,8 +575,19 @@ func (h *Hub) AssignAgent(roomID, agentID, displayName string) (*Participant, er
 	room.SetAssignedAgent(agentID, displayName)
 	assigned := room.AssignedAgent()
 	if assigned == nil {
-		return nil, errors.New("unable to assign agent")
+		return nil, nil, errors.New("unable to assign agent")
 	}
+	notes := warnings
+	if note != "" {
+		notes = append([]string{note}, warnings...)
+	}
+	room.recordAssignment(AssignmentRecord{
+		Action:      AssignmentAssigned,
+		AgentID:     assigned.ID,
+		DisplayName: assigned.DisplayName,
+		Note:        strings.Join(notes, "; "),
+	})
+	h.persistRoom(room)
 
 	h.broadcast(room, Envelope{
 		Cmd:         MessageTypeSystem,
@@ -235,20 +603,186 @@ func (h *Hub) AssignAgent(roomID, agentID, displayName string) (*Participant, er
 			"assignedAgentId": agentID,
 		},
 	})
+	h.publishRoomEvent(EventRoomAssigned, room)
 
-	return assigned, nil
+	return assigned, warnings, nil
 }
 
-func (h *Hub) getOrCreateRoom(roomID string) *Room {
+// joinRoom attaches the client while holding the hub lock so that the room
+// cannot be evicted between lookup and attachment.
+// It also reports whether the room was created by this join, as opposed to
+// being resident already or loaded back from the room store.
+func (h *Hub) joinRoom(c *Client, roomID string) (*Room, *Participant, bool) {
+	room := h.getRoom(roomID)
+	stored := true
+	if room == nil {
+		room, stored = h.loadRoom(roomID)
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if room, ok := h.rooms[roomID]; ok {
-		return room
+	created := false
+	if current, ok := h.rooms[roomID]; ok {
+		room = current
+	} else {
+		h.rooms[roomID] = room
+		created = !stored
 	}
+	return room, room.AddClient(c), created
+}
 
+// loadRoom builds a room, restoring persisted state and sequence numbering
+// when storage is configured. It reports whether the room store knew the room.
+func (h *Hub) loadRoom(roomID string) (*Room, bool) {
 	room := NewRoom(roomID)
-	h.rooms[roomID] = room
+	room.historyLimit = h.historyLimit
+	stored := false
+
+	if h.roomStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		state, err := h.roomStore.LoadRoomState(ctx, roomID)
+		cancel()
+		if err != nil {
+			log.Printf("load room %s state: %v", roomID, err)
+		} else if state != nil {
+			room.restoreState(*state)
+			stored = true
+		}
+	}
+
+	if h.store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		latest, err := h.store.LatestSequence(ctx, roomID)
+		cancel()
+		if err != nil {
+			log.Printf("load room %s sequence: %v", roomID, err)
+		}
+		room.restoreSequence(latest)
+	}
+
+	return room, stored
+}
+
+// appendMessage stores a message in the room, broadcasts it on top of env and
+// publishes it to the event stream. The broadcast carries the stored metadata,
+// never the metadata of env.
+func (h *Hub) appendMessage(room *Room, msg ChatMessage, env Envelope) ChatMessage {
+	stored := room.AddMessage(msg)
+	h.persistMessage(stored)
+
+	env.Cmd = stored.Type
+	env.Type = stored.Type
+	env.RoomID = stored.RoomID
+	env.SenderID = stored.SenderID
+	env.SenderRole = stored.SenderRole
+	env.DisplayName = stored.DisplayName
+	env.Content = stored.Content
+	env.Timestamp = stored.Timestamp
+	env.Seq = stored.Sequence
+	env.Ack = stored.Sequence
+	env.Metadata = stored.Metadata
+	h.broadcast(room, env)
+	h.publishMessageEvent(room, stored)
+	return stored
+}
+
+// PostMessage appends a chat message or system notice to a room on behalf of
+// an admin tool or backend, and delivers it like a message sent over a socket.
+// Chat messages are sent as an agent. Evicted rooms are loaded back first.
+func (h *Hub) PostMessage(roomID string, msg ChatMessage) (ChatMessage, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return ChatMessage{}, ErrRoomNotFound
+	}
+	msg.Content = strings.TrimSpace(msg.Content)
+	if msg.Content == "" {
+		return ChatMessage{}, errors.New("content is required")
+	}
+	switch msg.Type {
+	case "", MessageTypeChat:
+		msg.Type = MessageTypeChat
+		msg.SenderRole = RoleAgent
+	case MessageTypeSystem:
+		msg.SenderRole = ""
+	default:
+		return ChatMessage{}, fmt.Errorf("unsupported message type %q", msg.Type)
+	}
+	msg.RoomID = room.ID()
+	msg.Timestamp = time.Now()
+	return h.appendMessage(room, msg, Envelope{}), nil
+}
+
+// playerMetadataKeys lists the metadata players may attach to their chat
+// messages. Every other key is reserved for flags the hub sets itself, such as
+// greeting, outreach or botRule.
+var playerMetadataKeys = []string{"menuOption", "handoff"}
+
+// clientMetadata keeps the metadata a client of the role may store with its
+// chat message.
+func clientMetadata(role string, metadata map[string]string) map[string]string {
+	if role != RolePlayer {
+		return nil
+	}
+	var allowed map[string]string
+	for _, key := range playerMetadataKeys {
+		if value := metadata[key]; value != "" {
+			if allowed == nil {
+				allowed = make(map[string]string)
+			}
+			allowed[key] = value
+		}
+	}
+	return allowed
+}
+
+// MessageWithMetadata looks for a message whose metadata sets key to value
+// among the latest MaxHistoryPageSize messages of a room, so that a backend
+// can tell whether a message it posted before a restart reached the room.
+// Evicted rooms are loaded back first.
+func (h *Hub) MessageWithMetadata(roomID, key, value string) (ChatMessage, bool, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return ChatMessage{}, false, ErrRoomNotFound
+	}
+	history, _ := h.messagesBefore(room, 0, MaxHistoryPageSize)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Metadata[key] == value {
+			return history[i], true, nil
+		}
+	}
+	return ChatMessage{}, false, nil
+}
+
+// knownRoom returns a resident room, loading it back when it was evicted but
+// its state is still in the room store.
+func (h *Hub) knownRoom(roomID string) *Room {
+	if room := h.getRoom(roomID); room != nil {
+		return room
+	}
+	if h.roomStore == nil {
+		return nil
+	}
+	room, stored := h.loadRoom(roomID)
+	if !stored {
+		return nil
+	}
+	return h.keepRoom(room)
+}
+
+// storedRoom returns a resident room or, for an evicted room, one built from
+// the room store without being kept, so that reads do not load rooms back.
+func (h *Hub) storedRoom(roomID string) *Room {
+	if room := h.getRoom(roomID); room != nil {
+		return room
+	}
+	if h.roomStore == nil {
+		return nil
+	}
+	room, stored := h.loadRoom(roomID)
+	if !stored {
+		return nil
+	}
 	return room
 }
 
@@ -260,6 +794,25 @@ func (h *Hub) getRoom(roomID string) *Room {
 }
 
 func (h *Hub) broadcast(room *Room, env Envelope) {
+	h.broadcastTo(room, env, nil)
+}
+
+// broadcastToPlayers delivers an envelope to the player clients of a room only.
+func (h *Hub) broadcastToPlayers(room *Room, env Envelope) {
+	h.broadcastTo(room, env, func(c *Client) bool {
+		return c.Role == RolePlayer
+	})
+}
+
+// broadcastToAgents delivers an envelope to every non-player client of a room,
+// which is where internal information such as handoff notes may be shown.
+func (h *Hub) broadcastToAgents(room *Room, env Envelope) {
+	h.broadcastTo(room, env, func(c *Client) bool {
+		return c.Role != RolePlayer
+	})
+}
+
+func (h *Hub) broadcastTo(room *Room, env Envelope, include func(*Client) bool) {
 	env.Normalize()
 
 	if env.Timestamp.IsZero() {
@@ -273,24 +826,165 @@ func (h *Hub) broadcast(room *Room, env Envelope) {
 
 	clients := room.Clients()
 	for _, client := range clients {
-		select {
-		case client.send <- payload:
-		default:
-			// drop message if buffer is full to avoid blocking
+		if include != nil && !include(client) {
+			continue
 		}
+		client.deliver(payload)
 	}
 }
 
 // MessagesSince returns chat history newer than the provided sequence number.
+// Evicted rooms are read from the stores without being loaded back.
 func (h *Hub) MessagesSince(roomID string, sequence int64) ([]ChatMessage, int64, error) {
-	room := h.getRoom(roomID)
+	room := h.storedRoom(roomID)
 	if room == nil {
 		return nil, 0, ErrRoomNotFound
 	}
-	history, next := room.MessagesSince(sequence)
+	history, next := h.messagesSince(room, sequence)
 	return history, next, nil
 }
 
+// messagesSince reads the messages newer than sequence from memory and falls
+// back to the message store for those no longer held by the room, at most
+// MaxHistoryPageSize of them.
+func (h *Hub) messagesSince(room *Room, sequence int64) ([]ChatMessage, int64) {
+	history, next := room.MessagesSince(sequence)
+	first := next + 1
+	if len(history) > 0 {
+		first = history[0].Sequence
+	}
+	missing := first - max(sequence, 0) - 1
+	if h.store == nil || missing <= 0 {
+		return history, next
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	older, err := h.store.MessagesBefore(ctx, room.ID(), first, int(min(missing, MaxHistoryPageSize)))
+	cancel()
+	if err != nil {
+		log.Printf("load room %s history: %v", room.ID(), err)
+		return history, next
+	}
+	return append(older, history...), next
+}
+
+// MessagesBefore returns up to limit messages older than the provided sequence
+// and reports whether even older messages exist. Evicted rooms are read from
+// the stores without being loaded back.
+func (h *Hub) MessagesBefore(roomID string, before int64, limit int) ([]ChatMessage, bool, int64, error) {
+	room := h.storedRoom(roomID)
+	if room == nil {
+		return nil, false, 0, ErrRoomNotFound
+	}
+	history, hasMore := h.messagesBefore(room, before, clampHistoryLimit(limit))
+	return history, hasMore, room.NextSequence(), nil
+}
+
+// messagesBefore reads a page from memory and falls back to the message store
+// for the part of the page that has already been trimmed from the room.
+func (h *Hub) messagesBefore(room *Room, before int64, limit int) ([]ChatMessage, bool) {
+	history := room.MessagesBefore(before, limit)
+
+	cursor := before
+	if len(history) > 0 {
+		cursor = history[0].Sequence
+	} else if cursor <= 0 {
+		cursor = room.NextSequence() + 1
+	}
+
+	if h.store != nil && len(history) < limit && cursor > 1 {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		older, err := h.store.MessagesBefore(ctx, room.ID(), cursor, limit-len(history))
+		cancel()
+		if err != nil {
+			log.Printf("load room %s history: %v", room.ID(), err)
+		} else if len(older) > 0 {
+			history = append(older, history...)
+		}
+	}
+
+	// Without a message store, trimmed messages are gone and only what is
+	// still in memory can be paged to.
+	hasMore := false
+	if len(history) > 0 {
+		if h.store != nil {
+			hasMore = history[0].Sequence > 1
+		} else {
+			hasMore = room.hasMessagesBefore(history[0].Sequence)
+		}
+	}
+	return history, hasMore
+}
+
+// visibleMessagesBefore reads a page of the history the client may see. Pages
+// for players keep reading further back until the internal notes they may not
+// see have been replaced, so that a short page means the history is exhausted.
+func (h *Hub) visibleMessagesBefore(c *Client, room *Room, before int64, limit int) ([]ChatMessage, bool) {
+	history, hasMore := h.messagesBefore(room, before, limit)
+	if c.Role != RolePlayer {
+		return history, hasMore
+	}
+	visible := visibleHistory(c, history)
+	for hasMore && len(visible) < limit {
+		history, hasMore = h.messagesBefore(room, history[0].Sequence, limit-len(visible))
+		if len(history) == 0 {
+			break
+		}
+		visible = append(visibleHistory(c, history), visible...)
+	}
+	return visible, hasMore
+}
+
+func (h *Hub) persistMessage(msg ChatMessage) {
+	if h.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := h.store.SaveMessage(ctx, msg); err != nil {
+		log.Printf("persist room %s message %d: %v", msg.RoomID, msg.Sequence, err)
+	}
+}
+
+// visibleHistory drops internal messages from history sent to players.
+func visibleHistory(c *Client, history []ChatMessage) []ChatMessage {
+	if c.Role != RolePlayer {
+		return history
+	}
+	visible := history[:0:0]
+	for _, msg := range history {
+		if !msg.IsInternal() {
+			visible = append(visible, msg)
+		}
+	}
+	return visible
+}
+
+func clampHistoryLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultHistoryPageSize
+	}
+	if limit > MaxHistoryPageSize {
+		return MaxHistoryPageSize
+	}
+	return limit
+}
+
+func metadataInt(metadata map[string]string, key string) int64 {
+	if metadata == nil {
+		return 0
+	}
+	value, ok := metadata[key]
+	if !ok {
+		return 0
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return parsed
+}
+
 // OnlineAgents returns unique connected agents across all rooms.
 func (h *Hub) OnlineAgents() []AgentPresence {
 	h.mu.RLock()
@@ -320,8 +1014,33 @@ func (h *Hub) OnlineAgents() []AgentPresence {
 		}
 	}
 
+	// Multiplexed agents are online even before subscribing to any room.
+	for client := range h.multiplexed {
+		if client.Role != RoleAgent {
+			continue
+		}
+		if _, ok := catalog[client.ID]; ok {
+			continue
+		}
+		catalog[client.ID] = &AgentPresence{
+			ID:          client.ID,
+			DisplayName: client.DisplayName,
+			Rooms:       make([]string, 0),
+			LastSeen:    time.Now(),
+		}
+	}
+
+	for _, c := range h.agentConnectionsLocked(func(*Client) bool { return true }) {
+		if entry, ok := catalog[c.ID]; ok {
+			entry.Agency = c.Agency
+		}
+	}
+
 	presences := make([]AgentPresence, 0, len(catalog))
 	for _, presence := range catalog {
+		status := h.cachedAgentStatus(presence.ID)
+		presence.Status = status.Status
+		presence.StatusChangedAt = status.ChangedAt
 		presences = append(presences, *presence)
 	}
 	sort.Slice(presences, func(i, j int) bool {
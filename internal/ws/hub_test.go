@@ -1,41 +1,47 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 type testClient struct {
 	*Client
+	mem *MemoryConn
 }
 
 func newTestClient(h *Hub, roomID, role, id, name string) *testClient {
-	c := &Client{
-		hub:         h,
-		conn:        nil,
-		send:        make(chan []byte, 10),
-		ID:          id,
-		DisplayName: name,
-		Role:        role,
-		RoomID:      roomID,
+	mem := NewMemoryConn(10)
+	return &testClient{Client: NewClient(h, mem, roomID, id, role, name), mem: mem}
+}
+
+// connectAgent opens a multiplexed connection for an agent so that the agent
+// counts as online and may be assigned rooms.
+func connectAgent(t *testing.T, h *Hub, id, name string) *testClient {
+	t.Helper()
+	agent := newTestClient(h, "", RoleAgent, id, name)
+	agent.Multiplexed = true
+	if _, err := h.Register(agent.Client); err != nil {
+		t.Fatalf("register agent %s failed: %v", id, err)
 	}
-	return &testClient{Client: c}
+	return agent
 }
 
 func (c *testClient) nextEnvelope(t *testing.T) Envelope {
-	select {
-	case payload := <-c.send:
-		var env Envelope
-		if err := json.Unmarshal(payload, &env); err != nil {
-			t.Fatalf("failed to decode envelope: %v", err)
-		}
-		env.Normalize()
-		return env
-	case <-time.After(time.Second):
-		t.Fatal("timeout waiting for message")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	env, err := c.mem.Receive(ctx)
+	if err != nil {
+		t.Fatalf("waiting for message: %v", err)
 	}
-	return Envelope{}
+	return env
 }
 
 func TestHubMessageFlow(t *testing.T) {
@@ -81,6 +87,7 @@ func TestHubMessageFlow(t *testing.T) {
 		t.Fatalf("expected next sequence to be tracked")
 	}
 
+	connectAgent(t, hub, "a1", "客服A")
 	if _, err := hub.AssignAgent("room-1", "a1", "客服A"); err != nil {
 		t.Fatalf("assign agent failed: %v", err)
 	}
@@ -169,3 +176,2038 @@ func TestMessagesSince(t *testing.T) {
 		t.Fatalf("expected next sequence to advance")
 	}
 }
+
+type memoryMessageStore struct {
+	mu       sync.Mutex
+	messages map[string][]ChatMessage
+}
+
+func newMemoryMessageStore() *memoryMessageStore {
+	return &memoryMessageStore{messages: make(map[string][]ChatMessage)}
+}
+
+func (s *memoryMessageStore) SaveMessage(ctx context.Context, msg ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[msg.RoomID] = append(s.messages[msg.RoomID], msg)
+	return nil
+}
+
+func (s *memoryMessageStore) MessagesBefore(ctx context.Context, roomID string, before int64, limit int) ([]ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []ChatMessage
+	for _, msg := range s.messages[roomID] {
+		if msg.Sequence < before {
+			result = append(result, msg)
+		}
+	}
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
+
+func (s *memoryMessageStore) LatestSequence(ctx context.Context, roomID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.messages[roomID]
+	if len(history) == 0 {
+		return 0, nil
+	}
+	return history[len(history)-1].Sequence, nil
+}
+
+func TestMessagesBefore(t *testing.T) {
+	store := newMemoryMessageStore()
+	for i := int64(1); i <= 5; i++ {
+		_ = store.SaveMessage(context.Background(), ChatMessage{RoomID: "room-p", Sequence: i, Content: fmt.Sprintf("old-%d", i)})
+	}
+
+	hub := NewHub(WithMessageStore(store), WithInitialHistoryLimit(3))
+	client := newTestClient(hub, "room-p", RolePlayer, "pp", "玩家P")
+	if _, err := hub.Register(client.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	initial := client.nextEnvelope(t)
+	if initial.Cmd != MessageTypeHistory {
+		t.Fatalf("expected initial history, got %s", initial.Cmd)
+	}
+	if len(initial.History) != 3 || initial.History[0].Sequence != 3 {
+		t.Fatalf("expected last 3 persisted messages, got %+v", initial.History)
+	}
+	if initial.Payload["hasMore"] != true {
+		t.Fatalf("expected hasMore in initial history, got %+v", initial.Payload)
+	}
+	_ = client.nextEnvelope(t) // consume join
+
+	if err := hub.HandleIncoming(client.Client, Envelope{Cmd: MessageTypeChat, Content: "new"}); err != nil {
+		t.Fatalf("send message failed: %v", err)
+	}
+	latest := client.nextEnvelope(t)
+	if latest.Seq != 6 {
+		t.Fatalf("expected sequence to continue after persisted history, got %d", latest.Seq)
+	}
+
+	history, hasMore, _, err := hub.MessagesBefore("room-p", 7, 2)
+	if err != nil {
+		t.Fatalf("messages before failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Sequence != 5 || history[1].Sequence != 6 {
+		t.Fatalf("expected sequences 5 and 6, got %+v", history)
+	}
+	if !hasMore {
+		t.Fatalf("expected more history to be available")
+	}
+
+	history, hasMore, _, err = hub.MessagesBefore("room-p", 3, 10)
+	if err != nil {
+		t.Fatalf("messages before failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Content != "old-1" {
+		t.Fatalf("expected oldest page from store, got %+v", history)
+	}
+	if hasMore {
+		t.Fatalf("expected no more history before the first message")
+	}
+}
+
+func TestMessagesBeforeWithoutStore(t *testing.T) {
+	hub := NewHub(WithHistoryLimit(3))
+	client := newTestClient(hub, "room-t", RolePlayer, "pt", "玩家T")
+	if _, err := hub.Register(client.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	_ = client.nextEnvelope(t) // consume join
+
+	for i := 1; i <= 5; i++ {
+		if err := hub.HandleIncoming(client.Client, Envelope{Cmd: MessageTypeChat, Content: fmt.Sprintf("msg-%d", i)}); err != nil {
+			t.Fatalf("send message failed: %v", err)
+		}
+		_ = client.nextEnvelope(t)
+	}
+
+	history, hasMore, _, err := hub.MessagesBefore("room-t", 6, 2)
+	if err != nil {
+		t.Fatalf("messages before failed: %v", err)
+	}
+	if len(history) != 2 || history[0].Sequence != 4 || !hasMore {
+		t.Fatalf("expected sequences 4 and 5 with more in memory, got %+v (hasMore=%v)", history, hasMore)
+	}
+
+	history, hasMore, _, err = hub.MessagesBefore("room-t", 4, 2)
+	if err != nil {
+		t.Fatalf("messages before failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Sequence != 3 {
+		t.Fatalf("expected the oldest resident message, got %+v", history)
+	}
+	if hasMore {
+		t.Fatalf("expected no more history once trimmed messages are gone")
+	}
+}
+
+type memoryRoomStore struct {
+	mu     sync.Mutex
+	states map[string]RoomState
+	loads  int
+}
+
+func newMemoryRoomStore() *memoryRoomStore {
+	return &memoryRoomStore{states: make(map[string]RoomState)}
+}
+
+func (s *memoryRoomStore) SaveRoomState(ctx context.Context, state RoomState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state.RoomID] = state
+	return nil
+}
+
+func (s *memoryRoomStore) LoadRoomState(ctx context.Context, roomID string) (*RoomState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	state, ok := s.states[roomID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s *memoryRoomStore) LoadActiveRoomState(ctx context.Context, agency, ownerID string) (*RoomState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var active *RoomState
+	for _, state := range s.states {
+		if state.Agency != agency || state.OwnerID != ownerID || state.Status == RoomStatusClosed {
+			continue
+		}
+		if active == nil || state.LastActivity.After(active.LastActivity) {
+			state := state
+			active = &state
+		}
+	}
+	return active, nil
+}
+
+func (s *memoryRoomStore) LoadInactiveRoomStates(ctx context.Context, before time.Time, limit int) ([]RoomState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make([]RoomState, 0)
+	for _, state := range s.states {
+		if state.Status != RoomStatusClosed && state.LastActivity.Before(before) && len(states) < limit {
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+func (s *memoryRoomStore) ActiveRoomIDs(ctx context.Context, agentID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0)
+	for _, state := range s.states {
+		if state.AssignedAgentID == agentID && (state.Status == RoomStatusOpen || state.Status == RoomStatusPending) {
+			ids = append(ids, state.RoomID)
+		}
+	}
+	return ids, nil
+}
+
+func TestRoomStatePersistence(t *testing.T) {
+	plain := NewHub(WithRoomEviction(time.Minute, 0))
+	if _, _, err := plain.CreateRoom("", "p1"); err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if evicted := plain.EvictIdleRooms(time.Now().Add(time.Hour)); evicted != 0 {
+		t.Fatalf("expected rooms to stay resident without a room store, evicted %d", evicted)
+	}
+
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms))
+	summary, _, err := hub.CreateRoom("", "p2")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if _, err := hub.SetRoomStatus(summary.RoomID, RoomStatusResolved, "a1", RoleAgent, "客服A"); err != nil {
+		t.Fatalf("set status failed: %v", err)
+	}
+	state, _ := rooms.LoadRoomState(context.Background(), summary.RoomID)
+	if state == nil || state.Status != RoomStatusResolved {
+		t.Fatalf("expected status change to be persisted, got %+v", state)
+	}
+
+	if _, err := hub.SetRoomPriority(summary.RoomID, 3); err != nil {
+		t.Fatalf("set priority failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rooms.states = make(map[string]RoomState)
+	hub.Run(ctx)
+	state, _ = rooms.LoadRoomState(context.Background(), summary.RoomID)
+	if state == nil || state.Priority != 3 {
+		t.Fatalf("expected resident rooms to be saved on shutdown, got %+v", state)
+	}
+}
+
+func TestHistoryLimitAndEviction(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms), WithHistoryLimit(2), WithRoomEviction(time.Minute, 0))
+	client := newTestClient(hub, "room-e", RolePlayer, "pe", "玩家E")
+
+	if _, err := hub.Register(client.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	_ = client.nextEnvelope(t) // consume join
+
+	for _, content := range []string{"one", "two", "three"} {
+		if err := hub.HandleIncoming(client.Client, Envelope{Cmd: MessageTypeChat, Content: content}); err != nil {
+			t.Fatalf("send message failed: %v", err)
+		}
+		_ = client.nextEnvelope(t)
+	}
+
+	metrics := hub.Metrics()
+	if metrics.ResidentRooms != 1 || metrics.ResidentMessages != 2 {
+		t.Fatalf("expected 1 room with 2 resident messages, got %+v", metrics)
+	}
+
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 0 {
+		t.Fatalf("expected connected room to stay resident, evicted %d", evicted)
+	}
+
+	hub.Unregister(client.Client)
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Fatalf("expected idle room to be evicted, evicted %d", evicted)
+	}
+	if metrics := hub.Metrics(); metrics.ResidentRooms != 0 || metrics.EvictedRooms != 1 {
+		t.Fatalf("unexpected metrics after eviction: %+v", metrics)
+	}
+	if state, ok := rooms.states["room-e"]; !ok || state.NextSequence != 3 {
+		t.Fatalf("expected room state to be persisted, got %+v", rooms.states)
+	}
+
+	sub := hub.SubscribeEvents("")
+	defer hub.UnsubscribeEvents(sub)
+	rejoin := newTestClient(hub, "room-e", RolePlayer, "pe", "玩家E")
+	if _, err := hub.Register(rejoin.Client); err != nil {
+		t.Fatalf("re-register failed: %v", err)
+	}
+	_ = rejoin.nextEnvelope(t) // consume join
+	if evt := nextEvent(t, sub); evt.Type != EventRoomUpdated {
+		t.Fatalf("expected reloaded room not to be announced as created, got %s", evt.Type)
+	}
+	if err := hub.HandleIncoming(rejoin.Client, Envelope{Cmd: MessageTypeChat, Content: "four"}); err != nil {
+		t.Fatalf("send message failed: %v", err)
+	}
+	if msg := rejoin.nextEnvelope(t); msg.Seq != 4 {
+		t.Fatalf("expected sequence to continue after reload, got %d", msg.Seq)
+	}
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	hub := NewHub(WithAutoClose(time.Hour))
+	client := newTestClient(hub, "room-l", RolePlayer, "pl", "玩家L")
+
+	if _, err := hub.Register(client.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	_ = client.nextEnvelope(t) // consume join
+
+	summary, err := hub.SetRoomStatus("room-l", RoomStatusResolved, "a1", RoleAgent, "客服A")
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if summary.Status != RoomStatusResolved {
+		t.Fatalf("expected resolved status, got %s", summary.Status)
+	}
+	notice := client.nextEnvelope(t)
+	if notice.Metadata["status"] != RoomStatusResolved || notice.Metadata["previousStatus"] != RoomStatusOpen {
+		t.Fatalf("unexpected transition notice: %+v", notice.Metadata)
+	}
+
+	if _, err := hub.SetRoomStatus("room-l", RoomStatusPending, "a1", RoleAgent, "客服A"); err != ErrInvalidTransition {
+		t.Fatalf("expected invalid transition, got %v", err)
+	}
+
+	if err := hub.HandleIncoming(client.Client, Envelope{Cmd: MessageTypeChat, Content: "還有問題"}); err != nil {
+		t.Fatalf("send message failed: %v", err)
+	}
+	reopen := client.nextEnvelope(t)
+	if reopen.Metadata["status"] != RoomStatusOpen {
+		t.Fatalf("expected player message to reopen room, got %+v", reopen)
+	}
+	_ = client.nextEnvelope(t) // consume chat message
+
+	if closed := hub.CloseInactiveRooms(time.Now().Add(2 * time.Hour)); closed != 1 {
+		t.Fatalf("expected inactive room to close, closed %d", closed)
+	}
+	if rooms := hub.Rooms(); rooms[0].Status != RoomStatusClosed {
+		t.Fatalf("expected closed status, got %s", rooms[0].Status)
+	}
+}
+
+func TestCloseInactiveEvictedRooms(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms), WithRoomEviction(time.Minute, 0), WithAutoClose(time.Hour))
+	summary, _, err := hub.CreateRoom("", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Fatalf("expected idle room to be evicted, evicted %d", evicted)
+	}
+
+	if closed := hub.CloseInactiveRooms(time.Now().Add(2 * time.Hour)); closed != 1 {
+		t.Fatalf("expected evicted room to be auto-closed, closed %d", closed)
+	}
+	state, _ := rooms.LoadRoomState(context.Background(), summary.RoomID)
+	if state == nil || state.Status != RoomStatusClosed {
+		t.Fatalf("expected closed status to be persisted, got %+v", state)
+	}
+}
+
+func TestTransferRoom(t *testing.T) {
+	hub := NewHub()
+	player := newTestClient(hub, "room-t", RolePlayer, "pt", "玩家T")
+	agent := newTestClient(hub, "room-t", RoleAgent, "a1", "客服A")
+
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register player failed: %v", err)
+	}
+	_ = player.nextEnvelope(t) // consume player join
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register agent failed: %v", err)
+	}
+	_ = player.nextEnvelope(t) // consume agent join
+	_ = agent.nextEnvelope(t)
+
+	target := connectAgent(t, hub, "a2", "客服B")
+
+	if err := hub.HandleIncoming(agent.Client, Envelope{
+		Cmd:      MessageTypeTransfer,
+		Metadata: map[string]string{"toAgentId": "a2"},
+	}); err != ErrNotPermitted {
+		t.Fatalf("expected unassigned agent to be refused a transfer, got %v", err)
+	}
+
+	if _, err := hub.AssignAgent("room-t", "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+	_ = agent.nextEnvelope(t)
+
+	if err := hub.HandleIncoming(agent.Client, Envelope{
+		Cmd:      MessageTypeTransfer,
+		Content:  "玩家詢問提款延遲",
+		Metadata: map[string]string{"toAgentId": "a2", "toAgent": "客服B"},
+	}); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+
+	playerNotice := player.nextEnvelope(t)
+	if playerNotice.Metadata["note"] != "" || playerNotice.Content == "玩家詢問提款延遲" {
+		t.Fatalf("handoff note leaked to player: %+v", playerNotice)
+	}
+	agentNotice := agent.nextEnvelope(t)
+	if agentNotice.Metadata["note"] != "玩家詢問提款延遲" {
+		t.Fatalf("expected agent to see handoff note, got %+v", agentNotice.Metadata)
+	}
+	if targetNotice := target.nextEnvelope(t); targetNotice.Metadata["transfer"] != "requested" {
+		t.Fatalf("expected receiving agent to be told about the transfer, got %+v", targetNotice)
+	}
+
+	if _, err := hub.AcceptTransfer("room-t", "a3"); err != ErrTransferNotForAgent {
+		t.Fatalf("expected transfer to be addressed to a2, got %v", err)
+	}
+	assigned, err := hub.AcceptTransfer("room-t", "a2")
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	if assigned.DisplayName != "客服B" {
+		t.Fatalf("expected 客服B to be assigned, got %s", assigned.DisplayName)
+	}
+	if accepted := player.nextEnvelope(t); accepted.Metadata["assignedAgentId"] != "a2" || accepted.Metadata["note"] != "" {
+		t.Fatalf("unexpected player notice after accept: %+v", accepted)
+	}
+
+	assignments, err := hub.Assignments("room-t")
+	if err != nil {
+		t.Fatalf("assignments failed: %v", err)
+	}
+	if len(assignments) != 2 || assignments[1].Action != AssignmentTransferred || assignments[1].FromAgentID != "a1" {
+		t.Fatalf("unexpected assignment history: %+v", assignments)
+	}
+}
+
+func TestInternalMessages(t *testing.T) {
+	hub := NewHub()
+	player := newTestClient(hub, "room-i", RolePlayer, "pi", "玩家I")
+	agent := newTestClient(hub, "room-i", RoleAgent, "a1", "客服A")
+
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register player failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register agent failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+	_ = agent.nextEnvelope(t)
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeInternal, Content: "偷看"}); err != ErrNotPermitted {
+		t.Fatalf("expected players to be refused internal messages, got %v", err)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeInternal, Content: "疑似洗碼，請主管確認"}); err != nil {
+		t.Fatalf("internal message failed: %v", err)
+	}
+	if note := agent.nextEnvelope(t); note.Cmd != MessageTypeInternal {
+		t.Fatalf("expected agent to receive internal note, got %s", note.Cmd)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeChat, Content: "您好"}); err != nil {
+		t.Fatalf("chat message failed: %v", err)
+	}
+	if msg := player.nextEnvelope(t); msg.Cmd != MessageTypeChat {
+		t.Fatalf("expected player to only receive the chat message, got %s", msg.Cmd)
+	}
+	_ = agent.nextEnvelope(t)
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeHistory}); err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	history := player.nextEnvelope(t)
+	if len(history.History) != 1 || history.History[0].Content != "您好" {
+		t.Fatalf("expected player history without internal notes, got %+v", history.History)
+	}
+
+	snapshot, err := hub.RoomSnapshot("room-i")
+	if err != nil {
+		t.Fatalf("snapshot error: %v", err)
+	}
+	if len(snapshot.History) != 2 || !snapshot.History[0].IsInternal() {
+		t.Fatalf("expected snapshot to include internal note, got %+v", snapshot.History)
+	}
+
+	for _, content := range []string{"備註一", "備註二"} {
+		if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeInternal, Content: content}); err != nil {
+			t.Fatalf("internal message failed: %v", err)
+		}
+		_ = agent.nextEnvelope(t)
+	}
+	if last := hub.Rooms()[0].LastMessage; last != "您好" {
+		t.Fatalf("expected preview to skip internal notes, got %q", last)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "謝謝"}); err != nil {
+		t.Fatalf("chat message failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+	_ = agent.nextEnvelope(t)
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeHistory, Metadata: map[string]string{"before": "100", "limit": "2"}}); err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	page := player.nextEnvelope(t)
+	if len(page.History) != 2 || page.History[0].Content != "您好" || page.History[1].Content != "謝謝" {
+		t.Fatalf("expected a full page of visible messages, got %+v", page.History)
+	}
+}
+
+func TestObserverBargeIn(t *testing.T) {
+	hub := NewHub()
+	player := newTestClient(hub, "room-o", RolePlayer, "po", "玩家O")
+	supervisor := newTestClient(hub, "room-o", RoleAgent, "admin01", "主管")
+	supervisor.Observer = true
+
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register player failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+	if _, err := hub.Register(supervisor.Client); err != nil {
+		t.Fatalf("register observer failed: %v", err)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "hi"}); err != nil {
+		t.Fatalf("send message failed: %v", err)
+	}
+	if msg := player.nextEnvelope(t); msg.Cmd != MessageTypeChat {
+		t.Fatalf("expected no join notice for observer, got %s", msg.Cmd)
+	}
+	if msg := supervisor.nextEnvelope(t); msg.Content != "hi" {
+		t.Fatalf("expected observer to receive room traffic, got %+v", msg)
+	}
+
+	snapshot, _ := hub.RoomSnapshot("room-o")
+	if len(snapshot.Participants) != 1 {
+		t.Fatalf("expected observer to be hidden from participants, got %+v", snapshot.Participants)
+	}
+	if err := hub.HandleIncoming(supervisor.Client, Envelope{Cmd: MessageTypeChat, Content: "oops"}); err != ErrNotPermitted {
+		t.Fatalf("expected observer chat to be refused, got %v", err)
+	}
+
+	if err := hub.HandleIncoming(supervisor.Client, Envelope{Cmd: MessageTypeBargeIn}); err != nil {
+		t.Fatalf("barge in failed: %v", err)
+	}
+	if join := player.nextEnvelope(t); join.Metadata["bargeIn"] != "true" {
+		t.Fatalf("expected barge-in join notice, got %+v", join)
+	}
+	_ = supervisor.nextEnvelope(t)
+	if agents := hub.OnlineAgents(); len(agents) != 1 || agents[0].ID != "admin01" {
+		t.Fatalf("expected supervisor to be an online agent after barge-in, got %+v", agents)
+	}
+
+	hub.Unregister(supervisor.Client)
+	if leave := player.nextEnvelope(t); leave.Cmd != MessageTypeSystem {
+		t.Fatalf("expected leave notice after barge-in, got %s", leave.Cmd)
+	}
+}
+
+func TestMultiplexedAgent(t *testing.T) {
+	hub := NewHub()
+	p1 := newTestClient(hub, "room-m1", RolePlayer, "p1", "玩家1")
+	p2 := newTestClient(hub, "room-m2", RolePlayer, "p2", "玩家2")
+	agent := newTestClient(hub, "", RoleAgent, "a1", "客服A")
+	agent.Multiplexed = true
+
+	for _, c := range []*testClient{p1, p2} {
+		if _, err := hub.Register(c.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+		_ = c.nextEnvelope(t)
+	}
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register multiplexed agent failed: %v", err)
+	}
+	if agents := hub.OnlineAgents(); len(agents) != 1 || len(agents[0].Rooms) != 0 {
+		t.Fatalf("expected idle multiplexed agent to be online, got %+v", agents)
+	}
+
+	for _, roomID := range []string{"room-m1", "room-m2"} {
+		if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeSubscribe, RoomID: roomID}); err != nil {
+			t.Fatalf("subscribe %s failed: %v", roomID, err)
+		}
+		if join := agent.nextEnvelope(t); join.RoomID != roomID {
+			t.Fatalf("expected join notice for %s, got %+v", roomID, join)
+		}
+	}
+	_ = p1.nextEnvelope(t)
+	_ = p2.nextEnvelope(t)
+
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeChat, Content: "hi"}); err != ErrRoomIDRequired {
+		t.Fatalf("expected room id to be required, got %v", err)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeChat, RoomID: "room-m3", Content: "hi"}); err != ErrNotSubscribed {
+		t.Fatalf("expected unsubscribed room to be refused, got %v", err)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeChat, RoomID: "room-m2", Content: "您好"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if msg := p2.nextEnvelope(t); msg.Content != "您好" {
+		t.Fatalf("expected player 2 to receive message, got %+v", msg)
+	}
+	_ = agent.nextEnvelope(t)
+
+	if err := hub.HandleIncoming(p1.Client, Envelope{Cmd: MessageTypeChat, Content: "在嗎"}); err != nil {
+		t.Fatalf("player send failed: %v", err)
+	}
+	if msg := agent.nextEnvelope(t); msg.RoomID != "room-m1" || msg.Content != "在嗎" {
+		t.Fatalf("expected agent to receive room-m1 message, got %+v", msg)
+	}
+	_ = p1.nextEnvelope(t)
+
+	if agents := hub.OnlineAgents(); len(agents) != 1 || len(agents[0].Rooms) != 2 {
+		t.Fatalf("expected agent presence in both rooms, got %+v", agents)
+	}
+
+	hub.Unregister(agent.Client)
+	if leave := p1.nextEnvelope(t); leave.Cmd != MessageTypeSystem {
+		t.Fatalf("expected leave notice in room-m1, got %s", leave.Cmd)
+	}
+	if agents := hub.OnlineAgents(); len(agents) != 0 {
+		t.Fatalf("expected no online agents after disconnect, got %+v", agents)
+	}
+}
+
+func nextEvent(t *testing.T, sub *EventSubscription) HubEvent {
+	select {
+	case evt := <-sub.Events():
+		return evt
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return HubEvent{}
+}
+
+func TestEventQueueKeepsEveryEvent(t *testing.T) {
+	hub := NewHub()
+	lossy := hub.SubscribeEvents("")
+	defer hub.UnsubscribeEvents(lossy)
+	queue := hub.SubscribeEventQueue("agency-a")
+
+	total := eventBufferSize * 3
+	for i := 0; i < total; i++ {
+		hub.publish(HubEvent{Type: EventRoomUpdated, Agency: "agency-a", RoomID: fmt.Sprintf("room-%d", i)})
+	}
+	hub.publish(HubEvent{Type: EventRoomUpdated, Agency: "agency-b", RoomID: "room-other"})
+
+	for i := 0; i < total; i++ {
+		if evt := nextEvent(t, queue); evt.RoomID != fmt.Sprintf("room-%d", i) {
+			t.Fatalf("expected room-%d, got %+v", i, evt)
+		}
+	}
+	if len(lossy.Events()) != eventBufferSize {
+		t.Fatalf("expected the plain subscription to keep %d events, got %d", eventBufferSize, len(lossy.Events()))
+	}
+
+	hub.UnsubscribeEvents(queue)
+	select {
+	case _, ok := <-queue.Events():
+		if ok {
+			t.Fatal("expected no events after unsubscribing")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the queue to close after unsubscribing")
+	}
+}
+
+func TestEventStreamScopedByAgency(t *testing.T) {
+	hub := NewHub()
+	sub := hub.SubscribeEvents("agency-a")
+	defer hub.UnsubscribeEvents(sub)
+
+	other := newTestClient(hub, "room-b", RolePlayer, "pb", "玩家B")
+	other.Agency = "agency-b"
+	if _, err := hub.Register(other.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	player := newTestClient(hub, "room-a", RolePlayer, "pa", "玩家A")
+	player.Agency = "agency-a"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	_ = player.nextEnvelope(t)
+
+	created := nextEvent(t, sub)
+	if created.Type != EventRoomCreated || created.RoomID != "room-a" || created.Room.Agency != "agency-a" {
+		t.Fatalf("expected room-a created event, got %+v", created)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "請問如何儲值"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	msg := nextEvent(t, sub)
+	if msg.Type != EventMessageCreated || msg.Message.Preview != "請問如何儲值" {
+		t.Fatalf("expected message event, got %+v", msg)
+	}
+
+	connectAgent(t, hub, "a1", "客服A")
+	if _, err := hub.AssignAgent("room-a", "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	if assigned := nextEvent(t, sub); assigned.Type != EventRoomAssigned || assigned.Room.AssignedAgentID != "a1" {
+		t.Fatalf("expected assignment event, got %+v", assigned)
+	}
+
+	select {
+	case evt := <-sub.Events():
+		t.Fatalf("unexpected extra event %+v", evt)
+	default:
+	}
+}
+
+func TestFallbackSession(t *testing.T) {
+	hub := NewHub(WithSessionTTL(time.Minute))
+	session, err := hub.OpenSession(NewSessionClient(hub, "room-s", "ps", RolePlayer, "玩家S"))
+	if err != nil {
+		t.Fatalf("open session failed: %v", err)
+	}
+
+	agent := newTestClient(hub, "room-s", RoleAgent, "a1", "客服A")
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register agent failed: %v", err)
+	}
+	_ = agent.nextEnvelope(t)
+
+	if err := session.Handle(Envelope{Cmd: MessageTypeChat, Content: "WebSocket 被擋住了"}); err != nil {
+		t.Fatalf("session send failed: %v", err)
+	}
+	msg := agent.nextEnvelope(t)
+	if msg.Content != "WebSocket 被擋住了" || msg.Seq == 0 || msg.SenderID != "ps" {
+		t.Fatalf("unexpected message from session: %+v", msg)
+	}
+
+	// Player join, agent join and the echoed chat message are all queued.
+	messages, err := session.Poll(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 queued messages, got %d", len(messages))
+	}
+	var echoed Envelope
+	if err := json.Unmarshal(messages[2], &echoed); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if echoed.Seq != msg.Seq {
+		t.Fatalf("expected session to see seq %d, got %d", msg.Seq, echoed.Seq)
+	}
+
+	empty, err := session.Poll(context.Background(), 10*time.Millisecond)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected empty poll, got %d messages (%v)", len(empty), err)
+	}
+
+	if expired := hub.ExpireSessions(time.Now()); expired != 0 {
+		t.Fatalf("expected no expired sessions, got %d", expired)
+	}
+	if expired := hub.ExpireSessions(time.Now().Add(2 * time.Minute)); expired != 1 {
+		t.Fatalf("expected session to expire, got %d", expired)
+	}
+	if _, err := hub.Session(session.ID); err != ErrSessionNotFound {
+		t.Fatalf("expected session to be gone, got %v", err)
+	}
+	if leave := agent.nextEnvelope(t); leave.Cmd != MessageTypeSystem {
+		t.Fatalf("expected leave notice, got %s", leave.Cmd)
+	}
+}
+
+func TestMemoryConnClosedClient(t *testing.T) {
+	hub := NewHub()
+	player := newTestClient(hub, "room-m", RolePlayer, "pm", "玩家M")
+	agent := newTestClient(hub, "room-m", RoleAgent, "a1", "客服A")
+	for _, c := range []*testClient{player, agent} {
+		if _, err := hub.Register(c.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
+	_ = player.nextEnvelope(t)
+	_ = player.nextEnvelope(t)
+	_ = agent.nextEnvelope(t)
+
+	// A transport that closes before the client is unregistered must not
+	// break delivery to the rest of the room.
+	agent.Close()
+	if err := agent.SendEnvelope(Envelope{Cmd: MessageTypeSystem}); err != ErrConnClosed {
+		t.Fatalf("expected ErrConnClosed, got %v", err)
+	}
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "還在嗎"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if msg := player.nextEnvelope(t); msg.Content != "還在嗎" {
+		t.Fatalf("expected echoed message, got %+v", msg)
+	}
+}
+
+type memoryAgentStatusStore struct {
+	mu       sync.Mutex
+	statuses map[string]AgentStatus
+	log      []AgentStatus
+}
+
+func newMemoryAgentStatusStore() *memoryAgentStatusStore {
+	return &memoryAgentStatusStore{statuses: make(map[string]AgentStatus)}
+}
+
+func (s *memoryAgentStatusStore) SaveAgentStatus(ctx context.Context, status AgentStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status.AgentID] = status
+	s.log = append(s.log, status)
+	return nil
+}
+
+func (s *memoryAgentStatusStore) LoadAgentStatus(ctx context.Context, agentID string) (*AgentStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[agentID]
+	if !ok {
+		return nil, nil
+	}
+	return &status, nil
+}
+
+func TestAgentStatus(t *testing.T) {
+	store := newMemoryAgentStatusStore()
+	hub := NewHub(WithAgentStatusStore(store))
+	sub := hub.SubscribeEvents("")
+	defer hub.UnsubscribeEvents(sub)
+
+	player := newTestClient(hub, "room-st", RolePlayer, "p1", "玩家1")
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register player failed: %v", err)
+	}
+	agent := newTestClient(hub, "room-st", RoleAgent, "a1", "客服A")
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register agent failed: %v", err)
+	}
+	_ = agent.nextEnvelope(t)
+
+	if agents := hub.OnlineAgents(); len(agents) != 1 || agents[0].Status != AgentStatusAvailable {
+		t.Fatalf("expected agent to default to available, got %+v", agents)
+	}
+
+	err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeAgentStatus, Metadata: map[string]string{"status": "lunch"}})
+	if err != ErrInvalidAgentStatus {
+		t.Fatalf("expected invalid status error, got %v", err)
+	}
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeAgentStatus, Metadata: map[string]string{"status": AgentStatusAway}}); err != ErrNotPermitted {
+		t.Fatalf("expected players to be refused, got %v", err)
+	}
+
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeAgentStatus, Metadata: map[string]string{"status": AgentStatusAway}}); err != nil {
+		t.Fatalf("set status failed: %v", err)
+	}
+	confirm := agent.nextEnvelope(t)
+	if confirm.Cmd != MessageTypeAgentStatus || confirm.Metadata["status"] != AgentStatusAway {
+		t.Fatalf("expected status confirmation, got %+v", confirm)
+	}
+
+	var statusEvent HubEvent
+	for statusEvent.Type != EventAgentStatus {
+		statusEvent = nextEvent(t, sub)
+	}
+	if statusEvent.Agent == nil || statusEvent.Agent.Status != AgentStatusAway || statusEvent.Agent.StatusChangedAt.IsZero() {
+		t.Fatalf("unexpected status event %+v", statusEvent.Agent)
+	}
+
+	if _, err := hub.AssignAgent("room-st", "a1", "客服A"); err != ErrAgentUnavailable {
+		t.Fatalf("expected away agent to be refused, got %v", err)
+	}
+	if _, err := hub.TransferRoom("room-st", TransferRequest{ToAgentID: "a1"}); err != ErrAgentUnavailable {
+		t.Fatalf("expected transfer to away agent to be refused, got %v", err)
+	}
+
+	// A fresh hub restores the persisted status when the agent reconnects.
+	restarted := NewHub(WithAgentStatusStore(store))
+	again := newTestClient(restarted, "room-st", RoleAgent, "a1", "客服A")
+	if _, err := restarted.Register(again.Client); err != nil {
+		t.Fatalf("reconnect failed: %v", err)
+	}
+	if agents := restarted.OnlineAgents(); len(agents) != 1 || agents[0].Status != AgentStatusAway {
+		t.Fatalf("expected persisted away status, got %+v", agents)
+	}
+
+	if _, err := restarted.SetAgentStatus("a1", AgentStatusAvailable, "主管"); err != nil {
+		t.Fatalf("set status failed: %v", err)
+	}
+	if _, err := restarted.AssignAgent("room-st", "a1", "客服A"); err != nil {
+		t.Fatalf("expected available agent to be assigned: %v", err)
+	}
+	if len(store.log) != 2 {
+		t.Fatalf("expected 2 logged status changes, got %d", len(store.log))
+	}
+
+	// Agents who never chose a status are offline until they connect.
+	if status := restarted.AgentStatus("a9"); status.Status != AgentStatusOffline {
+		t.Fatalf("expected unknown agent to be offline, got %+v", status)
+	}
+	if _, err := restarted.AssignAgent("room-st", "a9", "客服Z"); err != ErrAgentUnavailable {
+		t.Fatalf("expected disconnected agent to be refused, got %v", err)
+	}
+}
+
+func TestAgentCapacityAndSkills(t *testing.T) {
+	hub := NewHub()
+	for _, roomID := range []string{"room-c1", "room-c2", "room-c3"} {
+		player := newTestClient(hub, roomID, RolePlayer, "p-"+roomID, "玩家")
+		if _, err := hub.Register(player.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
+
+	profile, err := hub.SetAgentProfile(AgentProfile{
+		AgentID:       "a1",
+		MaxConcurrent: 1,
+		Languages:     []string{"zh-TW", " EN "},
+		Skills:        []string{"Payments", "payments", "vip"},
+	})
+	if err != nil {
+		t.Fatalf("set profile failed: %v", err)
+	}
+	if len(profile.Skills) != 2 || profile.Skills[0] != "payments" || profile.Languages[0] != "en" {
+		t.Fatalf("expected normalized tags, got %+v", profile)
+	}
+
+	connectAgent(t, hub, "a1", "客服A")
+	if _, err := hub.AssignAgent("room-c1", "a1", "客服A"); err != nil {
+		t.Fatalf("first assignment failed: %v", err)
+	}
+	// Re-assigning the same room does not count against the limit.
+	if _, err := hub.AssignAgent("room-c1", "a1", "客服A"); err != nil {
+		t.Fatalf("reassignment failed: %v", err)
+	}
+	if _, err := hub.AssignAgent("room-c2", "a1", "客服A"); !errors.Is(err, ErrAgentOverCapacity) {
+		t.Fatalf("expected capacity error, got %v", err)
+	}
+
+	if _, err := hub.SetRoomStatus("room-c1", RoomStatusResolved, "a1", RoleAgent, "客服A"); err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if _, err := hub.SetRoomSkills("room-c2", []string{"Withdrawals"}); err != nil {
+		t.Fatalf("set room skills failed: %v", err)
+	}
+	if _, err := hub.AssignAgent("room-c2", "a1", "客服A"); !errors.Is(err, ErrAgentMissingSkill) {
+		t.Fatalf("expected missing skill error, got %v", err)
+	}
+
+	assigned, warnings, err := hub.ForceAssignAgent("room-c2", "a1", "客服A")
+	if err != nil || assigned == nil {
+		t.Fatalf("forced assignment failed: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning, got %v", warnings)
+	}
+	if count := hub.ActiveRoomCount("a1"); count != 1 {
+		t.Fatalf("expected 1 active room, got %d", count)
+	}
+
+	_, warnings, err = hub.ForceAssignAgent("room-c3", "a1", "客服A")
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "1/1") {
+		t.Fatalf("expected capacity warning, got %v (%v)", warnings, err)
+	}
+}
+
+func TestTransferChecksCapacityAndSkills(t *testing.T) {
+	hub := NewHub()
+	for _, roomID := range []string{"room-t1", "room-t2"} {
+		player := newTestClient(hub, roomID, RolePlayer, "p-"+roomID, "玩家")
+		if _, err := hub.Register(player.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
+	connectAgent(t, hub, "a1", "客服A")
+	connectAgent(t, hub, "a2", "客服B")
+	if _, err := hub.SetAgentProfile(AgentProfile{AgentID: "a2", MaxConcurrent: 1}); err != nil {
+		t.Fatalf("set profile failed: %v", err)
+	}
+	for _, roomID := range []string{"room-t1", "room-t2"} {
+		if _, err := hub.AssignAgent(roomID, "a1", "客服A"); err != nil {
+			t.Fatalf("assign failed: %v", err)
+		}
+	}
+
+	if _, err := hub.SetRoomSkills("room-t1", []string{"vip"}); err != nil {
+		t.Fatalf("set room skills failed: %v", err)
+	}
+	if _, err := hub.TransferRoom("room-t1", TransferRequest{ToAgentID: "a2", RequestedBy: "a1"}); !errors.Is(err, ErrAgentMissingSkill) {
+		t.Fatalf("expected the transfer to need the room's skills, got %v", err)
+	}
+	if _, err := hub.SetRoomSkills("room-t1", nil); err != nil {
+		t.Fatalf("clear room skills failed: %v", err)
+	}
+
+	if _, err := hub.TransferRoom("room-t1", TransferRequest{ToAgentID: "a2", RequestedBy: "a1"}); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+	if _, err := hub.TransferRoom("room-t2", TransferRequest{ToAgentID: "a2", RequestedBy: "a1"}); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+	if _, err := hub.AcceptTransfer("room-t1", "a2"); err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	if _, err := hub.AcceptTransfer("room-t2", "a2"); !errors.Is(err, ErrAgentOverCapacity) {
+		t.Fatalf("expected accepting beyond capacity to be refused, got %v", err)
+	}
+	if snapshot, _ := hub.RoomSnapshot("room-t2"); snapshot.PendingTransfer == nil || snapshot.Summary.AssignedAgentID != "a1" {
+		t.Fatalf("expected the refused transfer to stay pending, got %+v", snapshot.Summary)
+	}
+	if _, err := hub.TransferRoom("room-t2", TransferRequest{ToAgentID: "a2", RequestedBy: "a1"}); !errors.Is(err, ErrAgentOverCapacity) {
+		t.Fatalf("expected transfers to an agent at capacity to be refused, got %v", err)
+	}
+}
+
+func TestCapacityCountsEvictedRooms(t *testing.T) {
+	hub := NewHub(WithRoomStore(newMemoryRoomStore()), WithRoomEviction(time.Minute, 0))
+	connectAgent(t, hub, "a1", "客服A")
+	if _, err := hub.SetAgentProfile(AgentProfile{AgentID: "a1", MaxConcurrent: 1}); err != nil {
+		t.Fatalf("set profile failed: %v", err)
+	}
+
+	first, _, err := hub.CreateRoom("", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if _, err := hub.AssignAgent(first.RoomID, "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Fatalf("expected assigned room to be evicted, evicted %d", evicted)
+	}
+
+	second, _, err := hub.CreateRoom("", "p2")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if _, err := hub.AssignAgent(second.RoomID, "a1", "客服A"); !errors.Is(err, ErrAgentOverCapacity) {
+		t.Fatalf("expected evicted room to count toward capacity, got %v", err)
+	}
+}
+
+type staticPriorityResolver map[string]int
+
+func (r staticPriorityResolver) ResolvePriority(ctx context.Context, agency, playerID string) (int, error) {
+	return r[playerID], nil
+}
+
+func TestPriorityQueue(t *testing.T) {
+	hub := NewHub(WithPriorityResolver(staticPriorityResolver{"vip": 3}))
+	sub := hub.SubscribeEvents("")
+	defer hub.UnsubscribeEvents(sub)
+
+	for _, id := range []string{"regular-1", "vip", "regular-2"} {
+		player := newTestClient(hub, "room-"+id, RolePlayer, id, id)
+		player.Agency = "agency-q"
+		if _, err := hub.Register(player.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		queue := hub.Queue("agency-q")
+		if len(queue) == 3 && queue[0].Priority == 3 {
+			break
+		}
+		select {
+		case <-sub.Events():
+		case <-deadline:
+			t.Fatalf("priority was not resolved: %+v", hub.Queue("agency-q"))
+		}
+	}
+
+	queue := hub.Queue("agency-q")
+	order := []string{queue[0].RoomID, queue[1].RoomID, queue[2].RoomID}
+	if order[0] != "room-vip" || order[1] != "room-regular-1" || order[2] != "room-regular-2" {
+		t.Fatalf("unexpected queue order %v", order)
+	}
+	if queue[1].WaitingSince.IsZero() {
+		t.Fatalf("expected waiting rooms to report waitingSince")
+	}
+	if other := hub.Queue("agency-other"); len(other) != 0 {
+		t.Fatalf("expected queue to be scoped by agency, got %d rooms", len(other))
+	}
+
+	connectAgent(t, hub, "a1", "客服A")
+	if _, err := hub.AssignAgent("room-vip", "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	if _, err := hub.SetRoomPriority("room-regular-2", 5); err != nil {
+		t.Fatalf("set priority failed: %v", err)
+	}
+	queue = hub.Queue("")
+	if len(queue) != 2 || queue[0].RoomID != "room-regular-2" {
+		t.Fatalf("expected assigned room to leave the queue and override to reorder, got %+v", queue)
+	}
+	summary, err := hub.RoomSnapshot("room-vip")
+	if err != nil || summary.Summary.Priority != 3 || !summary.Summary.WaitingSince.IsZero() {
+		t.Fatalf("expected assigned vip room to keep priority without waiting time, got %+v (%v)", summary.Summary, err)
+	}
+
+	if _, err := hub.SetRoomPriority("room-vip", 1); err != nil {
+		t.Fatalf("set priority failed: %v", err)
+	}
+	hub.resolvePriority(hub.getRoom("room-vip"), "agency-q", "vip")
+	if summary, _ := hub.RoomSnapshot("room-vip"); summary.Summary.Priority != 1 || !summary.Summary.PriorityOverride {
+		t.Fatalf("expected manual priority to survive resolution, got %+v", summary.Summary)
+	}
+}
+
+func TestQueueNotices(t *testing.T) {
+	hub := NewHub(WithQueueNotices(time.Minute))
+
+	first := newTestClient(hub, "room-first", RolePlayer, "p1", "玩家一")
+	first.Agency = "agency-n"
+	if _, err := hub.Register(first.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	second := newTestClient(hub, "room-second", RolePlayer, "p2", "玩家二")
+	second.Agency = "agency-n"
+	if _, err := hub.Register(second.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	nextQueueNotice := func(c *testClient) Envelope {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			env := c.nextEnvelope(t)
+			if env.Cmd == MessageTypeSystem && env.Metadata["queue"] == "waiting" {
+				return env
+			}
+		}
+		t.Fatalf("no queue notice received")
+		return Envelope{}
+	}
+
+	if env := nextQueueNotice(second); env.Metadata["position"] != "2" || env.Metadata["etaSeconds"] != "" {
+		t.Fatalf("expected position 2 without an estimate, got %+v", env.Metadata)
+	}
+
+	now := time.Now()
+	hub.recordThroughput("agency-n", now.Add(-4*time.Minute))
+	hub.recordThroughput("agency-n", now.Add(-2*time.Minute))
+	if notified := hub.NotifyQueue(now); notified != 2 {
+		t.Fatalf("expected 2 rooms notified, got %d", notified)
+	}
+	env := nextQueueNotice(second)
+	if env.Metadata["position"] != "2" || env.Metadata["etaSeconds"] != "240" {
+		t.Fatalf("expected position 2 with a 240s estimate, got %+v", env.Metadata)
+	}
+
+	connectAgent(t, hub, "a1", "客服A")
+	if _, err := hub.AssignAgent("room-first", "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	if notified := hub.NotifyQueue(time.Now()); notified != 1 {
+		t.Fatalf("expected only the waiting room to be notified, got %d", notified)
+	}
+	if position, _, _, ok := hub.QueuePosition("room-second", time.Now()); !ok || position != 1 {
+		t.Fatalf("expected room to move up to position 1, got %d (%v)", position, ok)
+	}
+}
+
+type closedAgencies map[string]bool
+
+func (c closedAgencies) IsOpen(agency string, at time.Time) bool {
+	return !c[agency]
+}
+
+func TestOfflineMode(t *testing.T) {
+	hub := NewHub(WithOfflineMode(closedAgencies{"agency-closed": true}))
+
+	nextNotice := func(c *testClient, key string) Envelope {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			env := c.nextEnvelope(t)
+			if env.Cmd == MessageTypeSystem && env.Metadata[key] != "" {
+				return env
+			}
+		}
+		t.Fatalf("no notice with metadata %q received", key)
+		return Envelope{}
+	}
+
+	closed := newTestClient(hub, "room-closed", RolePlayer, "p0", "玩家零")
+	closed.Agency = "agency-closed"
+	if _, err := hub.Register(closed.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if env := nextNotice(closed, "offline"); env.Metadata["reason"] != OfflineReasonClosed {
+		t.Fatalf("expected closed reason, got %+v", env.Metadata)
+	}
+
+	player := newTestClient(hub, "room-offline", RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-open"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if env := nextNotice(player, "offline"); env.Metadata["reason"] != OfflineReasonNoAgents {
+		t.Fatalf("expected no_agents reason, got %+v", env.Metadata)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "請問何時出款"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if env := nextNotice(player, "leftMessage"); env.Metadata["offline"] != "true" {
+		t.Fatalf("expected left message acknowledgement, got %+v", env.Metadata)
+	}
+	if backlog := hub.OfflineBacklog("agency-open"); len(backlog) != 1 || backlog[0].LeftMessageAt.IsZero() || backlog[0].Status != RoomStatusPending {
+		t.Fatalf("expected one pending left message in the backlog, got %+v", backlog)
+	}
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "補充：訂單編號 123"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if summary, _ := hub.RoomSnapshot("room-offline"); summary.Summary.Status != RoomStatusPending {
+		t.Fatalf("expected further messages to keep the room pending, got %s", summary.Summary.Status)
+	}
+
+	other := newTestClient(hub, "", RoleAgent, "b1", "客服B")
+	other.Agency = "agency-other"
+	other.Multiplexed = true
+	if _, err := hub.Register(other.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if reason := hub.SupportOffline("agency-open", time.Now()); reason != OfflineReasonNoAgents {
+		t.Fatalf("expected an agent of another agency not to bring support online, got %q", reason)
+	}
+	if backlog := hub.OfflineBacklog("agency-other"); len(backlog) != 0 {
+		t.Fatalf("expected backlog to be scoped by agency, got %+v", backlog)
+	}
+
+	agent := newTestClient(hub, "", RoleAgent, "a1", "客服A")
+	agent.Multiplexed = true
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	env := nextNotice(agent, "offlineBacklog")
+	if env.Metadata["offlineBacklog"] != "1" {
+		t.Fatalf("expected backlog of 1, got %+v", env.Metadata)
+	}
+	if reason := hub.SupportOffline("agency-open", time.Now()); reason != "" {
+		t.Fatalf("expected support to be online once an agent is available, got %q", reason)
+	}
+
+	if _, err := hub.AssignAgent("room-offline", "a1", "客服A"); err != nil {
+		t.Fatalf("assign failed: %v", err)
+	}
+	if backlog := hub.OfflineBacklog(""); len(backlog) != 0 {
+		t.Fatalf("expected assignment to clear the backlog, got %+v", backlog)
+	}
+}
+
+func TestAgencyGreeting(t *testing.T) {
+	hub := NewHub(WithOfflineMode(nil))
+	if _, err := hub.SetGreeting(AgencyGreeting{
+		Agency:      "Agency-G",
+		Greeting:    "您好，歡迎來到客服中心",
+		AwayMessage: "客服暫時不在，請留言",
+		Menu: []PreChatOption{
+			{ID: "deposit", Label: "充值問題", Skills: []string{"Payments"}},
+			{ID: "other", Label: "其他"},
+		},
+	}); err != nil {
+		t.Fatalf("set greeting failed: %v", err)
+	}
+	if _, err := hub.SetGreeting(AgencyGreeting{Agency: "agency-g", Menu: []PreChatOption{{ID: "x"}}}); !errors.Is(err, ErrInvalidGreeting) {
+		t.Fatalf("expected invalid greeting error, got %v", err)
+	}
+
+	player := newTestClient(hub, "room-greeting", RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-g"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	var greeting, away Envelope
+	for greeting.Content == "" || away.Content == "" {
+		env := player.nextEnvelope(t)
+		switch {
+		case env.Metadata["greeting"] == "true":
+			greeting = env
+		case env.Metadata["offline"] == "true":
+			away = env
+		}
+	}
+	if greeting.Content != "您好，歡迎來到客服中心" || greeting.Seq != 1 {
+		t.Fatalf("unexpected greeting %+v", greeting)
+	}
+	var menu []PreChatOption
+	raw, _ := json.Marshal(greeting.Payload["menu"])
+	if err := json.Unmarshal(raw, &menu); err != nil || len(menu) != 2 || menu[0].Skills[0] != "payments" {
+		t.Fatalf("expected normalized pre-chat menu, got %+v", greeting.Payload)
+	}
+	if away.Content != "客服暫時不在，請留言" {
+		t.Fatalf("expected the away message while offline, got %q", away.Content)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "充值問題", Metadata: map[string]string{"menuOption": "deposit"}}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	snapshot, err := hub.RoomSnapshot("room-greeting")
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if skills := snapshot.Summary.RequiredSkills; len(skills) != 1 || skills[0] != "payments" {
+		t.Fatalf("expected the menu choice to set room skills, got %v", skills)
+	}
+
+	hub.Unregister(player.Client)
+	again := newTestClient(hub, "room-greeting", RolePlayer, "p1", "玩家一")
+	again.Agency = "agency-g"
+	if _, err := hub.Register(again.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	history := again.nextEnvelope(t)
+	greetings := 0
+	for _, msg := range history.History {
+		if msg.Metadata["greeting"] == "true" {
+			greetings++
+		}
+	}
+	if history.Cmd != MessageTypeHistory || greetings != 1 {
+		t.Fatalf("expected the greeting once in history, got %+v", history.History)
+	}
+}
+
+type memoryCannedStore struct {
+	mu        sync.Mutex
+	nextID    int64
+	responses map[int64]CannedResponse
+}
+
+func newMemoryCannedStore() *memoryCannedStore {
+	return &memoryCannedStore{responses: make(map[int64]CannedResponse)}
+}
+
+func (s *memoryCannedStore) SaveCannedResponse(ctx context.Context, response CannedResponse) (CannedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, existing := range s.responses {
+		if id != response.ID && existing.Agency == response.Agency && existing.OwnerID == response.OwnerID && existing.Shortcut == response.Shortcut {
+			return CannedResponse{}, ErrCannedShortcutTaken
+		}
+	}
+	if response.ID == 0 {
+		s.nextID++
+		response.ID = s.nextID
+	}
+	s.responses[response.ID] = response
+	return response, nil
+}
+
+func (s *memoryCannedStore) LoadCannedResponse(ctx context.Context, id int64) (*CannedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response, ok := s.responses[id]
+	if !ok {
+		return nil, nil
+	}
+	return &response, nil
+}
+
+func (s *memoryCannedStore) ListCannedResponses(ctx context.Context, filter CannedResponseFilter) ([]CannedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	responses := make([]CannedResponse, 0)
+	for _, response := range s.responses {
+		shared := !response.Personal() && (filter.Agency == "" || response.Agency == filter.Agency)
+		personal := response.Personal() && response.OwnerID == filter.OwnerID
+		if (shared || personal) && (filter.Category == "" || response.Category == filter.Category) {
+			responses = append(responses, response)
+		}
+	}
+	return responses, nil
+}
+
+func (s *memoryCannedStore) DeleteCannedResponse(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.responses[id]; !ok {
+		return ErrCannedResponseNotFound
+	}
+	delete(s.responses, id)
+	return nil
+}
+
+func TestCannedResponses(t *testing.T) {
+	hub := NewHub(WithCannedResponseStore(newMemoryCannedStore()))
+
+	shared, err := hub.SaveCannedResponse(CannedResponse{Agency: "agency-c", Shortcut: "/deposit", Category: "充值", Content: "{{playerName}} 您好，我是 {{agentName}}，充值約需 5 分鐘入帳"})
+	if err != nil {
+		t.Fatalf("save shared response failed: %v", err)
+	}
+	if _, err := hub.SaveCannedResponse(CannedResponse{Agency: "agency-c", Shortcut: "/deposit", Content: "重複"}); !errors.Is(err, ErrCannedShortcutTaken) {
+		t.Fatalf("expected duplicate shortcut to be rejected, got %v", err)
+	}
+	other, _ := hub.SaveCannedResponse(CannedResponse{Agency: "agency-other", Shortcut: "/deposit", Content: "其他代理"})
+	mine, _ := hub.SaveCannedResponse(CannedResponse{Agency: "agency-c", OwnerID: "a1", Shortcut: "/bye", Content: "感謝您的耐心"})
+	theirs, _ := hub.SaveCannedResponse(CannedResponse{Agency: "agency-c", OwnerID: "a2", Shortcut: "/bye", Content: "掰掰"})
+
+	list, err := hub.CannedResponses(CannedResponseFilter{Agency: "agency-c", OwnerID: "a1"})
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected shared and personal responses, got %+v (%v)", list, err)
+	}
+
+	player := newTestClient(hub, "room-canned", RolePlayer, "p1", "小明")
+	player.Agency = "agency-c"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register player failed: %v", err)
+	}
+	agent := newTestClient(hub, "room-canned", RoleAgent, "a1", "客服A")
+	if _, err := hub.Register(agent.Client); err != nil {
+		t.Fatalf("register agent failed: %v", err)
+	}
+
+	send := func(c *testClient, id int64) error {
+		return hub.HandleIncoming(c.Client, Envelope{Cmd: MessageTypeCannedSend, Metadata: map[string]string{"cannedId": fmt.Sprint(id)}})
+	}
+	if err := send(agent, shared.ID); err != nil {
+		t.Fatalf("send canned response failed: %v", err)
+	}
+	for {
+		env := player.nextEnvelope(t)
+		if env.Cmd != MessageTypeChat {
+			continue
+		}
+		if env.Content != "小明 您好，我是 客服A，充值約需 5 分鐘入帳" || env.SenderID != "a1" || env.Metadata["cannedId"] != fmt.Sprint(shared.ID) {
+			t.Fatalf("unexpected rendered message %+v", env)
+		}
+		break
+	}
+	if err := send(agent, mine.ID); err != nil {
+		t.Fatalf("send personal response failed: %v", err)
+	}
+	for _, id := range []int64{theirs.ID, other.ID} {
+		if err := send(agent, id); !errors.Is(err, ErrNotPermitted) {
+			t.Fatalf("expected response %d to be refused, got %v", id, err)
+		}
+	}
+	if err := send(player, shared.ID); !errors.Is(err, ErrNotPermitted) {
+		t.Fatalf("expected players to be refused, got %v", err)
+	}
+	if err := send(agent, 999); !errors.Is(err, ErrCannedResponseNotFound) {
+		t.Fatalf("expected unknown response error, got %v", err)
+	}
+}
+
+func TestBotAutoReply(t *testing.T) {
+	hub := NewHub()
+	if _, err := hub.SetBotRules("agency-b", []BotRule{{Pattern: "("}}); !errors.Is(err, ErrInvalidBotRule) {
+		t.Fatalf("expected invalid bot rule error, got %v", err)
+	}
+	if _, err := hub.SetBotRules("Agency-B", []BotRule{
+		{ID: "deposit", Keywords: []string{"Deposit", "充值"}, Reply: "請至錢包頁面選擇充值方式"},
+		{ID: "withdraw", Pattern: `提(現|款)`, Reply: "提現約需 30 分鐘處理"},
+	}); err != nil {
+		t.Fatalf("set bot rules failed: %v", err)
+	}
+
+	player := newTestClient(hub, "room-bot", RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-b"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	player.nextEnvelope(t) // join notice
+	if queue := hub.Queue(""); len(queue) != 0 {
+		t.Fatalf("expected the bot room to stay out of the queue, got %+v", queue)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "how to DEPOSIT?"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t) // echo
+	reply := player.nextEnvelope(t)
+	if reply.SenderRole != RoleBot || reply.Content != "請至錢包頁面選擇充值方式" || reply.Metadata["botRule"] != "deposit" {
+		t.Fatalf("unexpected bot reply %+v", reply)
+	}
+	if reply.Payload["actions"] == nil {
+		t.Fatalf("expected the handoff action, got %+v", reply.Payload)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "提款多久"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if reply := player.nextEnvelope(t); reply.Metadata["botRule"] != "withdraw" {
+		t.Fatalf("expected the pattern rule to answer, got %+v", reply)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "轉接真人客服", Metadata: map[string]string{"handoff": "human"}}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if notice := player.nextEnvelope(t); notice.Metadata["handoff"] != "true" {
+		t.Fatalf("expected the handoff notice, got %+v", notice)
+	}
+	queue := hub.Queue("agency-b")
+	if len(queue) != 1 || queue[0].RoomID != "room-bot" || queue[0].BotActive {
+		t.Fatalf("expected the room in the queue after handoff, got %+v", queue)
+	}
+
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "充值"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	select {
+	case data := <-player.mem.Messages():
+		t.Fatalf("expected the bot to stay silent after handoff, got %s", data)
+	default:
+	}
+
+	unmatched := newTestClient(hub, "room-bot-2", RolePlayer, "p2", "玩家二")
+	unmatched.Agency = "agency-b"
+	if _, err := hub.Register(unmatched.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	unmatched.nextEnvelope(t)
+	if err := hub.HandleIncoming(unmatched.Client, Envelope{Cmd: MessageTypeChat, Content: "帳號被鎖了"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	unmatched.nextEnvelope(t)
+	if notice := unmatched.nextEnvelope(t); notice.Metadata["handoff"] != "true" {
+		t.Fatalf("expected unanswered questions to be handed off, got %+v", notice)
+	}
+}
+
+type recordingBotDispatcher struct {
+	messages chan ChatMessage
+	answer   BotAnswer
+}
+
+func (d *recordingBotDispatcher) DispatchBotMessage(ctx context.Context, bot AgencyBot, room RoomSummary, msg ChatMessage) (BotAnswer, error) {
+	d.messages <- msg
+	return d.answer, nil
+}
+
+func TestExternalBot(t *testing.T) {
+	dispatcher := &recordingBotDispatcher{messages: make(chan ChatMessage, 4)}
+	hub := NewHub(WithBotDispatcher(dispatcher))
+	if _, err := hub.SetBotWebhook("agency-x", "ftp://bot.example.com", false); !errors.Is(err, ErrInvalidBotWebhook) {
+		t.Fatalf("expected invalid webhook error, got %v", err)
+	}
+	bot, err := hub.SetBotWebhook("agency-x", "https://bot.example.com/hook", false)
+	if err != nil || bot.Token == "" {
+		t.Fatalf("register bot failed: %+v %v", bot, err)
+	}
+
+	player := newTestClient(hub, "room-ext", RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-x"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "存款沒到帳"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	select {
+	case msg := <-dispatcher.messages:
+		if msg.Content != "存款沒到帳" || msg.SenderRole != RolePlayer {
+			t.Fatalf("unexpected dispatched message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be dispatched to the bot")
+	}
+
+	if _, err := hub.BotReply("room-missing", "wrong", "hi", false); !errors.Is(err, ErrBotUnauthorized) {
+		t.Fatalf("expected unknown rooms to be hidden from invalid tokens, got %v", err)
+	}
+	if _, err := hub.BotReply("room-missing", bot.Token, "hi", false); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected room not found for a valid token, got %v", err)
+	}
+	if _, err := hub.BotReply("room-ext", "wrong", "hi", false); !errors.Is(err, ErrBotUnauthorized) {
+		t.Fatalf("expected unauthorized reply, got %v", err)
+	}
+	if _, err := hub.BotReply("room-ext", bot.Token, "請提供訂單編號", false); err != nil {
+		t.Fatalf("bot reply failed: %v", err)
+	}
+	reply := player.nextEnvelope(t)
+	if reply.SenderRole != RoleBot || reply.Content != "請提供訂單編號" || reply.Seq == 0 {
+		t.Fatalf("unexpected bot reply %+v", reply)
+	}
+
+	summary, err := hub.BotReply("room-ext", bot.Token, "", true)
+	if err != nil || summary.BotActive || summary.WaitingSince.IsZero() {
+		t.Fatalf("expected the bot to hand off the room, got %+v %v", summary, err)
+	}
+	if notice := player.nextEnvelope(t); notice.Metadata["handoff"] != "true" {
+		t.Fatalf("expected the handoff notice, got %+v", notice)
+	}
+	if _, err := hub.BotReply("room-ext", bot.Token, "還在嗎", false); !errors.Is(err, ErrBotInactive) {
+		t.Fatalf("expected the bot to be locked out after handoff, got %v", err)
+	}
+}
+
+func TestExternalBotAnswerInResponse(t *testing.T) {
+	dispatcher := &recordingBotDispatcher{messages: make(chan ChatMessage, 4), answer: BotAnswer{Content: "請提供訂單編號"}}
+	hub := NewHub(WithBotDispatcher(dispatcher))
+	if _, err := hub.SetBotWebhook("agency-x", "https://bot.example.com/hook", false); err != nil {
+		t.Fatalf("register bot failed: %v", err)
+	}
+	player := newTestClient(hub, "room-ext", RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-x"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "存款沒到帳"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if reply := player.nextEnvelope(t); reply.SenderRole != RoleBot || reply.Content != "請提供訂單編號" {
+		t.Fatalf("expected the answer in the response to be posted, got %+v", reply)
+	}
+
+	dispatcher.answer = BotAnswer{Handoff: true}
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "轉人工"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	player.nextEnvelope(t)
+	if notice := player.nextEnvelope(t); notice.Metadata["handoff"] != "true" {
+		t.Fatalf("expected the handoff notice, got %+v", notice)
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	hub := NewHub()
+	player := newTestClient(hub, "room-post", RolePlayer, "p1", "玩家一")
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	player.nextEnvelope(t)
+
+	if _, err := hub.PostMessage("missing", ChatMessage{Content: "hi"}); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected room not found, got %v", err)
+	}
+	if _, err := hub.PostMessage("room-post", ChatMessage{Type: MessageTypeInternal, Content: "hi"}); err == nil {
+		t.Fatal("expected unsupported message types to be rejected")
+	}
+
+	stored, err := hub.PostMessage("room-post", ChatMessage{SenderID: "api:agency", DisplayName: "系統", Content: " 您的提款已到帳 "})
+	if err != nil {
+		t.Fatalf("post message failed: %v", err)
+	}
+	if stored.Sequence != 1 || stored.SenderRole != RoleAgent || stored.Content != "您的提款已到帳" {
+		t.Fatalf("unexpected stored message %+v", stored)
+	}
+	env := player.nextEnvelope(t)
+	if env.Cmd != MessageTypeChat || env.Seq != stored.Sequence || env.Content != stored.Content {
+		t.Fatalf("unexpected broadcast %+v", env)
+	}
+
+	notice, err := hub.PostMessage("room-post", ChatMessage{Type: MessageTypeSystem, Content: "系統維護中", Metadata: map[string]string{"source": "backend"}})
+	if err != nil {
+		t.Fatalf("post notice failed: %v", err)
+	}
+	env = player.nextEnvelope(t)
+	if env.Cmd != MessageTypeSystem || env.Seq != notice.Sequence || notice.Sequence != 2 || env.Metadata["source"] != "backend" {
+		t.Fatalf("unexpected notice broadcast %+v", env)
+	}
+
+	forged := map[string]string{"greeting": "true", "outreach": "true", "menuOption": "deposit"}
+	if err := hub.HandleIncoming(player.Client, Envelope{Cmd: MessageTypeChat, Content: "存款", Metadata: forged}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	env = player.nextEnvelope(t)
+	history, _, err := hub.MessagesSince("room-post", 2)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("unexpected history %+v %v", history, err)
+	}
+	last := history[0]
+	if len(env.Metadata) != 1 || env.Metadata["menuOption"] != "deposit" || len(last.Metadata) != 1 || last.Metadata["menuOption"] != "deposit" {
+		t.Fatalf("expected only allowed metadata to be stored and broadcast, got %v and %v", env.Metadata, last.Metadata)
+	}
+}
+
+func TestPostMessageReloadsEvictedRoom(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms), WithRoomEviction(time.Minute, 0))
+	created, _, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Fatalf("expected the room to be evicted, got %d", evicted)
+	}
+
+	loads := rooms.loads
+	stored, err := hub.PostMessage(created.RoomID, ChatMessage{SenderID: "agent-1", DisplayName: "客服一", Content: "提款已到帳了嗎？"})
+	if err != nil {
+		t.Fatalf("post to evicted room failed: %v", err)
+	}
+	if rooms.loads != loads+1 {
+		t.Fatalf("expected the room state to be loaded once, got %d loads", rooms.loads-loads)
+	}
+	snapshot, err := hub.RoomSnapshot(created.RoomID)
+	if err != nil || snapshot.Summary.OwnerID != "p1" || stored.Sequence != 1 {
+		t.Fatalf("expected the room to be reloaded, got %+v %+v %v", snapshot.Summary, stored, err)
+	}
+	if _, err := hub.PostMessage("room-unknown", ChatMessage{Content: "hi"}); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected unknown rooms to stay unknown, got %v", err)
+	}
+}
+
+func TestRoomSnapshotKeepsEvictedRoomEvicted(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms), WithRoomEviction(time.Minute, 0))
+	created, _, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+		t.Fatalf("expected the room to be evicted, got %d", evicted)
+	}
+
+	snapshot, err := hub.RoomSnapshot(created.RoomID)
+	if err != nil || snapshot.Summary.Agency != "agency-a" || snapshot.Summary.OwnerID != "p1" {
+		t.Fatalf("expected the stored room state, got %+v %v", snapshot.Summary, err)
+	}
+	if resident := hub.Rooms(); len(resident) != 0 {
+		t.Fatalf("expected reading the room to leave it evicted, got %+v", resident)
+	}
+	if _, err := hub.RoomSnapshot("room-unknown"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected unknown rooms to stay unknown, got %v", err)
+	}
+}
+
+func TestEvictedRoomOperations(t *testing.T) {
+	messages := newMemoryMessageStore()
+	hub := NewHub(WithRoomStore(newMemoryRoomStore()), WithMessageStore(messages), WithRoomEviction(time.Minute, 0))
+	connectAgent(t, hub, "a1", "客服A")
+	connectAgent(t, hub, "a2", "客服B")
+	for _, agentID := range []string{"a1", "a2"} {
+		if _, err := hub.SetAgentProfile(AgentProfile{AgentID: agentID, Skills: []string{"vip"}}); err != nil {
+			t.Fatalf("set profile failed: %v", err)
+		}
+	}
+	created, _, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil {
+		t.Fatalf("create room failed: %v", err)
+	}
+	roomID := created.RoomID
+	for i := 1; i <= 3; i++ {
+		if _, err := hub.PostMessage(roomID, ChatMessage{SenderID: "a1", Content: fmt.Sprintf("msg-%d", i)}); err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+	}
+	evict := func() {
+		t.Helper()
+		if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 1 {
+			t.Fatalf("expected the room to be evicted, got %d", evicted)
+		}
+	}
+	evict()
+
+	since, next, err := hub.MessagesSince(roomID, 1)
+	if err != nil || len(since) != 2 || since[0].Sequence != 2 || next != 3 {
+		t.Fatalf("expected messages after 1 from the store, got %+v %d %v", since, next, err)
+	}
+	before, hasMore, _, err := hub.MessagesBefore(roomID, 0, 10)
+	if err != nil || len(before) != 3 || hasMore {
+		t.Fatalf("expected the stored history, got %+v %v %v", before, hasMore, err)
+	}
+	if _, err := hub.Assignments(roomID); err != nil {
+		t.Fatalf("assignments of an evicted room failed: %v", err)
+	}
+	if resident := hub.Rooms(); len(resident) != 0 {
+		t.Fatalf("expected reads to leave the room evicted, got %+v", resident)
+	}
+
+	if summary, err := hub.SetRoomPriority(roomID, 3); err != nil || summary.Priority != 3 {
+		t.Fatalf("set priority on an evicted room failed: %+v %v", summary, err)
+	}
+	evict()
+	if summary, err := hub.SetRoomSkills(roomID, []string{"VIP"}); err != nil || len(summary.RequiredSkills) != 1 {
+		t.Fatalf("set skills on an evicted room failed: %+v %v", summary, err)
+	}
+	evict()
+	if _, err := hub.AssignAgent(roomID, "a1", "客服A"); err != nil {
+		t.Fatalf("assign an evicted room failed: %v", err)
+	}
+	evict()
+	if _, err := hub.AcceptTransfer(roomID, "a2"); !errors.Is(err, ErrNoPendingTransfer) {
+		t.Fatalf("expected the evicted room to be found without a transfer, got %v", err)
+	}
+	evict()
+	if _, err := hub.TransferRoom(roomID, TransferRequest{ToAgentID: "a2", RequestedBy: "a1"}); err != nil {
+		t.Fatalf("transfer an evicted room failed: %v", err)
+	}
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 0 {
+		t.Fatalf("expected a room with a pending transfer to be kept, evicted %d", evicted)
+	}
+	if assigned, err := hub.AcceptTransfer(roomID, "a2"); err != nil || assigned.ID != "a2" {
+		t.Fatalf("accept transfer failed: %+v %v", assigned, err)
+	}
+	evict()
+	if summary, err := hub.SetRoomStatus(roomID, RoomStatusClosed, "a2", RoleAgent, "客服B"); err != nil || summary.Status != RoomStatusClosed {
+		t.Fatalf("close an evicted room failed: %+v %v", summary, err)
+	}
+	snapshot, _ := hub.RoomSnapshot(roomID)
+	if snapshot.Summary.Priority != 3 || snapshot.Summary.AssignedAgentID != "a2" || snapshot.Summary.Status != RoomStatusClosed {
+		t.Fatalf("expected every change to be kept, got %+v", snapshot.Summary)
+	}
+}
+
+func TestCreateRoomOwnership(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms), WithRoomEviction(time.Minute, 0))
+
+	created, isNew, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil || !isNew {
+		t.Fatalf("create room failed: %+v %v %v", created, isNew, err)
+	}
+	if created.OwnerID != "p1" || created.Agency != "agency-a" || !strings.HasPrefix(created.RoomID, "room-") {
+		t.Fatalf("unexpected room %+v", created)
+	}
+	again, isNew, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil || isNew || again.RoomID != created.RoomID {
+		t.Fatalf("expected the active room to be reused, got %+v %v %v", again, isNew, err)
+	}
+	other, isNew, err := hub.CreateRoom("agency-b", "p1")
+	if err != nil || !isNew || other.RoomID == created.RoomID {
+		t.Fatalf("expected a separate room per agency, got %+v %v %v", other, isNew, err)
+	}
+
+	if err := hub.AuthorizeRoom(created.RoomID, "agency-a", "p1"); err != nil {
+		t.Fatalf("owner should be authorized: %v", err)
+	}
+	if err := hub.AuthorizeRoom(created.RoomID, "agency-a", "p2"); !errors.Is(err, ErrRoomForbidden) {
+		t.Fatalf("expected other players to be rejected, got %v", err)
+	}
+	if err := hub.AuthorizeRoom(created.RoomID, "agency-b", "p1"); !errors.Is(err, ErrRoomForbidden) {
+		t.Fatalf("expected other agencies to be rejected, got %v", err)
+	}
+	if err := hub.AuthorizeRoom("room-made-up", "agency-a", "p1"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected unknown rooms to be rejected, got %v", err)
+	}
+	if err := hub.AuthorizeRoom(created.RoomID, " Agency-A ", "p1"); err != nil {
+		t.Fatalf("expected agencies to be compared normalized, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		roomID string
+		agency string
+		want   error
+	}{
+		{created.RoomID, "", nil},
+		{created.RoomID, "AGENCY-A", nil},
+		{created.RoomID, "agency-b", ErrRoomForbidden},
+		{"room-made-up", "", ErrRoomNotFound},
+	} {
+		if err := hub.AuthorizeAgent(tt.roomID, tt.agency); !errors.Is(err, tt.want) {
+			t.Fatalf("AuthorizeAgent(%s, %q) = %v, want %v", tt.roomID, tt.agency, err, tt.want)
+		}
+	}
+	agent := connectAgent(t, hub, "b1", "客服B")
+	agent.Agency = "agency-b"
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeSubscribe, RoomID: created.RoomID}); !errors.Is(err, ErrRoomForbidden) {
+		t.Fatalf("expected agents of other agencies to be refused, got %v", err)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeSubscribe, RoomID: "room-made-up"}); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("expected subscribing to unknown rooms to be refused, got %v", err)
+	}
+	if err := hub.HandleIncoming(agent.Client, Envelope{Cmd: MessageTypeSubscribe, RoomID: other.RoomID}); err != nil {
+		t.Fatalf("subscribe to own agency failed: %v", err)
+	}
+	hub.Unregister(agent.Client)
+
+	if evicted := hub.EvictIdleRooms(time.Now().Add(2 * time.Minute)); evicted != 2 {
+		t.Fatalf("expected both rooms to be evicted, got %d", evicted)
+	}
+	if err := hub.AuthorizeRoom(created.RoomID, "agency-a", "p1"); err != nil {
+		t.Fatalf("ownership should survive eviction: %v", err)
+	}
+	reloaded, isNew, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil || isNew || reloaded.RoomID != created.RoomID {
+		t.Fatalf("expected the evicted room to be reused, got %+v %v %v", reloaded, isNew, err)
+	}
+
+	if _, err := hub.SetRoomStatus(created.RoomID, RoomStatusClosed, "agent", RoleAgent, "客服"); err != nil {
+		t.Fatalf("close room failed: %v", err)
+	}
+	fresh, isNew, err := hub.CreateRoom("agency-a", "p1")
+	if err != nil || !isNew || fresh.RoomID == created.RoomID {
+		t.Fatalf("expected a new room after closing, got %+v %v %v", fresh, isNew, err)
+	}
+}
+
+func TestOutreach(t *testing.T) {
+	rooms := newMemoryRoomStore()
+	hub := NewHub(WithRoomStore(rooms))
+
+	if _, _, err := hub.Outreach("agency-a", "p1", "agent-1", "客服一", "  "); err == nil {
+		t.Fatal("expected empty outreach messages to be rejected")
+	}
+	if _, _, err := hub.Outreach("agency-a", "p1", "agent-1", "客服一", "您好"); !errors.Is(err, ErrAgentUnavailable) {
+		t.Fatalf("expected disconnected agents to be refused, got %v", err)
+	}
+	connectAgent(t, hub, "agent-1", "客服一")
+	summary, message, err := hub.Outreach("agency-a", "p1", "agent-1", "客服一", "您好，您的提款已處理完成")
+	if err != nil {
+		t.Fatalf("outreach failed: %v", err)
+	}
+	if summary.OwnerID != "p1" || summary.AssignedAgentID != "agent-1" || summary.PendingOutreach != 1 {
+		t.Fatalf("unexpected outreach room %+v", summary)
+	}
+	if records, err := hub.Assignments(summary.RoomID); err != nil || len(records) != 1 || records[0].Note != "outreach" {
+		t.Fatalf("expected the outreach assignment to be recorded, got %+v %v", records, err)
+	}
+	hub.throughputMu.Lock()
+	throughput := len(hub.throughput["agency-a"])
+	hub.throughputMu.Unlock()
+	if throughput != 1 {
+		t.Fatalf("expected the outreach assignment to count toward throughput, got %d", throughput)
+	}
+	connectAgent(t, hub, "agent-2", "客服二")
+	if _, _, err := hub.Outreach("agency-a", "p1", "agent-2", "客服二", "我來接手"); !errors.Is(err, ErrRoomAssignedToOther) {
+		t.Fatalf("expected the room of another agent to be left alone, got %v", err)
+	}
+	if message.SenderRole != RoleAgent || message.Metadata["outreach"] != "true" || message.Sequence != 1 {
+		t.Fatalf("unexpected outreach message %+v", message)
+	}
+	if pending := hub.PendingOutreach("agency-a", "p1"); pending != 1 {
+		t.Fatalf("expected one pending message, got %d", pending)
+	}
+	if state := rooms.states[summary.RoomID]; state.PendingOutreach != 1 || state.OwnerID != "p1" {
+		t.Fatalf("expected the outreach to be persisted, got %+v", state)
+	}
+
+	player := newTestClient(hub, summary.RoomID, RolePlayer, "p1", "玩家一")
+	player.Agency = "agency-a"
+	if _, err := hub.Register(player.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	history := player.nextEnvelope(t)
+	if history.Cmd != MessageTypeHistory || len(history.History) != 1 || history.History[0].Content != message.Content {
+		t.Fatalf("expected the opening message in the history, got %+v", history)
+	}
+	if pending := hub.PendingOutreach("agency-a", "p1"); pending != 0 {
+		t.Fatalf("expected the outreach to be delivered, got %d pending", pending)
+	}
+
+	if _, _, err := hub.Outreach("agency-a", "p1", "agent-1", "客服一", "還有其他問題嗎？"); err != nil {
+		t.Fatalf("second outreach failed: %v", err)
+	}
+	if pending := hub.PendingOutreach("agency-a", "p1"); pending != 0 {
+		t.Fatalf("connected players should not accumulate pending outreach, got %d", pending)
+	}
+}
+
+func TestAnnouncements(t *testing.T) {
+	hub := NewHub()
+	register := func(roomID, role, id, agency string) *testClient {
+		client := newTestClient(hub, roomID, role, id, id)
+		client.Agency = agency
+		if _, err := hub.Register(client.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+		client.nextEnvelope(t) // join
+		return client
+	}
+	playerA := register("room-a", RolePlayer, "pa", "agency-a")
+	agent := register("room-a", RoleAgent, "agent-1", "agency-a")
+	playerA.nextEnvelope(t) // agent join
+	playerB := register("room-b", RolePlayer, "pb", "agency-b")
+
+	if _, _, err := hub.Announce(Announcement{Content: " "}); !errors.Is(err, ErrInvalidAnnouncement) {
+		t.Fatalf("expected empty announcements to be rejected, got %v", err)
+	}
+	if _, _, err := hub.Announce(Announcement{Content: "維護", ExpiresAt: time.Now().Add(-time.Minute)}); !errors.Is(err, ErrInvalidAnnouncement) {
+		t.Fatalf("expected expired announcements to be rejected, got %v", err)
+	}
+
+	local, delivered, err := hub.Announce(Announcement{Agency: "agency-a", Content: "今晚 23:00 維護"})
+	if err != nil || delivered != 1 {
+		t.Fatalf("expected one delivery, got %d %v", delivered, err)
+	}
+	if env := playerA.nextEnvelope(t); env.Cmd != MessageTypeSystem || env.Metadata["announcement"] != local.ID {
+		t.Fatalf("unexpected announcement %+v", env)
+	}
+	select {
+	case data := <-agent.mem.Messages():
+		t.Fatalf("agents should not receive announcements, got %s", data)
+	case data := <-playerB.mem.Messages():
+		t.Fatalf("other agencies should not receive the announcement, got %s", data)
+	default:
+	}
+	if len(hub.Announcements("")) != 0 {
+		t.Fatal("announcements without an expiry should not be kept")
+	}
+
+	global, delivered, err := hub.Announce(Announcement{Content: "全站維護", ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil || delivered != 2 {
+		t.Fatalf("expected two deliveries, got %d %v", delivered, err)
+	}
+	playerA.nextEnvelope(t)
+	playerB.nextEnvelope(t)
+	if list := hub.Announcements("agency-b"); len(list) != 1 || list[0].ID != global.ID {
+		t.Fatalf("unexpected active announcements %+v", list)
+	}
+
+	late := newTestClient(hub, "room-c", RolePlayer, "pc", "pc")
+	late.Agency = "agency-c"
+	if _, err := hub.Register(late.Client); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	late.nextEnvelope(t) // join
+	if env := late.nextEnvelope(t); env.Metadata["announcement"] != global.ID || env.Metadata["expiresAt"] == "" {
+		t.Fatalf("expected the persisted announcement on connect, got %+v", env)
+	}
+
+	// Players who already received an announcement do not get it again when
+	// they reconnect.
+	hub.Unregister(late.Client)
+	hub.Unregister(playerA.Client)
+	for _, reconnect := range []struct{ roomID, id, agency string }{
+		{"room-c", "pc", "agency-c"},
+		{"room-a", "pa", "AGENCY-A"},
+	} {
+		again := newTestClient(hub, reconnect.roomID, RolePlayer, reconnect.id, reconnect.id)
+		again.Agency = reconnect.agency
+		if _, err := hub.Register(again.Client); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+		again.nextEnvelope(t) // join
+		select {
+		case data := <-again.mem.Messages():
+			t.Fatalf("expected %s not to receive the announcement again, got %s", reconnect.id, data)
+		default:
+		}
+	}
+
+	scoped, delivered, err := hub.Announce(Announcement{Agency: " Agency-B ", Content: "B 站公告"})
+	if err != nil || delivered != 1 || scoped.Agency != "agency-b" {
+		t.Fatalf("expected the agency to be normalized, got %+v %d %v", scoped, delivered, err)
+	}
+	playerB.nextEnvelope(t)
+
+	if err := hub.CancelAnnouncement(global.ID); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if err := hub.CancelAnnouncement(global.ID); !errors.Is(err, ErrAnnouncementNotFound) {
+		t.Fatalf("expected cancelled announcements to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// autoCloseBatchSize caps how many evicted rooms are loaded back to be closed
+// on each maintenance tick.
+const autoCloseBatchSize = 100
+
+var roomStatusLabels = map[string]string{
+	RoomStatusOpen:     "進行中",
+	RoomStatusPending:  "等待回覆",
+	RoomStatusResolved: "已解決",
+	RoomStatusClosed:   "已關閉",
+}
+
+// WithAutoClose closes rooms that have seen no activity for the given duration.
+func WithAutoClose(after time.Duration) HubOption {
+	return func(h *Hub) {
+		h.autoCloseAfter = after
+	}
+}
+
+// SetRoomStatus moves a room to a new lifecycle state and notifies its clients.
+// The actor's role is shown to the clients alongside the change.
+func (h *Hub) SetRoomStatus(roomID, status, actorID, actorRole, actorName string) (RoomSummary, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return RoomSummary{}, ErrRoomNotFound
+	}
+	if err := h.transitionRoom(room, status, actorID, actorRole, actorName); err != nil {
+		return RoomSummary{}, err
+	}
+	return room.Summary(), nil
+}
+
+func (h *Hub) transitionRoom(room *Room, status, actorID, actorRole, actorName string) error {
+	previous, err := room.SetStatus(status)
+	if err != nil {
+		return err
+	}
+	if previous == status {
+		return nil
+	}
+
+	content := fmt.Sprintf("對話狀態已變更為「%s」", roomStatusLabels[status])
+	if actorName != "" {
+		content = fmt.Sprintf("%s 將對話狀態變更為「%s」", actorName, roomStatusLabels[status])
+	}
+	if previous != RoomStatusOpen && status == RoomStatusOpen {
+		content = "對話已重新開啟"
+	}
+
+	h.broadcast(room, Envelope{
+		Cmd:         MessageTypeSystem,
+		Type:        MessageTypeSystem,
+		RoomID:      room.ID(),
+		Timestamp:   time.Now(),
+		Content:     content,
+		SenderID:    actorID,
+		SenderRole:  actorRole,
+		DisplayName: actorName,
+		Metadata: map[string]string{
+			"status":         status,
+			"previousStatus": previous,
+		},
+	})
+	h.persistRoom(room)
+	if status == RoomStatusClosed {
+		h.publishRoomEvent(EventRoomClosed, room)
+	} else {
+		h.publishRoomEvent(EventRoomUpdated, room)
+	}
+	return nil
+}
+
+// CloseInactiveRooms closes rooms whose last activity is older than the
+// auto-close threshold and returns how many were closed. Rooms that were
+// evicted before reaching the threshold are found in the room store and loaded
+// back to be closed.
+func (h *Hub) CloseInactiveRooms(now time.Time) int {
+	if h.autoCloseAfter <= 0 {
+		return 0
+	}
+
+	h.mu.RLock()
+	candidates := make([]*Room, 0)
+	for _, room := range h.rooms {
+		if room.Status() != RoomStatusClosed && now.Sub(room.LastActivity()) >= h.autoCloseAfter {
+			candidates = append(candidates, room)
+		}
+	}
+	h.mu.RUnlock()
+
+	if h.roomStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		states, err := h.roomStore.LoadInactiveRoomStates(ctx, now.Add(-h.autoCloseAfter), autoCloseBatchSize)
+		cancel()
+		if err != nil {
+			log.Printf("load inactive rooms: %v", err)
+		}
+		for _, state := range states {
+			if h.getRoom(state.RoomID) != nil {
+				continue
+			}
+			room := h.residentRoom(state.RoomID)
+			if room.Status() != RoomStatusClosed && now.Sub(room.LastActivity()) >= h.autoCloseAfter {
+				candidates = append(candidates, room)
+			}
+		}
+	}
+
+	closed := 0
+	for _, room := range candidates {
+		if err := h.transitionRoom(room, RoomStatusClosed, "", "", ""); err == nil {
+			closed++
+		}
+	}
+	return closed
+}
@@ -0,0 +1,58 @@
+package ws
+
+import "errors"
+
+var (
+	// ErrRoomIDRequired is returned when a multiplexed client omits the room of an envelope.
+	ErrRoomIDRequired = errors.New("room id is required")
+	// ErrNotSubscribed is returned when a multiplexed client addresses a room it has not subscribed to.
+	ErrNotSubscribed = errors.New("not subscribed to room")
+)
+
+// roomFor resolves the room an envelope is addressed to. Single-room clients
+// always talk to their own room; multiplexed clients must name a subscribed room.
+func (h *Hub) roomFor(c *Client, env Envelope) (*Room, error) {
+	roomID := c.RoomID
+	if c.Multiplexed {
+		if env.RoomID == "" {
+			return nil, ErrRoomIDRequired
+		}
+		if !c.IsSubscribed(env.RoomID) {
+			return nil, ErrNotSubscribed
+		}
+		roomID = env.RoomID
+	}
+
+	room := h.getRoom(roomID)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+func (h *Hub) subscribe(c *Client, roomID string) error {
+	if roomID == "" {
+		return ErrRoomIDRequired
+	}
+	if c.Role == RoleAgent {
+		if err := h.AuthorizeAgent(roomID, c.Agency); err != nil {
+			return err
+		}
+	}
+	if !c.addSubscription(roomID) {
+		return nil
+	}
+	h.attach(c, roomID)
+	return nil
+}
+
+func (h *Hub) unsubscribe(c *Client, roomID string) error {
+	if roomID == "" {
+		return ErrRoomIDRequired
+	}
+	if !c.removeSubscription(roomID) {
+		return ErrNotSubscribed
+	}
+	h.detach(c, roomID)
+	return nil
+}
@@ -0,0 +1,190 @@
+package ws
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons reported by SupportOffline.
+const (
+	OfflineReasonClosed   = "closed"
+	OfflineReasonNoAgents = "no_agents"
+)
+
+// OpeningHours reports whether an agency's support desk is open at a time.
+type OpeningHours interface {
+	IsOpen(agency string, at time.Time) bool
+}
+
+// WithOfflineMode tells players when support is unavailable, either outside
+// the agency's opening hours or while no agent of the agency is available, and
+// keeps the messages they leave as pending rooms for the first agent to come on
+// duty. A nil hours never closes support by the clock.
+func WithOfflineMode(hours OpeningHours) HubOption {
+	return func(h *Hub) {
+		h.offlineMode = true
+		h.openingHours = hours
+	}
+}
+
+// SupportOffline returns why support is unavailable to the agency's players
+// at the given time, or an empty string when it is available.
+func (h *Hub) SupportOffline(agency string, at time.Time) string {
+	if !h.offlineMode {
+		return ""
+	}
+	if h.openingHours != nil && !h.openingHours.IsOpen(agency, at) {
+		return OfflineReasonClosed
+	}
+	if !h.agentOnDuty(agency, "") {
+		return OfflineReasonNoAgents
+	}
+	return ""
+}
+
+// OfflineBacklog returns the unassigned pending rooms in which players left a
+// message while support was offline, highest priority and oldest message
+// first. An empty agency lists every agency. Only rooms held in memory are
+// listed; evicted rooms are left out until they are loaded back.
+func (h *Hub) OfflineBacklog(agency string) []RoomSummary {
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.RUnlock()
+
+	backlog := make([]RoomSummary, 0)
+	for _, room := range rooms {
+		summary := room.Summary()
+		if summary.Status != RoomStatusPending || summary.LeftMessageAt.IsZero() {
+			continue
+		}
+		if agency != "" && !strings.EqualFold(summary.Agency, agency) {
+			continue
+		}
+		backlog = append(backlog, summary)
+	}
+	sort.SliceStable(backlog, func(i, j int) bool {
+		if backlog[i].Priority != backlog[j].Priority {
+			return backlog[i].Priority > backlog[j].Priority
+		}
+		return backlog[i].LeftMessageAt.Before(backlog[j].LeftMessageAt)
+	})
+	return backlog
+}
+
+// agentOnDuty reports whether an available agent serving the agency, other
+// than except, is connected.
+func (h *Hub) agentOnDuty(agency, except string) bool {
+	for _, c := range h.agentConnections(func(c *Client) bool {
+		return c.ID != except && agentServes(c, agency)
+	}) {
+		if h.cachedAgentStatus(c.ID).Status == AgentStatusAvailable {
+			return true
+		}
+	}
+	return false
+}
+
+// agentServes reports whether an agent connection handles the agency's rooms.
+// Agents without an agency serve every agency.
+func agentServes(c *Client, agency string) bool {
+	return c.Agency == "" || agency == "" || strings.EqualFold(c.Agency, agency)
+}
+
+// leavingMessage reports whether a player writing into the room adds to a
+// message left while support is offline, which keeps the room pending.
+func (h *Hub) leavingMessage(room *Room, at time.Time) bool {
+	_, left := room.leftMessage()
+	return left && room.Status() == RoomStatusPending && h.SupportOffline(room.Agency(), at) != ""
+}
+
+// sendOfflineNotice tells a player that support is unavailable and that they
+// may leave a message.
+func (h *Hub) sendOfflineNotice(room *Room, c *Client, reason string, now time.Time) {
+	content := "目前沒有客服在線，您可以直接留言，客服上線後會盡快回覆"
+	if reason == OfflineReasonClosed {
+		content = "目前為非客服服務時間，您可以直接留言，客服上線後會盡快回覆"
+	}
+	if away := h.awayMessage(room.Agency()); away != "" {
+		content = away
+	}
+	_ = c.SendEnvelope(Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Timestamp: now,
+		Content:   content,
+		Metadata: map[string]string{
+			"offline": "true",
+			"reason":  reason,
+		},
+	})
+}
+
+// recordLeftMessage marks the room as a left message when a player writes
+// while support is offline and moves it to pending until an agent takes it.
+func (h *Hub) recordLeftMessage(room *Room, at time.Time) {
+	if h.SupportOffline(room.Agency(), at) == "" {
+		return
+	}
+	if !room.markLeftMessage(at) {
+		return
+	}
+	previous := room.Status()
+	if err := h.transitionRoom(room, RoomStatusPending, "", "", ""); err != nil || previous == RoomStatusPending {
+		h.persistRoom(room)
+	}
+	h.broadcastToPlayers(room, Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Timestamp: at,
+		Content:   "已收到您的留言，客服上線後會盡快回覆",
+		Metadata: map[string]string{
+			"offline":     "true",
+			"leftMessage": "true",
+		},
+	})
+	h.publishRoomEvent(EventRoomUpdated, room)
+}
+
+// alertOfflineBacklog tells an agent who has just come on duty about the
+// messages left in the agencies the agent serves while nobody was available.
+// Only the first available agent of each agency is alerted about its rooms;
+// later agents find the pending rooms in the room list.
+func (h *Hub) alertOfflineBacklog(agentID, agency string) {
+	if !h.offlineMode || h.AgentStatus(agentID).Status != AgentStatusAvailable {
+		return
+	}
+	backlog := make([]RoomSummary, 0)
+	for _, summary := range h.OfflineBacklog(agency) {
+		if !h.agentOnDuty(summary.Agency, agentID) {
+			backlog = append(backlog, summary)
+		}
+	}
+	if len(backlog) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, client := range h.agentClients(agentID) {
+		_ = client.SendEnvelope(Envelope{
+			Cmd:       MessageTypeSystem,
+			Type:      MessageTypeSystem,
+			RoomID:    client.RoomID,
+			Timestamp: now,
+			Content:   fmt.Sprintf("離線期間共有 %d 則玩家留言待處理", len(backlog)),
+			Metadata: map[string]string{
+				"offlineBacklog": strconv.Itoa(len(backlog)),
+			},
+			Payload: map[string]any{
+				"rooms": backlog,
+			},
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrRoomAssignedToOther is returned when an agent reaches out to a player
+// whose active room another agent is handling.
+var ErrRoomAssignedToOther = errors.New("room is assigned to another agent")
+
+// Outreach opens a conversation with a player on behalf of an agent. The
+// player's active room is reused or created and assigned to the agent, and the
+// opening message is stored so that the player receives it with the history
+// the next time they connect. The assignment goes through the same
+// availability, capacity and skill checks as AssignAgent, and a room another
+// agent is handling is left to them.
+func (h *Hub) Outreach(agency, playerID, agentID, agentName, content string) (RoomSummary, ChatMessage, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return RoomSummary{}, ChatMessage{}, errors.New("content is required")
+	}
+	if agentName == "" {
+		agentName = agentID
+	}
+
+	if err := h.agentAvailable(agentID); err != nil {
+		return RoomSummary{}, ChatMessage{}, err
+	}
+
+	summary, _, err := h.CreateRoom(agency, playerID)
+	if err != nil {
+		return RoomSummary{}, ChatMessage{}, err
+	}
+	room := h.residentRoom(summary.RoomID)
+
+	switch assigned := room.AssignedAgent(); {
+	case assigned == nil:
+		if _, _, err := h.assignAgent(room.ID(), agentID, agentName, false, "outreach"); err != nil {
+			return RoomSummary{}, ChatMessage{}, err
+		}
+	case assigned.ID != agentID:
+		return RoomSummary{}, ChatMessage{}, ErrRoomAssignedToOther
+	}
+
+	stored := h.appendMessage(room, ChatMessage{
+		Type:        MessageTypeChat,
+		RoomID:      room.ID(),
+		SenderID:    agentID,
+		SenderRole:  RoleAgent,
+		DisplayName: agentName,
+		Content:     content,
+		Timestamp:   time.Now(),
+		Metadata:    map[string]string{"outreach": "true"},
+	}, Envelope{})
+	room.queueOutreach()
+	h.persistRoom(room)
+	return room.Summary(), stored, nil
+}
+
+// PendingOutreach returns how many agent-initiated messages are waiting for
+// the player in their active room of the agency.
+func (h *Hub) PendingOutreach(agency, playerID string) int {
+	room := h.activeRoom(agency, playerID)
+	if room == nil {
+		return 0
+	}
+	return room.PendingOutreach()
+}
+
+// deliverOutreach clears the pending outreach count once the player has
+// connected and received the history.
+func (h *Hub) deliverOutreach(room *Room) {
+	if !room.clearOutreach() {
+		return
+	}
+	if err := h.saveRoomState(room); err != nil {
+		log.Printf("persist room %s outreach delivery: %v", room.ID(), err)
+	}
+}
@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+)
+
+// ErrRoomForbidden is returned when a player asks for a room that was not
+// created for them, or an agent for a room of another agency.
+var ErrRoomForbidden = errors.New("room belongs to another player or agency")
+
+// CreateRoom returns the active room of a player in an agency, creating one
+// with a server-generated ID when the player has none. Closed rooms are not
+// reused. It reports whether a new room was created.
+func (h *Hub) CreateRoom(agency, playerID string) (RoomSummary, bool, error) {
+	if playerID == "" {
+		return RoomSummary{}, false, errors.New("player id is required")
+	}
+
+	h.createMu.Lock()
+	defer h.createMu.Unlock()
+
+	if room := h.activeRoom(agency, playerID); room != nil {
+		return room.Summary(), false, nil
+	}
+
+	roomID, err := newRoomID()
+	if err != nil {
+		return RoomSummary{}, false, err
+	}
+	room := NewRoom(roomID)
+	room.historyLimit = h.historyLimit
+	room.setOwner(agency, playerID)
+	if err := h.saveRoomState(room); err != nil {
+		return RoomSummary{}, false, err
+	}
+
+	h.mu.Lock()
+	h.rooms[roomID] = room
+	h.mu.Unlock()
+
+	h.publishRoomEvent(EventRoomCreated, room)
+	return room.Summary(), true, nil
+}
+
+// AuthorizeRoom checks that a player may join a room. Only the player the
+// room was created for, within the same agency, is allowed in.
+func (h *Hub) AuthorizeRoom(roomID, agency, playerID string) error {
+	ownerID, roomAgency, err := h.roomOwner(roomID)
+	if err != nil {
+		return err
+	}
+	if ownerID == "" || ownerID != playerID || !sameAgency(roomAgency, agency) {
+		return ErrRoomForbidden
+	}
+	return nil
+}
+
+// AuthorizeAgent checks that an agent serving the given agency, or every
+// agency when it is empty, may join or observe an existing room.
+func (h *Hub) AuthorizeAgent(roomID, agency string) error {
+	_, roomAgency, err := h.roomOwner(roomID)
+	if err != nil {
+		return err
+	}
+	if agency != "" && !sameAgency(roomAgency, agency) {
+		return ErrRoomForbidden
+	}
+	return nil
+}
+
+// roomOwner returns the owner and agency of a resident or stored room, or
+// ErrRoomNotFound when the hub does not know the room.
+func (h *Hub) roomOwner(roomID string) (string, string, error) {
+	if room := h.getRoom(roomID); room != nil {
+		return room.OwnerID(), room.Agency(), nil
+	}
+	if h.roomStore == nil {
+		return "", "", ErrRoomNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	state, err := h.roomStore.LoadRoomState(ctx, roomID)
+	cancel()
+	if err != nil {
+		return "", "", err
+	}
+	if state == nil {
+		return "", "", ErrRoomNotFound
+	}
+	return state.OwnerID, state.Agency, nil
+}
+
+// activeRoom finds the most recently active room of the player that is not
+// closed, loading it from the room store when it is not resident.
+func (h *Hub) activeRoom(agency, playerID string) *Room {
+	var active *Room
+	h.mu.RLock()
+	for _, room := range h.rooms {
+		if room.OwnerID() != playerID || !sameAgency(room.Agency(), agency) || room.Status() == RoomStatusClosed {
+			continue
+		}
+		if active == nil || room.LastActivity().After(active.LastActivity()) {
+			active = room
+		}
+	}
+	h.mu.RUnlock()
+	if active != nil || h.roomStore == nil {
+		return active
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	state, err := h.roomStore.LoadActiveRoomState(ctx, agency, playerID)
+	cancel()
+	if err != nil {
+		log.Printf("load active room of %s: %v", playerID, err)
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+	room := h.residentRoom(state.RoomID)
+	if room.Status() == RoomStatusClosed {
+		return nil
+	}
+	return room
+}
+
+// residentRoom returns the room, loading it into memory if it was evicted.
+func (h *Hub) residentRoom(roomID string) *Room {
+	if room := h.getRoom(roomID); room != nil {
+		return room
+	}
+	room, _ := h.loadRoom(roomID)
+	return h.keepRoom(room)
+}
+
+// keepRoom makes a loaded room resident, unless another caller loaded the
+// same room first.
+func (h *Hub) keepRoom(room *Room) *Room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if current, ok := h.rooms[room.ID()]; ok {
+		return current
+	}
+	h.rooms[room.ID()] = room
+	return room
+}
+
+func newRoomID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "room-" + hex.EncodeToString(buf), nil
+}
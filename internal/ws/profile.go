@@ -0,0 +1,226 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAgentOverCapacity is returned when an agent already handles as many
+	// active rooms as their profile allows.
+	ErrAgentOverCapacity = errors.New("agent is at maximum concurrent chats")
+	// ErrAgentMissingSkill is returned when an agent lacks a skill the room
+	// requires.
+	ErrAgentMissingSkill = errors.New("agent lacks a required skill")
+)
+
+// AgentProfile describes what an agent can take on. A MaxConcurrent of zero
+// means the agent has no capacity limit.
+type AgentProfile struct {
+	AgentID       string    `json:"agentId"`
+	MaxConcurrent int       `json:"maxConcurrent"`
+	Languages     []string  `json:"languages"`
+	Skills        []string  `json:"skills"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// HasSkill reports whether the profile lists the skill tag.
+func (p AgentProfile) HasSkill(skill string) bool {
+	for _, candidate := range p.Skills {
+		if candidate == skill {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeTags lower-cases, trims and de-duplicates skill or language tags.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// WithAgentProfileStore persists agent profiles. Without a store, profiles
+// only live for the lifetime of the hub.
+func WithAgentProfileStore(store AgentProfileStore) HubOption {
+	return func(h *Hub) {
+		h.profileStore = store
+	}
+}
+
+// SetAgentProfile stores an agent's capacity, languages and skills.
+func (h *Hub) SetAgentProfile(profile AgentProfile) (AgentProfile, error) {
+	if profile.AgentID == "" {
+		return AgentProfile{}, errors.New("agent id is required")
+	}
+	if profile.MaxConcurrent < 0 {
+		return AgentProfile{}, errors.New("maxConcurrent must not be negative")
+	}
+	profile.Languages = NormalizeTags(profile.Languages)
+	profile.Skills = NormalizeTags(profile.Skills)
+	profile.UpdatedAt = time.Now()
+
+	if h.profileStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := h.profileStore.SaveAgentProfile(ctx, profile); err != nil {
+			return AgentProfile{}, err
+		}
+	}
+
+	h.profilesMu.Lock()
+	h.agentProfiles[profile.AgentID] = profile
+	h.profilesMu.Unlock()
+	return profile, nil
+}
+
+// AgentProfile returns an agent's profile, or an unrestricted profile without
+// skills when none was configured.
+func (h *Hub) AgentProfile(agentID string) (AgentProfile, error) {
+	h.profilesMu.RLock()
+	profile, ok := h.agentProfiles[agentID]
+	h.profilesMu.RUnlock()
+	if ok {
+		return profile, nil
+	}
+
+	empty := AgentProfile{AgentID: agentID, Languages: []string{}, Skills: []string{}}
+	if h.profileStore == nil {
+		return empty, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	stored, err := h.profileStore.LoadAgentProfile(ctx, agentID)
+	if err != nil {
+		return AgentProfile{}, err
+	}
+	if stored == nil {
+		return empty, nil
+	}
+
+	h.profilesMu.Lock()
+	h.agentProfiles[agentID] = *stored
+	h.profilesMu.Unlock()
+	return *stored, nil
+}
+
+// AgentProfiles lists every configured agent profile.
+func (h *Hub) AgentProfiles() ([]AgentProfile, error) {
+	if h.profileStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		return h.profileStore.ListAgentProfiles(ctx)
+	}
+
+	h.profilesMu.RLock()
+	defer h.profilesMu.RUnlock()
+
+	profiles := make([]AgentProfile, 0, len(h.agentProfiles))
+	for _, profile := range h.agentProfiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].AgentID < profiles[j].AgentID
+	})
+	return profiles, nil
+}
+
+// SetRoomSkills sets the skill tags an agent needs to be assigned the room.
+func (h *Hub) SetRoomSkills(roomID string, skills []string) (RoomSummary, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return RoomSummary{}, ErrRoomNotFound
+	}
+	room.SetRequiredSkills(NormalizeTags(skills))
+	h.persistRoom(room)
+	h.publishRoomEvent(EventRoomUpdated, room)
+	return room.Summary(), nil
+}
+
+// ActiveRoomCount counts the rooms assigned to the agent that are still open
+// or pending, including rooms that were evicted from memory.
+func (h *Hub) ActiveRoomCount(agentID string) int {
+	var stored []string
+	if h.roomStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		ids, err := h.roomStore.ActiveRoomIDs(ctx, agentID)
+		cancel()
+		if err != nil {
+			log.Printf("load active rooms of %s: %v", agentID, err)
+		}
+		stored = ids
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := 0
+	for _, room := range h.rooms {
+		if countsTowardCapacity(room, agentID) {
+			count++
+		}
+	}
+	// Resident rooms are more recent than the store, so only rooms that are
+	// not in memory are counted from it.
+	for _, id := range stored {
+		if _, ok := h.rooms[id]; !ok {
+			count++
+		}
+	}
+	return count
+}
+
+func countsTowardCapacity(room *Room, agentID string) bool {
+	assigned := room.AssignedAgent()
+	if assigned == nil || assigned.ID != agentID {
+		return false
+	}
+	status := room.Status()
+	return status == RoomStatusOpen || status == RoomStatusPending
+}
+
+// assignmentProblems lists why the agent should not take the room: being at
+// capacity and missing required skills. The room itself never counts toward
+// the agent's load.
+func (h *Hub) assignmentProblems(room *Room, agentID string) ([]error, error) {
+	profile, err := h.AgentProfile(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []error
+	if profile.MaxConcurrent > 0 {
+		active := h.ActiveRoomCount(agentID)
+		if countsTowardCapacity(room, agentID) {
+			active--
+		}
+		if active >= profile.MaxConcurrent {
+			problems = append(problems, fmt.Errorf("%w (%d/%d)", ErrAgentOverCapacity, active, profile.MaxConcurrent))
+		}
+	}
+	for _, skill := range room.RequiredSkills() {
+		if !profile.HasSkill(skill) {
+			problems = append(problems, fmt.Errorf("%w: %s", ErrAgentMissingSkill, skill))
+		}
+	}
+	return problems, nil
+}
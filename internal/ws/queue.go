@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PriorityResolver derives a room's queue priority from the player, for
+// example from the VIP level reported by the agency's player info API.
+type PriorityResolver interface {
+	ResolvePriority(ctx context.Context, agency, playerID string) (int, error)
+}
+
+// WithPriorityResolver looks up the priority of a room whenever its player
+// connects.
+func WithPriorityResolver(resolver PriorityResolver) HubOption {
+	return func(h *Hub) {
+		h.priorityResolver = resolver
+	}
+}
+
+// SetRoomPriority overrides a room's queue priority. The priority resolver no
+// longer changes it afterwards.
+func (h *Hub) SetRoomPriority(roomID string, priority int) (RoomSummary, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return RoomSummary{}, ErrRoomNotFound
+	}
+	room.overridePriority(priority)
+	h.persistRoom(room)
+	h.publishRoomEvent(EventRoomUpdated, room)
+	return room.Summary(), nil
+}
+
+// Queue lists the open rooms waiting for an agent, highest priority first and
+// longest waiting first within a priority. An empty agency lists every agency.
+// Only rooms held in memory are listed; evicted rooms are left out until they
+// are loaded back.
+func (h *Hub) Queue(agency string) []RoomSummary {
+	h.mu.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.mu.RUnlock()
+
+	queue := make([]RoomSummary, 0)
+	for _, room := range rooms {
+		if _, ok := room.waiting(); !ok {
+			continue
+		}
+		if agency != "" && room.Agency() != agency {
+			continue
+		}
+		queue = append(queue, room.Summary())
+	}
+	sortQueue(queue)
+	return queue
+}
+
+func sortQueue(queue []RoomSummary) {
+	sort.SliceStable(queue, func(i, j int) bool {
+		if queue[i].Priority != queue[j].Priority {
+			return queue[i].Priority > queue[j].Priority
+		}
+		if !queue[i].WaitingSince.Equal(queue[j].WaitingSince) {
+			return queue[i].WaitingSince.Before(queue[j].WaitingSince)
+		}
+		return queue[i].RoomID < queue[j].RoomID
+	})
+}
+
+func (h *Hub) resolvePriority(room *Room, agency, playerID string) {
+	if room.PriorityOverridden() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	priority, err := h.priorityResolver.ResolvePriority(ctx, agency, playerID)
+	if err != nil {
+		log.Printf("resolve priority for %s in room %s: %v", playerID, room.ID(), err)
+		return
+	}
+	if !room.PriorityOverridden() && priority != room.Priority() {
+		h.applyPriority(room, priority)
+	}
+}
+
+func (h *Hub) applyPriority(room *Room, priority int) {
+	room.SetPriority(priority)
+	h.persistRoom(room)
+	h.publishRoomEvent(EventRoomUpdated, room)
+}
+
+const (
+	// throughputWindow is how far back assignments count toward the rate used
+	// to estimate waiting times.
+	throughputWindow = 30 * time.Minute
+	// minThroughputSamples is the number of recent assignments needed before
+	// an estimate is offered.
+	minThroughputSamples = 2
+)
+
+// WithQueueNotices tells waiting players their queue position and estimated
+// wait when they join and then every interval until an agent is assigned.
+func WithQueueNotices(interval time.Duration) HubOption {
+	return func(h *Hub) {
+		h.queueNoticeInterval = interval
+	}
+}
+
+// QueuePosition reports a waiting room's 1-based position in its agency's
+// queue and, when enough recent assignments are known, the estimated wait.
+func (h *Hub) QueuePosition(roomID string, now time.Time) (position int, eta time.Duration, hasETA bool, ok bool) {
+	room := h.getRoom(roomID)
+	if room == nil {
+		return 0, 0, false, false
+	}
+	agency := room.Agency()
+	for i, summary := range h.Queue(agency) {
+		if summary.RoomID == roomID {
+			eta, hasETA = h.estimateWait(agency, i+1, now)
+			return i + 1, eta, hasETA, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// NotifyQueue sends every waiting player their current queue position and
+// returns the number of rooms notified. The queue is built once and positions
+// are counted per agency while walking it.
+func (h *Hub) NotifyQueue(now time.Time) int {
+	positions := make(map[string]int)
+	offline := make(map[string]bool)
+	notified := 0
+	for _, summary := range h.Queue("") {
+		agency := summary.Agency
+		positions[agency]++
+		closed, known := offline[agency]
+		if !known {
+			closed = h.SupportOffline(agency, now) != ""
+			offline[agency] = closed
+		}
+		if closed {
+			continue
+		}
+		room := h.getRoom(summary.RoomID)
+		if room == nil {
+			continue
+		}
+		h.sendQueueNotice(room, positions[agency], now, nil)
+		notified++
+	}
+	return notified
+}
+
+// sendQueueNotice tells the room's players, or only the given client, that the
+// room is at the given position of its agency's queue.
+func (h *Hub) sendQueueNotice(room *Room, position int, now time.Time, only *Client) {
+	eta, hasETA := h.estimateWait(room.Agency(), position, now)
+
+	content := fmt.Sprintf("目前排在第 %d 位，請稍候客服接待", position)
+	metadata := map[string]string{
+		"queue":    "waiting",
+		"position": strconv.Itoa(position),
+	}
+	if hasETA {
+		minutes := int(math.Ceil(eta.Minutes()))
+		content = fmt.Sprintf("目前排在第 %d 位，預估等候約 %d 分鐘", position, minutes)
+		metadata["etaSeconds"] = strconv.Itoa(int(eta.Seconds()))
+	}
+
+	env := Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    room.ID(),
+		Timestamp: now,
+		Content:   content,
+		Metadata:  metadata,
+	}
+	if only != nil {
+		_ = only.SendEnvelope(env)
+		return
+	}
+	h.broadcastToPlayers(room, env)
+}
+
+// recordThroughput remembers when a waiting room of the agency was picked up.
+func (h *Hub) recordThroughput(agency string, at time.Time) {
+	h.throughputMu.Lock()
+	defer h.throughputMu.Unlock()
+
+	h.throughput[agency] = append(pruneBefore(h.throughput[agency], at.Add(-throughputWindow)), at)
+}
+
+// estimateWait divides the queue position by the agency's recent assignment
+// rate.
+func (h *Hub) estimateWait(agency string, position int, now time.Time) (time.Duration, bool) {
+	h.throughputMu.Lock()
+	samples := pruneBefore(h.throughput[agency], now.Add(-throughputWindow))
+	h.throughput[agency] = samples
+	h.throughputMu.Unlock()
+
+	if len(samples) < minThroughputSamples {
+		return 0, false
+	}
+	span := now.Sub(samples[0])
+	if span <= 0 {
+		return 0, false
+	}
+	perAssignment := span / time.Duration(len(samples))
+	return perAssignment * time.Duration(position), true
+}
+
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	start := 0
+	for start < len(times) && times[start].Before(cutoff) {
+		start++
+	}
+	return times[start:]
+}
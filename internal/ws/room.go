@@ -6,34 +6,74 @@ import (
 	"time"
 )
 
-// ErrClientNotInRoom is returned when a client attempts to interact with a room it does not belong to.
-var ErrClientNotInRoom = errors.New("client not part of room")
+var (
+	// ErrClientNotInRoom is returned when a client attempts to interact with a room it does not belong to.
+	ErrClientNotInRoom = errors.New("client not part of room")
+	// ErrInvalidStatus is returned for an unknown room status.
+	ErrInvalidStatus = errors.New("invalid room status")
+	// ErrInvalidTransition is returned when a room cannot move to the requested status.
+	ErrInvalidTransition = errors.New("invalid room status transition")
+)
+
+// roomTransitions lists the statuses each status may move to.
+var roomTransitions = map[string][]string{
+	RoomStatusOpen:     {RoomStatusPending, RoomStatusResolved, RoomStatusClosed},
+	RoomStatusPending:  {RoomStatusOpen, RoomStatusResolved, RoomStatusClosed},
+	RoomStatusResolved: {RoomStatusOpen, RoomStatusClosed},
+	RoomStatusClosed:   {RoomStatusOpen},
+}
+
+// ValidRoomStatus reports whether status is a known room lifecycle state.
+func ValidRoomStatus(status string) bool {
+	_, ok := roomTransitions[status]
+	return ok
+}
 
 // Room represents a chat room shared by a player and customer service agents.
 type Room struct {
 	id            string
+	agency        string
 	history       []ChatMessage
 	clients       map[*Client]struct{}
+	observers     map[*Client]struct{}
 	players       map[string]*Participant
 	agents        map[string]*Participant
+	ownerID       string
 	assignedAgent *Participant
+	assignments   []AssignmentRecord
+	transfer      *PendingTransfer
+	skills        []string
+	priority      int
+	prioritySet   bool
+	queuedAt      time.Time
+	leftMessageAt time.Time
+	botActive     bool
+	outreach      int
 	createdAt     time.Time
 	lastActivity  time.Time
+	idleSince     time.Time
+	status        string
+	statusChanged time.Time
 	nextSequence  int64
+	historyLimit  int
 	mu            sync.RWMutex
 }
 
 func NewRoom(id string) *Room {
 	now := time.Now()
 	return &Room{
-		id:           id,
-		history:      make([]ChatMessage, 0, 32),
-		clients:      make(map[*Client]struct{}),
-		players:      make(map[string]*Participant),
-		agents:       make(map[string]*Participant),
-		createdAt:    now,
-		lastActivity: now,
-		nextSequence: 0,
+		id:            id,
+		history:       make([]ChatMessage, 0, 32),
+		clients:       make(map[*Client]struct{}),
+		observers:     make(map[*Client]struct{}),
+		players:       make(map[string]*Participant),
+		agents:        make(map[string]*Participant),
+		createdAt:     now,
+		lastActivity:  now,
+		queuedAt:      now,
+		status:        RoomStatusOpen,
+		statusChanged: now,
+		nextSequence:  0,
 	}
 }
 
@@ -41,6 +81,28 @@ func (r *Room) ID() string {
 	return r.id
 }
 
+// Agency returns the agency of the player who owns the room.
+func (r *Room) Agency() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.agency
+}
+
+// OwnerID returns the player the room was created for, or an empty string for
+// rooms that predate server-side room creation.
+func (r *Room) OwnerID() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ownerID
+}
+
+func (r *Room) setOwner(agency, ownerID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.agency = agency
+	r.ownerID = ownerID
+}
+
 func (r *Room) CreatedAt() time.Time {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -53,14 +115,64 @@ func (r *Room) LastActivity() time.Time {
 	return r.lastActivity
 }
 
+func (r *Room) Status() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+// SetStatus moves the room to a new lifecycle state and returns the previous
+// one. Setting the current status again is a no-op.
+func (r *Room) SetStatus(status string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !ValidRoomStatus(status) {
+		return "", ErrInvalidStatus
+	}
+	previous := r.status
+	if previous == status {
+		return previous, nil
+	}
+
+	allowed := false
+	for _, next := range roomTransitions[previous] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return previous, ErrInvalidTransition
+	}
+
+	r.status = status
+	r.statusChanged = time.Now()
+	if status == RoomStatusOpen && r.assignedAgent == nil {
+		r.queuedAt = r.statusChanged
+	}
+	return previous, nil
+}
+
+// AddClient attaches a client to the room and returns its participant entry.
+// Observers are attached silently and yield a nil participant.
 func (r *Room) AddClient(c *Client) *Participant {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if c.Observer {
+		r.clients[c] = struct{}{}
+		r.observers[c] = struct{}{}
+		return nil
+	}
+
 	if _, ok := r.clients[c]; ok {
 		return r.ensureParticipantLocked(c)
 	}
 
+	if r.agency == "" && c.Role == RolePlayer {
+		r.agency = c.Agency
+	}
 	r.clients[c] = struct{}{}
 	participant := r.ensureParticipantLocked(c)
 	participant.Connected = true
@@ -102,15 +214,49 @@ func (r *Room) ensureParticipantLocked(c *Client) *Participant {
 	return participant
 }
 
-func (r *Room) RemoveClient(c *Client) {
+// promoteObserver turns a silent observer into a visible agent participant.
+func (r *Room) promoteObserver(c *Client) (*Participant, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.observers[c]; !ok {
+		return nil, false
+	}
+	delete(r.observers, c)
+
+	participant := r.ensureParticipantLocked(c)
+	participant.Connected = true
+	participant.LastSeen = time.Now()
+	r.lastActivity = participant.LastSeen
+	return participant, true
+}
+
+func (r *Room) IsObserver(c *Client) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.observers[c]
+	return ok
+}
+
+// RemoveClient detaches a client and reports whether a visible participant
+// left, as opposed to a silent observer or an unknown client.
+func (r *Room) RemoveClient(c *Client) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if _, ok := r.clients[c]; !ok {
-		return
+		return false
 	}
 
 	delete(r.clients, c)
+	if len(r.clients) == 0 {
+		r.idleSince = time.Now()
+	}
+	if _, ok := r.observers[c]; ok {
+		delete(r.observers, c)
+		return false
+	}
 
 	registry := r.players
 	if c.Role == RoleAgent {
@@ -121,6 +267,7 @@ func (r *Room) RemoveClient(c *Client) {
 		participant.Connected = false
 		participant.LastSeen = time.Now()
 	}
+	return true
 }
 
 func (r *Room) Touch(ts time.Time) {
@@ -139,11 +286,17 @@ func (r *Room) AddMessage(msg ChatMessage) ChatMessage {
 	if msg.Timestamp.IsZero() {
 		msg.Timestamp = time.Now()
 	}
+	if msg.Type == "" {
+		msg.Type = MessageTypeChat
+	}
 
 	r.nextSequence++
 	msg.Sequence = r.nextSequence
 
 	r.history = append(r.history, msg)
+	if r.historyLimit > 0 && len(r.history) > r.historyLimit {
+		r.history = append(r.history[:0], r.history[len(r.history)-r.historyLimit:]...)
+	}
 	r.lastActivity = msg.Timestamp
 
 	if participant, ok := r.players[msg.SenderID]; ok {
@@ -189,12 +342,155 @@ func (r *Room) MessagesSince(sequence int64) ([]ChatMessage, int64) {
 	return history, r.nextSequence
 }
 
+// MessagesBefore returns up to limit messages older than the provided
+// sequence, oldest first. A zero sequence pages back from the newest message.
+func (r *Room) MessagesBefore(before int64, limit int) []ChatMessage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	end := len(r.history)
+	if before > 0 {
+		end = 0
+		for i := len(r.history) - 1; i >= 0; i-- {
+			if r.history[i].Sequence < before {
+				end = i + 1
+				break
+			}
+		}
+	}
+
+	start := 0
+	if limit > 0 && end-limit > start {
+		start = end - limit
+	}
+
+	history := make([]ChatMessage, end-start)
+	copy(history, r.history[start:end])
+	return history
+}
+
+// hasMessagesBefore reports whether the room still holds a message older than
+// the sequence in memory.
+func (r *Room) hasMessagesBefore(sequence int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.history) > 0 && r.history[0].Sequence < sequence
+}
+
 func (r *Room) NextSequence() int64 {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	return r.nextSequence
 }
 
+// restoreSequence continues numbering after messages that were persisted
+// before the room was loaded into memory.
+func (r *Room) restoreSequence(sequence int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sequence > r.nextSequence {
+		r.nextSequence = sequence
+	}
+}
+
+func (r *Room) restoreState(state RoomState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !state.CreatedAt.IsZero() {
+		r.createdAt = state.CreatedAt
+	}
+	if state.Agency != "" {
+		r.agency = state.Agency
+	}
+	if state.OwnerID != "" {
+		r.ownerID = state.OwnerID
+	}
+	if state.LastActivity.After(r.lastActivity) {
+		r.lastActivity = state.LastActivity
+	}
+	if state.NextSequence > r.nextSequence {
+		r.nextSequence = state.NextSequence
+	}
+	r.assignments = append([]AssignmentRecord(nil), state.Assignments...)
+	r.skills = append([]string(nil), state.RequiredSkills...)
+	r.priority = state.Priority
+	r.prioritySet = state.PriorityOverride
+	if !state.QueuedAt.IsZero() {
+		r.queuedAt = state.QueuedAt
+	}
+	r.leftMessageAt = state.LeftMessageAt
+	r.outreach = state.PendingOutreach
+	if ValidRoomStatus(state.Status) {
+		r.status = state.Status
+		r.statusChanged = state.StatusChangedAt
+	}
+	if state.AssignedAgentID != "" {
+		participant := &Participant{
+			ID:          state.AssignedAgentID,
+			DisplayName: state.AssignedAgentName,
+			Role:        RoleAgent,
+			LastSeen:    state.LastActivity,
+		}
+		r.agents[participant.ID] = participant
+		r.assignedAgent = participant
+	}
+}
+
+// State captures the durable part of the room for persistence.
+func (r *Room) State() RoomState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state := RoomState{
+		RoomID:           r.id,
+		Agency:           r.agency,
+		OwnerID:          r.ownerID,
+		CreatedAt:        r.createdAt,
+		LastActivity:     r.lastActivity,
+		NextSequence:     r.nextSequence,
+		Status:           r.status,
+		StatusChangedAt:  r.statusChanged,
+		Assignments:      append([]AssignmentRecord(nil), r.assignments...),
+		RequiredSkills:   append([]string(nil), r.skills...),
+		Priority:         r.priority,
+		PriorityOverride: r.prioritySet,
+		QueuedAt:         r.queuedAt,
+		LeftMessageAt:    r.leftMessageAt,
+		PendingOutreach:  r.outreach,
+	}
+	if r.assignedAgent != nil {
+		state.AssignedAgentID = r.assignedAgent.ID
+		state.AssignedAgentName = r.assignedAgent.DisplayName
+	}
+	return state
+}
+
+// idleFor reports how long the room has had no connected clients, or zero
+// while any client is still attached.
+func (r *Room) idleFor(now time.Time) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// A transfer waiting to be accepted is not persisted, so the room is kept
+	// until it is accepted or cancelled.
+	if len(r.clients) > 0 || r.transfer != nil {
+		return 0
+	}
+	since := r.lastActivity
+	if r.idleSince.After(since) {
+		since = r.idleSince
+	}
+	return now.Sub(since)
+}
+
+func (r *Room) counts() (messages, clients int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.history), len(r.clients)
+}
+
 func (r *Room) Clients() []*Client {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -227,6 +523,226 @@ func (r *Room) SetAssignedAgent(agentID, displayName string) {
 
 	participant.Connected = false
 	r.assignedAgent = participant
+	r.transfer = nil
+	r.queuedAt = time.Time{}
+	r.leftMessageAt = time.Time{}
+	r.botActive = false
+}
+
+// ClearAssignedAgent returns the room to the waiting queue.
+func (r *Room) ClearAssignedAgent() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.assignedAgent = nil
+	r.transfer = nil
+	r.queuedAt = time.Now()
+}
+
+// Priority returns the room's queue priority; higher values are served first.
+func (r *Room) Priority() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.priority
+}
+
+func (r *Room) SetPriority(priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.priority = priority
+}
+
+// overridePriority sets the priority chosen by an admin, which the priority
+// resolver no longer replaces.
+func (r *Room) overridePriority(priority int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.priority = priority
+	r.prioritySet = true
+}
+
+// PriorityOverridden reports whether an admin chose the room's priority.
+func (r *Room) PriorityOverridden() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.prioritySet
+}
+
+// playerName returns the display name of the room's player.
+func (r *Room) playerName() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, participant := range r.players {
+		return participant.DisplayName
+	}
+	return ""
+}
+
+// leftMessage returns when the player left a message while support was offline.
+func (r *Room) leftMessage() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.leftMessageAt, !r.leftMessageAt.IsZero()
+}
+
+// markLeftMessage flags a waiting room as a message left while support was
+// offline. It reports false when the room is assigned or already flagged.
+func (r *Room) markLeftMessage(at time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.assignedAgent != nil || r.botActive || !r.leftMessageAt.IsZero() {
+		return false
+	}
+	r.leftMessageAt = at
+	return true
+}
+
+// queueOutreach counts an agent-initiated message as undelivered unless the
+// player is connected to receive it right away.
+func (r *Room) queueOutreach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.players {
+		if p.Connected {
+			return
+		}
+	}
+	r.outreach++
+}
+
+// clearOutreach resets the undelivered outreach count, reporting whether there
+// was anything pending.
+func (r *Room) clearOutreach() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.outreach == 0 {
+		return false
+	}
+	r.outreach = 0
+	return true
+}
+
+// PendingOutreach returns how many agent-initiated messages the player has not
+// received yet.
+func (r *Room) PendingOutreach() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.outreach
+}
+
+// waiting reports whether the room is open without an assigned agent, and
+// since when. Rooms handled by the auto-reply bot are not waiting yet.
+func (r *Room) waiting() (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.status != RoomStatusOpen || r.assignedAgent != nil || r.botActive {
+		return time.Time{}, false
+	}
+	return r.queuedAt, true
+}
+
+// startBot hands an open, unassigned room to the auto-reply bot.
+func (r *Room) startBot() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != RoomStatusOpen || r.assignedAgent != nil || r.botActive {
+		return false
+	}
+	r.botActive = true
+	return true
+}
+
+// stopBot takes the room away from the auto-reply bot and queues it for an
+// agent from at. It reports false when the bot was not handling the room.
+func (r *Room) stopBot(at time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.botActive {
+		return false
+	}
+	r.botActive = false
+	if r.assignedAgent == nil {
+		r.queuedAt = at
+	}
+	return true
+}
+
+// BotActive reports whether the auto-reply bot is answering the room.
+func (r *Room) BotActive() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.botActive
+}
+
+func (r *Room) recordAssignment(record AssignmentRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record.At.IsZero() {
+		record.At = time.Now()
+	}
+	r.assignments = append(r.assignments, record)
+}
+
+// Assignments returns the assignment history of the room, oldest first.
+func (r *Room) Assignments() []AssignmentRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	assignments := make([]AssignmentRecord, len(r.assignments))
+	copy(assignments, r.assignments)
+	return assignments
+}
+
+func (r *Room) setPendingTransfer(transfer PendingTransfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transfer = &transfer
+}
+
+// takePendingTransfer removes and returns the pending transfer if it is
+// addressed to the given agent.
+func (r *Room) takePendingTransfer(agentID string) (*PendingTransfer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.transfer == nil {
+		return nil, ErrNoPendingTransfer
+	}
+	if r.transfer.ToAgentID != agentID {
+		return nil, ErrTransferNotForAgent
+	}
+	transfer := r.transfer
+	r.transfer = nil
+	return transfer, nil
+}
+
+// RequiredSkills lists the skill tags an agent needs to be assigned the room.
+func (r *Room) RequiredSkills() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.skills...)
+}
+
+func (r *Room) SetRequiredSkills(skills []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.skills = append([]string(nil), skills...)
 }
 
 func (r *Room) AssignedAgent() *Participant {
@@ -256,48 +772,31 @@ func (r *Room) Snapshot() RoomSnapshot {
 	history := make([]ChatMessage, len(r.history))
 	copy(history, r.history)
 
-	connectedPlayers := 0
-	for _, p := range r.players {
-		if p.Connected {
-			connectedPlayers++
-		}
-	}
-	connectedAgents := 0
-	for _, p := range r.agents {
-		if p.Connected {
-			connectedAgents++
-		}
-	}
+	assignments := make([]AssignmentRecord, len(r.assignments))
+	copy(assignments, r.assignments)
 
-	summary := RoomSummary{
-		RoomID:               r.id,
-		CreatedAt:            r.createdAt,
-		LastActivity:         r.lastActivity,
-		PlayerCount:          len(r.players),
-		AgentCount:           len(r.agents),
-		ConnectedPlayerCount: connectedPlayers,
-		ConnectedAgentCount:  connectedAgents,
-	}
-	if r.assignedAgent != nil {
-		summary.AssignedAgentID = r.assignedAgent.ID
-		summary.AssignedAgent = r.assignedAgent.DisplayName
-	}
-	if len(r.history) > 0 {
-		summary.LastMessage = r.history[len(r.history)-1].Content
-	}
-
-	return RoomSnapshot{
-		Summary:      summary,
+	snapshot := RoomSnapshot{
+		Summary:      r.summaryLocked(),
 		Participants: participants,
 		History:      history,
 		NextSequence: r.nextSequence,
+		Assignments:  assignments,
 	}
+	if r.transfer != nil {
+		transfer := *r.transfer
+		snapshot.PendingTransfer = &transfer
+	}
+	return snapshot
 }
 
 func (r *Room) Summary() RoomSummary {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.summaryLocked()
+}
+
+func (r *Room) summaryLocked() RoomSummary {
 	connectedPlayers := 0
 	for _, p := range r.players {
 		if p.Connected {
@@ -313,19 +812,44 @@ func (r *Room) Summary() RoomSummary {
 
 	summary := RoomSummary{
 		RoomID:               r.id,
+		Agency:               r.agency,
+		OwnerID:              r.ownerID,
 		CreatedAt:            r.createdAt,
 		LastActivity:         r.lastActivity,
 		PlayerCount:          len(r.players),
 		AgentCount:           len(r.agents),
 		ConnectedPlayerCount: connectedPlayers,
 		ConnectedAgentCount:  connectedAgents,
+		Status:               r.status,
+		StatusChangedAt:      r.statusChanged,
+		RequiredSkills:       append([]string(nil), r.skills...),
+		Priority:             r.priority,
+		PriorityOverride:     r.prioritySet,
+		PendingOutreach:      r.outreach,
+	}
+	if r.status == RoomStatusOpen && r.assignedAgent == nil {
+		if r.botActive {
+			summary.BotActive = true
+		} else {
+			summary.WaitingSince = r.queuedAt
+		}
+	}
+	if r.assignedAgent == nil {
+		summary.LeftMessageAt = r.leftMessageAt
 	}
 	if r.assignedAgent != nil {
 		summary.AssignedAgentID = r.assignedAgent.ID
 		summary.AssignedAgent = r.assignedAgent.DisplayName
 	}
-	if len(r.history) > 0 {
-		summary.LastMessage = r.history[len(r.history)-1].Content
+	// Internal notes never become the preview shown in room lists.
+	for i := len(r.history) - 1; i >= 0; i-- {
+		if !r.history[i].IsInternal() {
+			summary.LastMessage = r.history[i].Content
+			break
+		}
+	}
+	if r.transfer != nil {
+		summary.PendingTransferTo = r.transfer.ToAgentID
 	}
 	return summary
 }
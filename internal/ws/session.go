@@ -0,0 +1,203 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSessionTTL is how long an HTTP fallback session survives without
+	// an active poll or stream before it is closed.
+	DefaultSessionTTL = time.Minute
+
+	sessionBufferSize = 64
+)
+
+// ErrSessionNotFound is returned when an HTTP fallback session is unknown or
+// has already expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is an HTTP fallback connection for clients that cannot upgrade to
+// WebSocket. Downstream messages are read through Poll, either by a
+// Server-Sent Events stream or by long-polling, and upstream envelopes are
+// posted to Handle. The wrapped Client joins the hub like any other, so rooms,
+// sequencing and history behave exactly as they do over WebSocket.
+type Session struct {
+	ID       string
+	client   *Client
+	conn     *MemoryConn
+	mu       sync.Mutex
+	lastSeen time.Time
+	active   int
+}
+
+// NewSessionClient creates a client backed by an in-memory connection for use
+// by an HTTP fallback session.
+func NewSessionClient(hub *Hub, roomID, id, role, displayName string) *Client {
+	return NewClient(hub, NewMemoryConn(sessionBufferSize), roomID, id, role, displayName)
+}
+
+// WithSessionTTL overrides how long idle HTTP fallback sessions are kept.
+func WithSessionTTL(ttl time.Duration) HubOption {
+	return func(h *Hub) {
+		if ttl > 0 {
+			h.sessionTTL = ttl
+		}
+	}
+}
+
+// OpenSession registers the client with the hub and returns a session that
+// carries its traffic over plain HTTP.
+func (h *Hub) OpenSession(c *Client) (*Session, error) {
+	conn, ok := c.conn.(*MemoryConn)
+	if !ok {
+		return nil, errors.New("session clients require a memory connection")
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.Register(c); err != nil {
+		return nil, err
+	}
+
+	session := &Session{ID: id, client: c, conn: conn, lastSeen: time.Now()}
+	h.sessionsMu.Lock()
+	h.sessions[id] = session
+	h.sessionsMu.Unlock()
+	return session, nil
+}
+
+// Session looks up an open HTTP fallback session.
+func (h *Hub) Session(id string) (*Session, error) {
+	h.sessionsMu.Lock()
+	session, ok := h.sessions[id]
+	h.sessionsMu.Unlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	session.touch(time.Now())
+	return session, nil
+}
+
+// CloseSession detaches the session's client from the hub.
+func (h *Hub) CloseSession(id string) {
+	h.sessionsMu.Lock()
+	session, ok := h.sessions[id]
+	delete(h.sessions, id)
+	h.sessionsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	h.Unregister(session.client)
+	session.client.Close()
+}
+
+// ExpireSessions closes fallback sessions that have not been polled within
+// the session TTL and returns how many were closed.
+func (h *Hub) ExpireSessions(now time.Time) int {
+	h.sessionsMu.Lock()
+	expired := make([]string, 0)
+	for id, session := range h.sessions {
+		if session.idleFor(now) >= h.sessionTTL {
+			expired = append(expired, id)
+		}
+	}
+	h.sessionsMu.Unlock()
+
+	for _, id := range expired {
+		h.CloseSession(id)
+	}
+	return len(expired)
+}
+
+// Client returns the hub client carried by the session.
+func (s *Session) Client() *Client {
+	return s.client
+}
+
+// Handle processes an envelope posted by the session's client, reporting
+// errors back downstream the same way the WebSocket read loop does.
+func (s *Session) Handle(env Envelope) error {
+	return s.client.Receive(env)
+}
+
+// Poll waits up to wait for downstream messages and returns everything that
+// is queued once the first one arrives. It returns ErrSessionNotFound once the
+// session has been closed.
+func (s *Session) Poll(ctx context.Context, wait time.Duration) ([]json.RawMessage, error) {
+	s.begin()
+	defer s.end()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var messages []json.RawMessage
+	select {
+	case payload, ok := <-s.conn.Messages():
+		if !ok {
+			return nil, ErrSessionNotFound
+		}
+		messages = append(messages, payload)
+	case <-timer.C:
+		return messages, nil
+	case <-ctx.Done():
+		return messages, ctx.Err()
+	}
+
+	for {
+		select {
+		case payload, ok := <-s.conn.Messages():
+			if !ok {
+				return messages, nil
+			}
+			messages = append(messages, payload)
+		default:
+			return messages, nil
+		}
+	}
+}
+
+func (s *Session) touch(now time.Time) {
+	s.mu.Lock()
+	s.lastSeen = now
+	s.mu.Unlock()
+}
+
+func (s *Session) begin() {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+}
+
+func (s *Session) end() {
+	s.mu.Lock()
+	s.active--
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+// idleFor reports how long the session has gone without a poll in progress.
+func (s *Session) idleFor(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active > 0 {
+		return 0
+	}
+	return now.Sub(s.lastSeen)
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"im/internal/simplews"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 8192
+)
+
+// SocketConn adapts a simplews WebSocket connection to Conn. Outgoing
+// envelopes are buffered and written by a dedicated goroutine.
+type SocketConn struct {
+	conn   *simplews.Conn
+	mu     sync.Mutex
+	send   chan []byte
+	closed bool
+}
+
+// NewSocketConn wraps an upgraded WebSocket connection.
+func NewSocketConn(conn *simplews.Conn) *SocketConn {
+	return &SocketConn{
+		conn: conn,
+		send: make(chan []byte, 16),
+	}
+}
+
+func (s *SocketConn) Send(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrConnClosed
+	}
+	select {
+	case s.send <- payload:
+		return nil
+	default:
+		return ErrSendBufferFull
+	}
+}
+
+// Close stops the write loop, which sends a close frame and closes the socket.
+func (s *SocketConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.send)
+	}
+	return nil
+}
+
+// Serve pumps the socket for the client until the connection drops, then
+// unregisters the client from its hub. It blocks for the connection lifetime.
+func (s *SocketConn) Serve(c *Client) {
+	go s.writePump()
+	s.readPump(c)
+}
+
+func (s *SocketConn) readPump(c *Client) {
+	defer func() {
+		c.hub.Unregister(c)
+		c.Close()
+		_ = s.conn.Close()
+	}()
+
+	s.conn.SetReadLimit(maxMessageSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := s.conn.ReadMessage()
+		if err != nil {
+			if simplews.IsUnexpectedCloseError(err, simplews.CloseGoingAway, simplews.CloseAbnormalClosure) {
+				log.Printf("websocket unexpected close: %v", err)
+			}
+			break
+		}
+
+		var envelope Envelope
+		if err := json.Unmarshal(message, &envelope); err != nil {
+			c.sendSystemError("格式錯誤，請重新傳送")
+			continue
+		}
+
+		_ = c.Receive(envelope)
+	}
+}
+
+func (s *SocketConn) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = s.Close()
+		_ = s.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				_ = s.conn.WriteMessage(simplews.CloseMessage, []byte{})
+				return
+			}
+
+			if err := s.conn.WriteMessage(simplews.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := s.conn.WriteMessage(simplews.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Agent availability states. Only available agents receive new assignments
+// and transfers; the other states are chosen explicitly by the agent or a
+// supervisor and survive reconnects.
+const (
+	AgentStatusAvailable = "available"
+	AgentStatusAway      = "away"
+	AgentStatusBusy      = "busy"
+	AgentStatusOffline   = "offline"
+)
+
+// EventAgentStatus is published to the admin event stream when an agent's
+// availability changes.
+const EventAgentStatus = "agent.status"
+
+var (
+	// ErrInvalidAgentStatus is returned for an unknown availability state.
+	ErrInvalidAgentStatus = errors.New("invalid agent status")
+	// ErrAgentUnavailable is returned when assigning a room to an agent who is
+	// away, busy or offline.
+	ErrAgentUnavailable = errors.New("agent is not available")
+)
+
+// AgentStatus is an agent's chosen availability and when it last changed.
+type AgentStatus struct {
+	AgentID   string    `json:"agentId"`
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changedAt"`
+	ChangedBy string    `json:"changedBy,omitempty"`
+}
+
+// ValidAgentStatus reports whether status is a known availability state.
+func ValidAgentStatus(status string) bool {
+	switch status {
+	case AgentStatusAvailable, AgentStatusAway, AgentStatusBusy, AgentStatusOffline:
+		return true
+	default:
+		return false
+	}
+}
+
+// WithAgentStatusStore persists agent availability across reconnects and
+// restarts.
+func WithAgentStatusStore(store AgentStatusStore) HubOption {
+	return func(h *Hub) {
+		h.statusStore = store
+	}
+}
+
+// SetAgentStatus records an agent's availability, notifies the agent's own
+// connections and publishes the change to the admin event stream.
+func (h *Hub) SetAgentStatus(agentID, status, changedBy string) (AgentStatus, error) {
+	if !ValidAgentStatus(status) {
+		return AgentStatus{}, ErrInvalidAgentStatus
+	}
+
+	record := AgentStatus{
+		AgentID:   agentID,
+		Status:    status,
+		ChangedAt: time.Now(),
+		ChangedBy: changedBy,
+	}
+	if h.statusStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		if err := h.statusStore.SaveAgentStatus(ctx, record); err != nil {
+			return AgentStatus{}, err
+		}
+	}
+
+	h.statusMu.Lock()
+	previous, known := h.agentStatuses[agentID]
+	h.agentStatuses[agentID] = record
+	h.statusMu.Unlock()
+
+	var agency string
+	for _, client := range h.agentClients(agentID) {
+		if agency == "" {
+			agency = client.Agency
+		}
+		_ = client.SendEnvelope(Envelope{
+			Cmd:       MessageTypeAgentStatus,
+			Type:      MessageTypeAgentStatus,
+			RoomID:    client.RoomID,
+			Timestamp: record.ChangedAt,
+			Metadata: map[string]string{
+				"status":    record.Status,
+				"changedAt": record.ChangedAt.Format(time.RFC3339),
+				"changedBy": record.ChangedBy,
+			},
+		})
+	}
+
+	presence := h.agentPresence(agentID)
+	if presence == nil {
+		presence = &AgentPresence{
+			ID:              agentID,
+			Rooms:           make([]string, 0),
+			Status:          record.Status,
+			StatusChangedAt: record.ChangedAt,
+		}
+	}
+	h.publish(HubEvent{
+		Type:   EventAgentStatus,
+		Agency: agency,
+		Agent:  presence,
+	})
+	if known && previous.Status != AgentStatusAvailable && record.Status == AgentStatusAvailable {
+		h.alertOfflineBacklog(agentID, agency)
+	}
+
+	return record, nil
+}
+
+// AgentStatus returns an agent's current availability. Agents who never chose
+// a status are available while connected and offline otherwise.
+func (h *Hub) AgentStatus(agentID string) AgentStatus {
+	h.loadAgentStatus(agentID)
+	status := h.cachedAgentStatus(agentID)
+	if status.ChangedAt.IsZero() && !h.agentConnected(agentID) {
+		status.Status = AgentStatusOffline
+	}
+	return status
+}
+
+// loadAgentStatus fills the cache from the store the first time an agent is
+// seen, so a status chosen before a reconnect or restart still applies.
+func (h *Hub) loadAgentStatus(agentID string) {
+	h.statusMu.RLock()
+	_, ok := h.agentStatuses[agentID]
+	h.statusMu.RUnlock()
+	if ok || h.statusStore == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	record, err := h.statusStore.LoadAgentStatus(ctx, agentID)
+	if err != nil {
+		log.Printf("load agent %s status: %v", agentID, err)
+		return
+	}
+	if record == nil {
+		record = &AgentStatus{AgentID: agentID, Status: AgentStatusAvailable}
+	}
+
+	h.statusMu.Lock()
+	if _, ok := h.agentStatuses[agentID]; !ok {
+		h.agentStatuses[agentID] = *record
+	}
+	h.statusMu.Unlock()
+}
+
+func (h *Hub) cachedAgentStatus(agentID string) AgentStatus {
+	h.statusMu.RLock()
+	defer h.statusMu.RUnlock()
+
+	if record, ok := h.agentStatuses[agentID]; ok {
+		return record
+	}
+	return AgentStatus{AgentID: agentID, Status: AgentStatusAvailable}
+}
+
+// agentAvailable reports ErrAgentUnavailable unless the agent is connected and
+// may take new rooms.
+func (h *Hub) agentAvailable(agentID string) error {
+	if !h.agentConnected(agentID) || h.AgentStatus(agentID).Status != AgentStatusAvailable {
+		return ErrAgentUnavailable
+	}
+	return nil
+}
+
+// agentConnected reports whether the agent has a visible connection open.
+func (h *Hub) agentConnected(agentID string) bool {
+	return len(h.agentClients(agentID)) > 0
+}
+
+// agentClients returns every visible connection of an agent, across rooms and
+// multiplexed sockets.
+func (h *Hub) agentClients(agentID string) []*Client {
+	return h.agentConnections(func(c *Client) bool {
+		return c.ID == agentID
+	})
+}
+
+// agentConnections returns the visible agent connections that match, across
+// rooms and multiplexed sockets.
+func (h *Hub) agentConnections(match func(*Client) bool) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.agentConnectionsLocked(match)
+}
+
+// agentConnectionsLocked is agentConnections for callers holding h.mu.
+func (h *Hub) agentConnectionsLocked(match func(*Client) bool) []*Client {
+	seen := make(map[*Client]struct{})
+	clients := make([]*Client, 0)
+	add := func(c *Client) {
+		if c.Role != RoleAgent || !match(c) {
+			return
+		}
+		if _, ok := seen[c]; ok {
+			return
+		}
+		seen[c] = struct{}{}
+		clients = append(clients, c)
+	}
+	for c := range h.multiplexed {
+		add(c)
+	}
+	for _, room := range h.rooms {
+		for _, c := range room.Clients() {
+			if !room.IsObserver(c) {
+				add(c)
+			}
+		}
+	}
+	return clients
+}
+
+func (h *Hub) handleAgentStatusCommand(c *Client, env Envelope) error {
+	if c.Role != RoleAgent {
+		return ErrNotPermitted
+	}
+
+	status := env.Content
+	if env.Metadata != nil && env.Metadata["status"] != "" {
+		status = env.Metadata["status"]
+	}
+	_, err := h.SetAgentStatus(c.ID, status, c.DisplayName)
+	return err
+}
@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"context"
+	"time"
+)
+
+// MessageStore persists chat history so that rooms can serve pages older than
+// what is kept in memory.
+type MessageStore interface {
+	SaveMessage(ctx context.Context, msg ChatMessage) error
+	MessagesBefore(ctx context.Context, roomID string, before int64, limit int) ([]ChatMessage, error)
+	LatestSequence(ctx context.Context, roomID string) (int64, error)
+}
+
+// RoomState is the durable part of a room that survives eviction from memory.
+type RoomState struct {
+	RoomID            string
+	Agency            string
+	OwnerID           string
+	CreatedAt         time.Time
+	LastActivity      time.Time
+	NextSequence      int64
+	AssignedAgentID   string
+	AssignedAgentName string
+	Status            string
+	StatusChangedAt   time.Time
+	Assignments       []AssignmentRecord
+	RequiredSkills    []string
+	Priority          int
+	PriorityOverride  bool
+	QueuedAt          time.Time
+	LeftMessageAt     time.Time
+	PendingOutreach   int
+}
+
+// RoomStore persists room state so that evicted rooms can be reloaded with
+// their sequence numbering and assignment intact.
+type RoomStore interface {
+	SaveRoomState(ctx context.Context, state RoomState) error
+	// LoadRoomState returns nil without an error when the room is unknown.
+	LoadRoomState(ctx context.Context, roomID string) (*RoomState, error)
+	// LoadActiveRoomState returns the most recently active room of the owner
+	// in the agency that is not closed, or nil without an error when there is
+	// none.
+	LoadActiveRoomState(ctx context.Context, agency, ownerID string) (*RoomState, error)
+	// LoadInactiveRoomStates returns up to limit rooms that are not closed and
+	// have had no activity since before, least recently active first.
+	LoadInactiveRoomStates(ctx context.Context, before time.Time, limit int) ([]RoomState, error)
+	// ActiveRoomIDs returns the rooms assigned to the agent that are open or
+	// pending.
+	ActiveRoomIDs(ctx context.Context, agentID string) ([]string, error)
+}
+
+// AgentStatusStore persists the availability agents choose so that it
+// survives reconnects and restarts, and keeps a timestamped log of changes.
+type AgentStatusStore interface {
+	SaveAgentStatus(ctx context.Context, status AgentStatus) error
+	// LoadAgentStatus returns nil without an error when the agent has never
+	// set a status.
+	LoadAgentStatus(ctx context.Context, agentID string) (*AgentStatus, error)
+}
+
+// AgentProfileStore persists agent capacity, languages and skills.
+type AgentProfileStore interface {
+	SaveAgentProfile(ctx context.Context, profile AgentProfile) error
+	// LoadAgentProfile returns nil without an error when the agent has no
+	// profile.
+	LoadAgentProfile(ctx context.Context, agentID string) (*AgentProfile, error)
+	ListAgentProfiles(ctx context.Context) ([]AgentProfile, error)
+}
+
+// GreetingStore persists the greeting, away message and pre-chat menu of each
+// agency.
+type GreetingStore interface {
+	SaveGreeting(ctx context.Context, greeting AgencyGreeting) error
+	// LoadGreeting returns nil without an error when the agency has no
+	// greeting.
+	LoadGreeting(ctx context.Context, agency string) (*AgencyGreeting, error)
+}
+
+// BotRuleStore persists the auto-reply rules of each agency.
+type BotRuleStore interface {
+	SaveBotRules(ctx context.Context, bot AgencyBot) error
+	// LoadBotRules returns nil without an error when the agency has no
+	// rules.
+	LoadBotRules(ctx context.Context, agency string) (*AgencyBot, error)
+}
+
+// AnnouncementStore keeps announcements that are also sent to players who
+// connect before they expire.
+type AnnouncementStore interface {
+	SaveAnnouncement(ctx context.Context, announcement Announcement) error
+	// LoadActiveAnnouncements returns the announcements that have not expired
+	// at now.
+	LoadActiveAnnouncements(ctx context.Context, now time.Time) ([]Announcement, error)
+	// DeleteAnnouncement returns ErrAnnouncementNotFound when nothing was
+	// removed.
+	DeleteAnnouncement(ctx context.Context, id string) error
+}
+
+// CannedResponseStore persists the canned responses library.
+type CannedResponseStore interface {
+	// SaveCannedResponse inserts the response when its ID is zero and returns
+	// it with the assigned ID. It returns ErrCannedShortcutTaken when the
+	// shortcut is already used in the same scope.
+	SaveCannedResponse(ctx context.Context, response CannedResponse) (CannedResponse, error)
+	// LoadCannedResponse returns nil without an error when the response does
+	// not exist.
+	LoadCannedResponse(ctx context.Context, id int64) (*CannedResponse, error)
+	ListCannedResponses(ctx context.Context, filter CannedResponseFilter) ([]CannedResponse, error)
+	// DeleteCannedResponse returns ErrCannedResponseNotFound when nothing was
+	// removed.
+	DeleteCannedResponse(ctx context.Context, id int64) error
+}
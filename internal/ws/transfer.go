@@ -0,0 +1,270 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNoPendingTransfer is returned when accepting a room that has no transfer waiting.
+	ErrNoPendingTransfer = errors.New("no pending transfer")
+	// ErrTransferNotForAgent is returned when a transfer is accepted by someone other than its recipient.
+	ErrTransferNotForAgent = errors.New("transfer is addressed to another agent")
+	// ErrNotPermitted is returned when a client sends a command its role may not use.
+	ErrNotPermitted = errors.New("command not permitted")
+)
+
+// TransferRequest hands a room to another agent, or back to the waiting queue
+// when ToAgentID is empty. The note is only ever shown to agents.
+type TransferRequest struct {
+	ToAgentID   string
+	ToAgent     string
+	Note        string
+	RequestedBy string
+}
+
+// TransferRoom starts a handoff. Transfers to an agent stay pending until the
+// receiving agent accepts them; transfers to the queue take effect at once.
+// The receiving agent goes through the availability, capacity and skill
+// checks of a regular assignment.
+func (h *Hub) TransferRoom(roomID string, req TransferRequest) (*PendingTransfer, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	var fromID, fromName string
+	if current := room.AssignedAgent(); current != nil {
+		fromID, fromName = current.ID, current.DisplayName
+	}
+	now := time.Now()
+
+	if req.ToAgentID == "" {
+		room.ClearAssignedAgent()
+		room.recordAssignment(AssignmentRecord{
+			Action:      AssignmentQueued,
+			FromAgentID: fromID,
+			Note:        req.Note,
+			By:          req.RequestedBy,
+			At:          now,
+		})
+		h.persistRoom(room)
+
+		h.broadcastToPlayers(room, Envelope{
+			Cmd:       MessageTypeSystem,
+			Type:      MessageTypeSystem,
+			RoomID:    roomID,
+			Timestamp: now,
+			Content:   "對話已轉回等候佇列，將由下一位客服協助您",
+		})
+		h.broadcastToAgents(room, Envelope{
+			Cmd:       MessageTypeSystem,
+			Type:      MessageTypeSystem,
+			RoomID:    roomID,
+			Timestamp: now,
+			Content:   fmt.Sprintf("%s 將對話轉回等候佇列", transferActor(req.RequestedBy, fromName)),
+			Metadata: map[string]string{
+				"transfer":    AssignmentQueued,
+				"fromAgentId": fromID,
+				"note":        req.Note,
+			},
+		})
+		h.publishRoomEvent(EventRoomAssigned, room)
+		return nil, nil
+	}
+
+	if err := h.transferProblem(room, req.ToAgentID); err != nil {
+		return nil, err
+	}
+	toName := req.ToAgent
+	if presence := h.agentPresence(req.ToAgentID); presence != nil && presence.DisplayName != "" {
+		toName = presence.DisplayName
+	}
+	if toName == "" {
+		toName = req.ToAgentID
+	}
+
+	transfer := PendingTransfer{
+		FromAgentID: fromID,
+		FromAgent:   fromName,
+		ToAgentID:   req.ToAgentID,
+		ToAgent:     toName,
+		Note:        req.Note,
+		RequestedBy: req.RequestedBy,
+		RequestedAt: now,
+	}
+	room.setPendingTransfer(transfer)
+
+	h.broadcastToPlayers(room, Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    roomID,
+		Timestamp: now,
+		Content:   "正在為您轉接客服，請稍候",
+	})
+	notice := Envelope{
+		Cmd:       MessageTypeSystem,
+		Type:      MessageTypeSystem,
+		RoomID:    roomID,
+		Timestamp: now,
+		Content:   fmt.Sprintf("%s 請求將對話轉接給 %s", transferActor(req.RequestedBy, fromName), toName),
+		Metadata: map[string]string{
+			"transfer":    "requested",
+			"fromAgentId": fromID,
+			"toAgentId":   req.ToAgentID,
+			"toAgent":     toName,
+			"note":        req.Note,
+		},
+	}
+	h.broadcastToAgents(room, notice)
+	// The receiving agent is usually not in the room yet, so reach them on
+	// whichever connections they have open.
+	for _, client := range h.agentClients(req.ToAgentID) {
+		if !room.HasClient(client) {
+			_ = client.SendEnvelope(notice)
+		}
+	}
+	h.publishRoomEvent(EventRoomUpdated, room)
+
+	return &transfer, nil
+}
+
+// AcceptTransfer completes a pending transfer on behalf of the receiving agent.
+// The agent must still pass the checks of a regular assignment; the transfer
+// stays pending when they do not.
+func (h *Hub) AcceptTransfer(roomID, agentID string) (*Participant, error) {
+	room := h.knownRoom(roomID)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+
+	if room.Summary().PendingTransferTo == agentID {
+		if err := h.transferProblem(room, agentID); err != nil {
+			return nil, err
+		}
+	}
+	transfer, err := room.takePendingTransfer(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	room.SetAssignedAgent(transfer.ToAgentID, transfer.ToAgent)
+	assigned := room.AssignedAgent()
+	if assigned == nil {
+		return nil, errors.New("unable to assign agent")
+	}
+	room.recordAssignment(AssignmentRecord{
+		Action:      AssignmentTransferred,
+		AgentID:     assigned.ID,
+		DisplayName: assigned.DisplayName,
+		FromAgentID: transfer.FromAgentID,
+		Note:        transfer.Note,
+		By:          transfer.RequestedBy,
+	})
+	h.persistRoom(room)
+
+	now := time.Now()
+	h.broadcastToPlayers(room, Envelope{
+		Cmd:         MessageTypeSystem,
+		Type:        MessageTypeSystem,
+		RoomID:      roomID,
+		Timestamp:   now,
+		Content:     fmt.Sprintf("客服 %s 將協助這個對話", assigned.DisplayName),
+		SenderID:    assigned.ID,
+		SenderRole:  RoleAgent,
+		DisplayName: assigned.DisplayName,
+		Metadata: map[string]string{
+			"assignedAgent":   assigned.DisplayName,
+			"assignedAgentId": assigned.ID,
+		},
+	})
+	h.broadcastToAgents(room, Envelope{
+		Cmd:         MessageTypeSystem,
+		Type:        MessageTypeSystem,
+		RoomID:      roomID,
+		Timestamp:   now,
+		Content:     fmt.Sprintf("%s 已接手對話", assigned.DisplayName),
+		SenderID:    assigned.ID,
+		SenderRole:  RoleAgent,
+		DisplayName: assigned.DisplayName,
+		Metadata: map[string]string{
+			"transfer":        AssignmentTransferred,
+			"assignedAgent":   assigned.DisplayName,
+			"assignedAgentId": assigned.ID,
+			"fromAgentId":     transfer.FromAgentID,
+			"note":            transfer.Note,
+		},
+	})
+	h.publishRoomEvent(EventRoomAssigned, room)
+
+	return assigned, nil
+}
+
+// transferProblem runs the checks of a regular assignment against the agent
+// receiving a transfer: availability, capacity and required skills.
+func (h *Hub) transferProblem(room *Room, agentID string) error {
+	if err := h.agentAvailable(agentID); err != nil {
+		return err
+	}
+	problems, err := h.assignmentProblems(room, agentID)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// Assignments returns the assignment history of a room, oldest first.
+func (h *Hub) Assignments(roomID string) ([]AssignmentRecord, error) {
+	room := h.storedRoom(roomID)
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	return room.Assignments(), nil
+}
+
+func (h *Hub) handleTransferCommand(c *Client, roomID string, env Envelope) error {
+	if c.Role != RoleAgent {
+		return ErrNotPermitted
+	}
+
+	switch env.Cmd {
+	case MessageTypeTransfer:
+		// Only the agent handling the room may hand it over.
+		room := h.getRoom(roomID)
+		if room == nil {
+			return ErrRoomNotFound
+		}
+		if assigned := room.AssignedAgent(); assigned == nil || assigned.ID != c.ID {
+			return ErrNotPermitted
+		}
+		var toAgentID, toAgent string
+		if env.Metadata != nil {
+			toAgentID = env.Metadata["toAgentId"]
+			toAgent = env.Metadata["toAgent"]
+		}
+		_, err := h.TransferRoom(roomID, TransferRequest{
+			ToAgentID:   toAgentID,
+			ToAgent:     toAgent,
+			Note:        env.Content,
+			RequestedBy: c.DisplayName,
+		})
+		return err
+	default:
+		_, err := h.AcceptTransfer(roomID, c.ID)
+		return err
+	}
+}
+
+func transferActor(requestedBy, fromName string) string {
+	if requestedBy != "" {
+		return requestedBy
+	}
+	if fromName != "" {
+		return fromName
+	}
+	return "系統"
+}
@@ -7,16 +7,53 @@ const (
 	MessageTypeTyping  = "chat.typing"
 	MessageTypeHistory = "chat.history"
 	MessageTypeSystem  = "system.notice"
+	// MessageTypeInternal is an agent-only note that is kept in history but
+	// never delivered to players.
+	MessageTypeInternal = "chat.internal"
+
+	MessageTypeTransfer       = "room.transfer"
+	MessageTypeTransferAccept = "room.transfer.accept"
+	// MessageTypeBargeIn turns a silent observer into a visible agent.
+	MessageTypeBargeIn = "room.barge"
+	// MessageTypeSubscribe and MessageTypeUnsubscribe manage the rooms of a
+	// multiplexed agent connection.
+	MessageTypeSubscribe   = "room.subscribe"
+	MessageTypeUnsubscribe = "room.unsubscribe"
+	// MessageTypeAgentStatus sets the sending agent's availability; the
+	// requested status is carried in metadata.status.
+	MessageTypeAgentStatus = "agent.status"
+	// MessageTypeCannedSend posts a canned response, chosen by
+	// metadata.cannedId and rendered by the server, as a chat message.
+	MessageTypeCannedSend = "canned.send"
 )
 
 const (
 	RolePlayer = "player"
 	RoleAgent  = "agent"
+	// RoleBot marks the messages of the auto-reply bot.
+	RoleBot = "bot"
+)
+
+// Assignment history actions.
+const (
+	AssignmentAssigned    = "assigned"
+	AssignmentTransferred = "transferred"
+	AssignmentQueued      = "queued"
+)
+
+// Room lifecycle states. A room starts open, may be parked as pending while
+// waiting on the player, is resolved by an agent and is finally closed.
+const (
+	RoomStatusOpen     = "open"
+	RoomStatusPending  = "pending"
+	RoomStatusResolved = "resolved"
+	RoomStatusClosed   = "closed"
 )
 
 // ChatMessage represents a persisted chat message that belongs to a room.
 type ChatMessage struct {
 	RoomID      string            `json:"roomId"`
+	Type        string            `json:"type,omitempty"`
 	SenderID    string            `json:"senderId"`
 	SenderRole  string            `json:"senderRole"`
 	DisplayName string            `json:"displayName,omitempty"`
@@ -55,6 +92,8 @@ type Participant struct {
 // RoomSummary offers a lightweight view of a room for listing in the admin.
 type RoomSummary struct {
 	RoomID               string    `json:"roomId"`
+	Agency               string    `json:"agency,omitempty"`
+	OwnerID              string    `json:"ownerId,omitempty"`
 	CreatedAt            time.Time `json:"createdAt"`
 	LastActivity         time.Time `json:"lastActivity"`
 	PlayerCount          int       `json:"playerCount"`
@@ -64,14 +103,64 @@ type RoomSummary struct {
 	AssignedAgentID      string    `json:"assignedAgentId,omitempty"`
 	AssignedAgent        string    `json:"assignedAgent,omitempty"`
 	LastMessage          string    `json:"lastMessage,omitempty"`
+	Status               string    `json:"status"`
+	StatusChangedAt      time.Time `json:"statusChangedAt"`
+	PendingTransferTo    string    `json:"pendingTransferTo,omitempty"`
+	RequiredSkills       []string  `json:"requiredSkills,omitempty"`
+	// Priority orders the waiting queue; higher values are served first.
+	Priority int `json:"priority"`
+	// PriorityOverride is set once an admin chose the priority by hand; it is
+	// then no longer derived from the player.
+	PriorityOverride bool `json:"priorityOverride,omitempty"`
+	// WaitingSince is set while the room is open and waiting for an agent.
+	WaitingSince time.Time `json:"waitingSince,omitempty"`
+	// LeftMessageAt is set while a waiting room holds a message the player
+	// left when support was offline.
+	LeftMessageAt time.Time `json:"leftMessageAt,omitempty"`
+	// BotActive is set while the auto-reply bot answers the room; the room
+	// joins the waiting queue once the player is handed off.
+	BotActive bool `json:"botActive,omitempty"`
+	// PendingOutreach counts agent-initiated messages the player has not
+	// received yet because they were not connected.
+	PendingOutreach int `json:"pendingOutreach,omitempty"`
+}
+
+// AssignmentRecord is one entry in the assignment history of a room. An
+// empty AgentID means the room was returned to the waiting queue.
+type AssignmentRecord struct {
+	Action      string    `json:"action"`
+	AgentID     string    `json:"agentId,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	FromAgentID string    `json:"fromAgentId,omitempty"`
+	Note        string    `json:"note,omitempty"`
+	By          string    `json:"by,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// PendingTransfer is a handoff waiting to be accepted by the receiving agent.
+type PendingTransfer struct {
+	FromAgentID string    `json:"fromAgentId,omitempty"`
+	FromAgent   string    `json:"fromAgent,omitempty"`
+	ToAgentID   string    `json:"toAgentId"`
+	ToAgent     string    `json:"toAgent"`
+	Note        string    `json:"note,omitempty"`
+	RequestedBy string    `json:"requestedBy,omitempty"`
+	RequestedAt time.Time `json:"requestedAt"`
 }
 
 // RoomSnapshot represents the full state of a room, including the history.
 type RoomSnapshot struct {
-	Summary      RoomSummary   `json:"summary"`
-	Participants []Participant `json:"participants"`
-	History      []ChatMessage `json:"history"`
-	NextSequence int64         `json:"nextSequence"`
+	Summary         RoomSummary        `json:"summary"`
+	Participants    []Participant      `json:"participants"`
+	History         []ChatMessage      `json:"history"`
+	NextSequence    int64              `json:"nextSequence"`
+	Assignments     []AssignmentRecord `json:"assignments"`
+	PendingTransfer *PendingTransfer   `json:"pendingTransfer,omitempty"`
+}
+
+// IsInternal reports whether the message is hidden from players.
+func (m ChatMessage) IsInternal() bool {
+	return m.Type == MessageTypeInternal
 }
 
 // Normalize ensures the envelope uses the canonical command naming and keeps
@@ -89,7 +178,7 @@ func (e *Envelope) Normalize() {
 
 func normalizeLegacyType(value string) string {
 	switch value {
-	case "", MessageTypeChat, MessageTypeTyping, MessageTypeHistory, MessageTypeSystem:
+	case "", MessageTypeChat, MessageTypeTyping, MessageTypeHistory, MessageTypeSystem, MessageTypeInternal, MessageTypeTransfer, MessageTypeTransferAccept, MessageTypeBargeIn, MessageTypeSubscribe, MessageTypeUnsubscribe, MessageTypeAgentStatus, MessageTypeCannedSend:
 		return value
 	case "message":
 		return MessageTypeChat
@@ -99,6 +188,8 @@ func normalizeLegacyType(value string) string {
 		return MessageTypeHistory
 	case "system":
 		return MessageTypeSystem
+	case "internal", "whisper", "chat.whisper":
+		return MessageTypeInternal
 	default:
 		return value
 	}
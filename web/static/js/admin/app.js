@@ -9,6 +9,9 @@ const state = {
     token: null,
     onlineAgents: [],
     socket: null,
+    subscribedRooms: new Set(),
+    eventSocket: null,
+    eventRetryTimer: null,
     typingTimer: null,
     typingBubble: null,
     isSidebarCollapsed: false,
@@ -22,6 +25,8 @@ const dom = {
     sidebarAgentName: document.getElementById("sidebarAgentName"),
     sidebarAgentRole: document.getElementById("sidebarAgentRole"),
     connectionBadge: document.getElementById("connectionBadge"),
+    agentStatusSelect: document.getElementById("agentStatusSelect"),
+    cannedSelect: document.getElementById("cannedSelect"),
     refreshRooms: document.getElementById("refreshRooms"),
     roomList: document.getElementById("roomList"),
     roomTitle: document.getElementById("roomTitle"),
@@ -33,6 +38,8 @@ const dom = {
     messageInput: document.getElementById("messageInput"),
     sendButton: document.getElementById("sendMessage"),
     sendTyping: document.getElementById("sendTyping"),
+    scheduleAt: document.getElementById("scheduleAt"),
+    scheduleButton: document.getElementById("scheduleMessage"),
     agentName: document.getElementById("agentName"),
     assignAgent: document.getElementById("assignAgent"),
     waitingCount: document.getElementById("waitingCount"),
@@ -68,6 +75,13 @@ const dom = {
     playerPassword: document.getElementById("playerPassword"),
     playerDisplay: document.getElementById("playerDisplay"),
     playerAgency: document.getElementById("playerAgency"),
+    outreachForm: document.getElementById("outreachForm"),
+    outreachPlayer: document.getElementById("outreachPlayer"),
+    outreachContent: document.getElementById("outreachContent"),
+    announcementForm: document.getElementById("announcementForm"),
+    announcementContent: document.getElementById("announcementContent"),
+    announcementAgency: document.getElementById("announcementAgency"),
+    announcementMinutes: document.getElementById("announcementMinutes"),
     onlineAgentList: document.getElementById("onlineAgentList"),
     headerAvatar: document.getElementById("headerAvatar"),
     transferTarget: document.getElementById("transferTarget"),
@@ -81,6 +95,12 @@ const dom = {
     settingsWithdraw: document.getElementById("settingsWithdraw"),
     settingsBet: document.getElementById("settingsBet"),
     settingsPlayerInfo: document.getElementById("settingsPlayerInfo"),
+    settingsGreeting: document.getElementById("settingsGreeting"),
+    settingsAwayMessage: document.getElementById("settingsAwayMessage"),
+    settingsMenu: document.getElementById("settingsMenu"),
+    settingsBotRules: document.getElementById("settingsBotRules"),
+    settingsBotWebhook: document.getElementById("settingsBotWebhook"),
+    settingsBotToken: document.getElementById("settingsBotToken"),
     resetAgencySettings: document.getElementById("resetAgencySettings"),
     agencySettingsMessage: document.getElementById("agencySettingsMessage"),
 };
@@ -89,6 +109,20 @@ const REFRESH_ROOMS_INTERVAL = 30000;
 const ACTIVE_THRESHOLD_MS = 5 * 60 * 1000;
 const TYPING_TIMEOUT = 1500;
 const ONLINE_AGENTS_INTERVAL = 20000;
+const EVENT_STREAM_RETRY = 5000;
+const ROOM_STATUS_LABELS = {
+    open: "進行中",
+    pending: "等待回覆",
+    resolved: "已解決",
+    closed: "已關閉",
+};
+
+const AGENT_STATUS_LABELS = {
+    available: "可接待",
+    away: "暫離",
+    busy: "忙碌",
+    offline: "離線",
+};
 
 function parseDate(value) {
     if (!value) return null;
@@ -206,6 +240,141 @@ function resetAgencySettingsForm() {
     if (dom.settingsPlayerInfo) {
         dom.settingsPlayerInfo.value = "";
     }
+    fillAgencyGreetingForm(null);
+    fillAgencyBotForm(null);
+}
+
+function fillAgencyGreetingForm(greeting) {
+    if (dom.settingsGreeting) dom.settingsGreeting.value = greeting?.greeting || "";
+    if (dom.settingsAwayMessage) dom.settingsAwayMessage.value = greeting?.awayMessage || "";
+    if (dom.settingsMenu) {
+        const menu = Array.isArray(greeting?.menu) ? greeting.menu : [];
+        dom.settingsMenu.value = menu
+            .map((option) => [option.id, option.label, (option.skills || []).join(",")].join("|").replace(/\|$/, ""))
+            .join("\n");
+    }
+}
+
+function parsePreChatMenu(text) {
+    return (text || "")
+        .split("\n")
+        .map((line) => line.trim())
+        .filter(Boolean)
+        .map((line) => {
+            const [id, label, skills] = line.split("|").map((part) => (part || "").trim());
+            return {
+                id,
+                label: label || id,
+                skills: skills ? skills.split(",").map((skill) => skill.trim()).filter(Boolean) : [],
+            };
+        });
+}
+
+async function loadAgencyGreeting(agency) {
+    fillAgencyGreetingForm(null);
+    if (!agency) return;
+    try {
+        const response = await apiFetch(`/api/agencies/settings/${encodeURIComponent(agency)}/greeting`);
+        if (!response.ok) {
+            if (response.status === 401) {
+                handleUnauthorized();
+            }
+            return;
+        }
+        fillAgencyGreetingForm(await response.json());
+    } catch (error) {
+        console.error("load agency greeting failed", error);
+    }
+}
+
+async function saveAgencyGreeting(agency) {
+    const response = await apiFetch(`/api/agencies/settings/${encodeURIComponent(agency)}/greeting`, {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+            greeting: dom.settingsGreeting ? dom.settingsGreeting.value.trim() : "",
+            awayMessage: dom.settingsAwayMessage ? dom.settingsAwayMessage.value.trim() : "",
+            menu: parsePreChatMenu(dom.settingsMenu ? dom.settingsMenu.value : ""),
+        }),
+    });
+    if (!response.ok) {
+        const text = await response.text();
+        throw new Error(text || "儲存歡迎訊息失敗");
+    }
+    fillAgencyGreetingForm(await response.json());
+}
+
+function fillAgencyBotForm(bot) {
+    if (dom.settingsBotWebhook) dom.settingsBotWebhook.value = bot?.webhookUrl || "";
+    if (dom.settingsBotToken) {
+        // The token is only returned by the request that issued it.
+        dom.settingsBotToken.value = bot?.token || "";
+        dom.settingsBotToken.placeholder = bot?.hasToken ? "已設定（僅於產生時顯示）" : "設定 Webhook 後自動產生";
+    }
+    if (!dom.settingsBotRules) return;
+    const rules = Array.isArray(bot?.rules) ? bot.rules : [];
+    dom.settingsBotRules.value = rules
+        .map((rule) => [rule.pattern ? `/${rule.pattern}/` : (rule.keywords || []).join(","), rule.reply].join("|"))
+        .join("\n");
+}
+
+function parseBotRules(text) {
+    return (text || "")
+        .split("\n")
+        .map((line) => line.trim())
+        .filter(Boolean)
+        .map((line) => {
+            const index = line.indexOf("|");
+            const match = (index >= 0 ? line.slice(0, index) : line).trim();
+            const reply = index >= 0 ? line.slice(index + 1).trim() : "";
+            if (match.length > 2 && match.startsWith("/") && match.endsWith("/")) {
+                return { pattern: match.slice(1, -1), reply };
+            }
+            return { keywords: match.split(",").map((keyword) => keyword.trim()).filter(Boolean), reply };
+        });
+}
+
+async function loadAgencyBot(agency) {
+    fillAgencyBotForm(null);
+    if (!agency) return;
+    try {
+        const response = await apiFetch(`/api/agencies/settings/${encodeURIComponent(agency)}/bot-rules`);
+        if (!response.ok) {
+            if (response.status === 401) {
+                handleUnauthorized();
+            }
+            return;
+        }
+        fillAgencyBotForm(await response.json());
+    } catch (error) {
+        console.error("load agency bot rules failed", error);
+    }
+}
+
+async function saveAgencyBot(agency) {
+    const response = await apiFetch(`/api/agencies/settings/${encodeURIComponent(agency)}/bot-rules`, {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+            rules: parseBotRules(dom.settingsBotRules ? dom.settingsBotRules.value : ""),
+        }),
+    });
+    if (!response.ok) {
+        const text = await response.text();
+        throw new Error(text || "儲存自動回覆規則失敗");
+    }
+    const webhook = await apiFetch(`/api/agencies/settings/${encodeURIComponent(agency)}/bot-webhook`, {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+            webhookUrl: dom.settingsBotWebhook ? dom.settingsBotWebhook.value.trim() : "",
+        }),
+    });
+    if (!webhook.ok) {
+        const text = await webhook.text();
+        throw new Error(text || "儲存外部機器人設定失敗");
+    }
+    fillAgencyBotForm(await webhook.json());
 }
 
 function updateAgencySettingsOptions() {
@@ -227,6 +396,8 @@ function fillAgencySettingsForm(agency) {
     if (dom.settingsWithdraw) dom.settingsWithdraw.value = target?.withdrawApi || "";
     if (dom.settingsBet) dom.settingsBet.value = target?.betApi || "";
     if (dom.settingsPlayerInfo) dom.settingsPlayerInfo.value = target?.playerInfoApi || "";
+    loadAgencyGreeting(target ? normalized : "");
+    loadAgencyBot(target ? normalized : "");
     if (target && target.updatedAt) {
         const updatedTime = new Date(target.updatedAt);
         const label = Number.isNaN(updatedTime.getTime()) ? "" : updatedTime.toLocaleString("zh-TW");
@@ -297,6 +468,13 @@ async function saveAgencySettings(event) {
             return;
         }
         const data = await response.json();
+        try {
+            await saveAgencyGreeting(data.agency);
+            await saveAgencyBot(data.agency);
+        } catch (error) {
+            displayAgencySettingsMessage(error.message, "error");
+            return;
+        }
         const existingIndex = state.agencySettings.findIndex((item) => item.agency === data.agency);
         if (existingIndex >= 0) {
             state.agencySettings[existingIndex] = data;
@@ -350,6 +528,7 @@ function stopAutoRefresh() {
 
 function clearSession({ keepOverlay = false, message = "" } = {}) {
     stopAutoRefresh();
+    closeEventStream();
     closeSocket();
     state.account = null;
     state.token = null;
@@ -372,6 +551,10 @@ function clearSession({ keepOverlay = false, message = "" } = {}) {
     dom.agentName.value = "";
     dom.agentName.disabled = true;
     dom.assignAgent.disabled = true;
+    if (dom.agentStatusSelect) {
+        dom.agentStatusSelect.value = "available";
+        dom.agentStatusSelect.disabled = true;
+    }
     if (dom.transferTarget) {
         dom.transferTarget.disabled = true;
         dom.transferTarget.innerHTML = "<option value=\"\">轉接至在線客服</option>";
@@ -451,8 +634,78 @@ function startAutoRefresh() {
 
 async function onLogin(account, token) {
     applySession(account, token);
-    await Promise.all([loadRooms(), loadOnlineAgents()]);
+    await Promise.all([loadRooms(), loadOnlineAgents(), loadAgentStatus(), loadCannedResponses()]);
     startAutoRefresh();
+    connectEventStream();
+}
+
+function connectEventStream() {
+    if (!state.token || state.eventSocket) {
+        return;
+    }
+    clearTimeout(state.eventRetryTimer);
+    const protocol = window.location.protocol === "https:" ? "wss" : "ws";
+    const params = new URLSearchParams({ token: state.token });
+    const socket = new WebSocket(`${protocol}://${window.location.host}/ws/admin?${params.toString()}`);
+    state.eventSocket = socket;
+
+    socket.addEventListener("open", () => {
+        // Live events replace polling while the stream is up.
+        stopAutoRefresh();
+        loadRooms({ silent: true });
+        loadOnlineAgents();
+    });
+
+    socket.addEventListener("message", (event) => {
+        try {
+            handleHubEvent(JSON.parse(event.data));
+        } catch (error) {
+            console.error("invalid event", error);
+        }
+    });
+
+    socket.addEventListener("close", () => {
+        if (state.eventSocket !== socket) {
+            return;
+        }
+        state.eventSocket = null;
+        if (state.token) {
+            startAutoRefresh();
+            state.eventRetryTimer = setTimeout(connectEventStream, EVENT_STREAM_RETRY);
+        }
+    });
+}
+
+function closeEventStream() {
+    clearTimeout(state.eventRetryTimer);
+    if (state.eventSocket) {
+        const socket = state.eventSocket;
+        state.eventSocket = null;
+        socket.close();
+    }
+}
+
+function handleHubEvent(event) {
+    switch (event.type) {
+        case "room.created":
+        case "room.updated":
+        case "room.closed":
+        case "room.assigned":
+        case "message.created":
+            if (event.room) {
+                updateRoomSummary(event.room);
+                if (event.room.roomId === state.currentRoomId) {
+                    dom.roomMeta.textContent = buildRoomMeta(event.room);
+                }
+            }
+            break;
+        case "agent.presence":
+        case "agent.status":
+            loadOnlineAgents();
+            break;
+        default:
+            break;
+    }
 }
 
 async function registerAccount(role, username, password, displayName, agency = "") {
@@ -599,6 +852,19 @@ async function loadOnlineAgents() {
 function syncRooms() {
     state.rooms = Array.from(state.roomsMap.values());
     state.rooms.sort((a, b) => {
+        // Rooms still waiting for an agent come first, highest priority and
+        // longest wait at the top.
+        const aWaiting = Boolean(a.waitingSince);
+        const bWaiting = Boolean(b.waitingSince);
+        if (aWaiting !== bWaiting) {
+            return aWaiting ? -1 : 1;
+        }
+        if (aWaiting) {
+            if ((b.priority || 0) !== (a.priority || 0)) {
+                return (b.priority || 0) - (a.priority || 0);
+            }
+            return (parseDate(a.waitingSince)?.getTime() ?? 0) - (parseDate(b.waitingSince)?.getTime() ?? 0);
+        }
         const aTime = parseDate(a.lastActivity)?.getTime() ?? 0;
         const bTime = parseDate(b.lastActivity)?.getTime() ?? 0;
         return bTime - aTime;
@@ -630,7 +896,7 @@ function renderOnlineAgents() {
         dom.transferTarget.appendChild(placeholder);
 
         state.onlineAgents
-            .filter((agent) => agent.id !== state.agentId)
+            .filter((agent) => agent.id !== state.agentId && (!agent.status || agent.status === "available"))
             .forEach((agent) => {
                 const option = document.createElement("option");
                 option.value = agent.id;
@@ -656,7 +922,8 @@ function renderOnlineAgents() {
         } else {
             state.onlineAgents.forEach((agent) => {
                 const item = document.createElement("li");
-                item.innerHTML = `<strong>${agent.displayName}</strong><span>${(agent.rooms && agent.rooms.length) ? `房間 ${agent.rooms.join(", ")}` : "待命中"} · ${formatRelative(agent.lastSeen)}</span>`;
+                const statusLabel = AGENT_STATUS_LABELS[agent.status] || AGENT_STATUS_LABELS.available;
+                item.innerHTML = `<strong>${agent.displayName}</strong><span>${statusLabel} · ${(agent.rooms && agent.rooms.length) ? `房間 ${agent.rooms.join(", ")}` : "待命中"} · ${formatRelative(agent.lastSeen)}</span>`;
                 dom.onlineAgentList.appendChild(item);
             });
         }
@@ -681,7 +948,7 @@ function renderRoomList() {
 
         const title = document.createElement("div");
         title.className = "title";
-        title.innerHTML = `<span>#${room.roomId}</span>`;
+        title.innerHTML = `<span>#${room.roomId}</span>${room.priority > 0 ? ` <span class="badge badge-online">VIP ${room.priority}</span>` : ""}`;
         const badge = document.createElement("span");
         badge.className = "badge";
         const playerOnline = room.connectedPlayerCount ?? room.playerCount ?? 0;
@@ -692,7 +959,8 @@ function renderRoomList() {
         const meta = document.createElement("div");
         meta.className = "meta";
         const lastMessage = room.lastMessage ? room.lastMessage : "尚無訊息";
-        meta.textContent = `${lastMessage.slice(0, 32)} · ${formatRelative(room.lastActivity)}`;
+        const statusLabel = ROOM_STATUS_LABELS[room.status] || "";
+        meta.textContent = `${statusLabel ? `${statusLabel} · ` : ""}${lastMessage.slice(0, 32)} · ${formatRelative(room.lastActivity)}`;
 
         const ping = document.createElement("div");
         ping.className = "ping";
@@ -749,6 +1017,16 @@ function buildRoomMeta(summary) {
     ];
     if (summary.assignedAgent) {
         metaParts.push(`指派客服：${summary.assignedAgent}`);
+    } else if (summary.botActive) {
+        metaParts.push("機器人接待中");
+    } else if (summary.waitingSince) {
+        metaParts.push(`等候中：${formatRelative(summary.waitingSince)}`);
+    }
+    if (summary.priority > 0) {
+        metaParts.push(`優先等級：${summary.priority}`);
+    }
+    if (summary.leftMessageAt) {
+        metaParts.push(`離線留言：${formatRelative(summary.leftMessageAt)}`);
     }
     return metaParts.join(" ｜ ");
 }
@@ -764,7 +1042,7 @@ async function selectRoom(roomId) {
     state.currentRoomId = roomId;
     renderRoomList();
     await loadRoomSnapshot(roomId);
-    connectSocket(roomId);
+    subscribeRoom(roomId);
 }
 
 async function loadRoomSnapshot(roomId) {
@@ -862,6 +1140,13 @@ function appendMessage(message, options = {}) {
 function enableComposer(enabled) {
     dom.messageInput.disabled = !enabled;
     dom.sendButton.disabled = !enabled;
+    if (dom.cannedSelect) {
+        dom.cannedSelect.disabled = !enabled;
+    }
+    if (dom.scheduleButton) {
+        dom.scheduleAt.disabled = !enabled;
+        dom.scheduleButton.disabled = !enabled;
+    }
     if (enabled) {
         dom.messageInput.focus();
     }
@@ -871,23 +1156,27 @@ function scrollTimelineToBottom() {
     dom.messageStream.scrollTo({ top: dom.messageStream.scrollHeight, behavior: "smooth" });
 }
 
-function connectSocket(roomId) {
+function connectSocket() {
     if (!state.agentId) {
         return;
     }
-    closeSocket();
+    if (state.socket && state.socket.readyState <= WebSocket.OPEN) {
+        return;
+    }
     const protocol = window.location.protocol === "https:" ? "wss" : "ws";
     const params = new URLSearchParams({
-        roomId,
+        mode: "multi",
         role: "agent",
         id: state.agentId,
         name: state.agentDisplayName,
+        token: state.token,
     });
     const socket = new WebSocket(`${protocol}://${window.location.host}/ws?${params.toString()}`);
     state.socket = socket;
 
     socket.addEventListener("open", () => {
         setConnectionBadge(true);
+        state.subscribedRooms.forEach((roomId) => sendSubscription("room.subscribe", roomId));
         if (dom.messageInput.value.trim() !== "") {
             dom.sendButton.disabled = false;
         }
@@ -904,6 +1193,9 @@ function connectSocket(roomId) {
 
     socket.addEventListener("close", () => {
         setConnectionBadge(false);
+        if (state.socket === socket) {
+            state.socket = null;
+        }
     });
 
     socket.addEventListener("error", () => {
@@ -912,12 +1204,82 @@ function connectSocket(roomId) {
 }
 
 function closeSocket() {
+    state.subscribedRooms.clear();
     if (state.socket) {
         state.socket.close();
         state.socket = null;
     }
 }
 
+function subscribeRoom(roomId) {
+    if (!roomId) return;
+    const isNew = !state.subscribedRooms.has(roomId);
+    state.subscribedRooms.add(roomId);
+    if (!state.socket) {
+        connectSocket();
+        return;
+    }
+    if (isNew) {
+        sendSubscription("room.subscribe", roomId);
+    }
+}
+
+function sendSubscription(cmd, roomId) {
+    if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    state.socket.send(JSON.stringify({ cmd, type: cmd, roomId }));
+}
+
+function setAgentStatusSelect(status) {
+    if (!dom.agentStatusSelect || !status) {
+        return;
+    }
+    dom.agentStatusSelect.value = status;
+    dom.agentStatusSelect.disabled = !state.agentId;
+}
+
+async function loadAgentStatus() {
+    if (!state.agentId) {
+        return;
+    }
+    try {
+        const response = await apiFetch(`/api/agents/${encodeURIComponent(state.agentId)}/status`);
+        if (!response.ok) {
+            return;
+        }
+        const data = await response.json();
+        setAgentStatusSelect(data.status);
+    } catch (error) {
+        console.error("loadAgentStatus failed", error);
+    }
+}
+
+async function updateAgentStatus(status) {
+    if (!state.agentId) {
+        return;
+    }
+    try {
+        const response = await apiFetch(`/api/agents/${encodeURIComponent(state.agentId)}/status`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ status }),
+        });
+        if (!response.ok) {
+            if (response.status === 401) {
+                handleUnauthorized();
+                return;
+            }
+            await loadAgentStatus();
+            return;
+        }
+        const data = await response.json();
+        setAgentStatusSelect(data.status);
+    } catch (error) {
+        console.error("updateAgentStatus failed", error);
+    }
+}
+
 function setConnectionBadge(connected) {
     const badge = dom.connectionBadge;
     badge.textContent = connected ? "已連線" : "未連線";
@@ -927,6 +1289,16 @@ function setConnectionBadge(connected) {
 
 function handleIncoming(message) {
     const cmd = getCmd(message);
+    if (cmd === "agent.status") {
+        setAgentStatusSelect(message.metadata && message.metadata.status);
+        return;
+    }
+    if (message.roomId && message.roomId !== state.currentRoomId) {
+        if (cmd === "chat.message") {
+            updateRoomSummaryFromMessage(message);
+        }
+        return;
+    }
     switch (cmd) {
         case "chat.history": {
             const history = message.history || (message.payload && message.payload.messages) || [];
@@ -946,6 +1318,11 @@ function handleIncoming(message) {
             break;
         }
         case "system.notice": {
+            if (message.metadata && message.metadata.offlineBacklog) {
+                loadRooms({ silent: true });
+                window.alert(message.content);
+                break;
+            }
             appendMessage(message);
             if (message.metadata && message.metadata.assignedAgent) {
                 const summary = state.roomsMap.get(message.roomId);
@@ -1071,14 +1448,14 @@ function renderDrawerMetrics(waiting, active, agents, aggregate = agents) {
     });
 }
 
-async function assignRoomTo(agentId, displayName) {
+async function assignRoomTo(agentId, displayName, { force = false } = {}) {
     if (!state.currentRoomId || !agentId) return;
     if (!state.token) {
         showAuthOverlay();
         return;
     }
 
-    const payload = { agentId, displayName };
+    const payload = { agentId, displayName, force };
 
     try {
         const response = await apiFetch(`/api/rooms/${encodeURIComponent(state.currentRoomId)}/assign`, {
@@ -1092,6 +1469,12 @@ async function assignRoomTo(agentId, displayName) {
                 return;
             }
             const errorText = await response.text();
+            if (response.status === 409 && !force && /capacity|skill/.test(errorText)) {
+                if (window.confirm(`客服超出負荷或缺少所需技能（${errorText.trim()}），仍要指派嗎？`)) {
+                    await assignRoomTo(agentId, displayName, { force: true });
+                }
+                return;
+            }
             displayAccountMessage(errorText || "指派失敗", "error");
             return;
         }
@@ -1106,7 +1489,11 @@ async function assignRoomTo(agentId, displayName) {
             updateMetrics();
             dom.roomMeta.textContent = buildRoomMeta(summary);
         }
-        displayAccountMessage(`已指派給 ${participant.displayName}`, "success");
+        if (participant.warnings && participant.warnings.length) {
+            displayAccountMessage(`已指派給 ${participant.displayName}（注意：${participant.warnings.join("；")}）`, "info");
+        } else {
+            displayAccountMessage(`已指派給 ${participant.displayName}`, "success");
+        }
         loadOnlineAgents();
     } catch (error) {
         console.error("assign agent failed", error);
@@ -1133,11 +1520,63 @@ function sendMessage(content) {
     const payload = {
         cmd: "chat.message",
         type: "chat.message",
+        roomId: state.currentRoomId,
         content,
     };
     state.socket.send(JSON.stringify(payload));
 }
 
+function sendCannedResponse(cannedId) {
+    if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    const payload = {
+        cmd: "canned.send",
+        type: "canned.send",
+        roomId: state.currentRoomId,
+        metadata: { cannedId: String(cannedId) },
+    };
+    state.socket.send(JSON.stringify(payload));
+}
+
+async function loadCannedResponses() {
+    if (!dom.cannedSelect) {
+        return;
+    }
+    try {
+        const response = await apiFetch("/api/canned-responses");
+        if (!response.ok) {
+            return;
+        }
+        const items = await response.json();
+        const groups = new Map();
+        (Array.isArray(items) ? items : []).forEach((item) => {
+            const category = item.category || (item.ownerId ? "個人" : "共用");
+            if (!groups.has(category)) groups.set(category, []);
+            groups.get(category).push(item);
+        });
+        dom.cannedSelect.innerHTML = "";
+        const placeholder = document.createElement("option");
+        placeholder.value = "";
+        placeholder.textContent = "快捷回覆";
+        dom.cannedSelect.appendChild(placeholder);
+        groups.forEach((list, category) => {
+            const group = document.createElement("optgroup");
+            group.label = category;
+            list.forEach((item) => {
+                const option = document.createElement("option");
+                option.value = item.id;
+                option.textContent = item.title ? `${item.shortcut} ${item.title}` : item.shortcut;
+                option.title = item.content;
+                group.appendChild(option);
+            });
+            dom.cannedSelect.appendChild(group);
+        });
+    } catch (error) {
+        console.error("loadCannedResponses failed", error);
+    }
+}
+
 function sendTypingSignal() {
     if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
         return;
@@ -1145,6 +1584,7 @@ function sendTypingSignal() {
     const payload = {
         cmd: "chat.typing",
         type: "chat.typing",
+        roomId: state.currentRoomId,
         metadata: { status: "typing" },
     };
     state.socket.send(JSON.stringify(payload));
@@ -1155,6 +1595,11 @@ function registerEventListeners() {
         loadRooms();
         loadOnlineAgents();
     });
+    if (dom.agentStatusSelect) {
+        dom.agentStatusSelect.addEventListener("change", (event) => {
+            updateAgentStatus(event.target.value);
+        });
+    }
     dom.roomSearch.addEventListener("input", (event) => {
         state.searchKeyword = event.target.value.trim();
         applyFilter();
@@ -1213,6 +1658,16 @@ function registerEventListeners() {
         });
     }
 
+    if (dom.cannedSelect) {
+        dom.cannedSelect.addEventListener("change", () => {
+            const cannedId = dom.cannedSelect.value;
+            dom.cannedSelect.value = "";
+            if (cannedId) {
+                sendCannedResponse(cannedId);
+            }
+        });
+    }
+
     dom.messageForm.addEventListener("submit", (event) => {
         event.preventDefault();
         const content = dom.messageInput.value.trim();
@@ -1241,6 +1696,9 @@ function registerEventListeners() {
     });
 
     dom.sendTyping.addEventListener("click", () => sendTypingSignal());
+    if (dom.scheduleButton) {
+        dom.scheduleButton.addEventListener("click", scheduleMessage);
+    }
 
     window.addEventListener("beforeunload", () => closeSocket());
 
@@ -1321,6 +1779,12 @@ function registerEventListeners() {
             }
         });
     }
+    if (dom.outreachForm) {
+        dom.outreachForm.addEventListener("submit", startOutreach);
+    }
+    if (dom.announcementForm) {
+        dom.announcementForm.addEventListener("submit", publishAnnouncement);
+    }
     if (dom.agencySettingsForm) {
         dom.agencySettingsForm.addEventListener("submit", saveAgencySettings);
     }
@@ -1343,6 +1807,104 @@ function registerEventListeners() {
     }
 }
 
+// startOutreach opens a conversation with a player; the opening message is
+// delivered when the player next connects.
+async function startOutreach(event) {
+    event.preventDefault();
+    const playerId = dom.outreachPlayer.value.trim().toLowerCase();
+    const content = dom.outreachContent.value.trim();
+    if (!playerId || !content) {
+        displayAccountMessage("請填寫玩家帳號與開場訊息", "error");
+        return;
+    }
+    try {
+        const response = await apiFetch("/api/outreach", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ playerId, content }),
+        });
+        if (!response.ok) {
+            const text = await response.text();
+            displayAccountMessage(text || "發起對話失敗", "error");
+            return;
+        }
+        const result = await response.json();
+        dom.outreachPlayer.value = "";
+        dom.outreachContent.value = "";
+        displayAccountMessage(`已向玩家 ${playerId} 發起對話`, "success");
+        selectRoom(result.room.roomId);
+    } catch (error) {
+        console.error("outreach failed", error);
+        displayAccountMessage("發起對話失敗，請稍後再試", "error");
+    }
+}
+
+// publishAnnouncement pushes a notice to the connected players of an agency;
+// a retention period also shows it to players who connect later.
+async function publishAnnouncement(event) {
+    event.preventDefault();
+    const content = dom.announcementContent.value.trim();
+    const agency = dom.announcementAgency.value.trim();
+    const minutes = Number.parseInt(dom.announcementMinutes.value, 10) || 0;
+    if (!content) {
+        displayAccountMessage("請填寫公告內容", "error");
+        return;
+    }
+    try {
+        const response = await apiFetch("/api/announcements", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ content, agency, ttlSeconds: Math.max(minutes, 0) * 60 }),
+        });
+        if (!response.ok) {
+            const text = await response.text();
+            displayAccountMessage(text || "發布公告失敗", "error");
+            return;
+        }
+        const result = await response.json();
+        dom.announcementContent.value = "";
+        displayAccountMessage(`公告已推送給 ${result.delivered} 位在線玩家`, "success");
+    } catch (error) {
+        console.error("announcement failed", error);
+        displayAccountMessage("發布公告失敗，請稍後再試", "error");
+    }
+}
+
+// scheduleMessage stores the composed message to be sent into the current room
+// at the chosen time, even if nobody is online then.
+async function scheduleMessage() {
+    if (!state.currentRoomId) return;
+    const content = dom.messageInput.value.trim();
+    const sendAt = dom.scheduleAt.value ? new Date(dom.scheduleAt.value) : null;
+    if (!content || !sendAt || Number.isNaN(sendAt.getTime())) {
+        window.alert("請輸入訊息內容並選擇排程時間");
+        return;
+    }
+    try {
+        const response = await apiFetch(`/api/rooms/${encodeURIComponent(state.currentRoomId)}/scheduled-messages`, {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ content, displayName: state.agentDisplayName, sendAt: sendAt.toISOString() }),
+        });
+        if (!response.ok) {
+            if (response.status === 401) {
+                handleUnauthorized();
+                return;
+            }
+            const text = await response.text();
+            window.alert(text || "排程失敗");
+            return;
+        }
+        dom.messageInput.value = "";
+        dom.scheduleAt.value = "";
+        autoGrowTextarea();
+        window.alert(`訊息將於 ${sendAt.toLocaleString()} 傳送`);
+    } catch (error) {
+        console.error("schedule message failed", error);
+        window.alert("排程失敗，請稍後再試");
+    }
+}
+
 function autoGrowTextarea() {
     dom.messageInput.style.height = "auto";
     dom.messageInput.style.height = `${dom.messageInput.scrollHeight}px`;
@@ -9,9 +9,11 @@ const state = {
     typingTimer: null,
     typingBubble: null,
     suppressDisconnectNotice: false,
+    useFallback: false,
     timeline: [],
     timelineLastDay: null,
     connected: false,
+    seenAnnouncements: new Set(),
 };
 
 const dom = {
@@ -54,6 +56,7 @@ const PLAYER_TOKEN_KEY = "imPlayerToken";
 const DEFAULT_CHAT_TITLE = "客服連線準備中";
 const DEFAULT_CHAT_SUBTITLE = "點擊左側開始對話，系統將建立房間並等待客服加入。";
 const WAITING_ASSIGNMENT_SUBTITLE = "客服正在安排中，您可先留言";
+const OFFLINE_SUBTITLE = "客服目前不在線上，請留言，我們會盡快回覆";
 
 function apiFetch(url, options = {}) {
     const init = { ...options };
@@ -286,6 +289,26 @@ async function restoreSession() {
 async function onPlayerLogin(account, token) {
     applySession(account, token);
     setAssignedAgent(null);
+    await showPendingMessages(account);
+}
+
+// showPendingMessages tells the player about messages an agent sent while they
+// were away; they are delivered once the conversation is opened.
+async function showPendingMessages(account) {
+    let pending = account.pendingMessages;
+    if (pending === undefined) {
+        try {
+            const response = await apiFetch("/api/auth/profile");
+            if (!response.ok) return;
+            pending = (await response.json()).pendingMessages;
+        } catch (error) {
+            console.warn("load profile failed", error);
+            return;
+        }
+    }
+    if (pending > 0) {
+        dom.chatSubtitle.textContent = `客服傳來 ${pending} 則新訊息，點擊開始對話查看`;
+    }
 }
 
 function resetTimeline(placeholder = "尚未建立對話") {
@@ -335,18 +358,22 @@ function getCmd(message) {
     return message.cmd || message.type || "";
 }
 
-function ensureRoomId() {
-    const url = new URL(window.location.href);
-    let room = url.searchParams.get("room");
-    if (!room) {
-        room = `room-${Date.now()}`;
-        url.searchParams.set("room", room);
-        window.history.replaceState({}, "", url.toString());
+// requestRoom asks the server for the player's active room, which is created
+// on demand with a server-generated ID.
+async function requestRoom() {
+    const response = await apiFetch("/api/rooms", { method: "POST" });
+    if (!response.ok) {
+        const text = await response.text();
+        throw new Error(text || "建立對話失敗");
     }
-    return room;
+    const room = await response.json();
+    const url = new URL(window.location.href);
+    url.searchParams.set("room", room.roomId);
+    window.history.replaceState({}, "", url.toString());
+    return room.roomId;
 }
 
-function connect() {
+async function connect() {
     if (!state.account || !state.token) {
         if (dom.loginError) {
             dom.loginError.textContent = "請先登入後再開始對話";
@@ -357,7 +384,13 @@ function connect() {
 
     state.displayName = dom.playerName.value.trim() || state.displayName || state.account.displayName || state.account.username;
     state.playerId = state.account.username;
-    state.roomId = ensureRoomId();
+    try {
+        state.roomId = await requestRoom();
+    } catch (error) {
+        console.error("create room failed", error);
+        dom.chatSubtitle.textContent = "建立對話失敗，請稍後再試";
+        return;
+    }
     dom.roomIndicator.textContent = state.roomId;
     dom.chatTitle.textContent = `與客服的對話 #${state.roomId}`;
     dom.chatSubtitle.textContent = DEFAULT_CHAT_SUBTITLE;
@@ -365,51 +398,151 @@ function connect() {
 
     closeSocket({ silent: true });
 
-    const protocol = window.location.protocol === "https:" ? "wss" : "ws";
     const params = new URLSearchParams({
         roomId: state.roomId,
         role: "player",
         id: state.playerId,
         name: state.displayName,
+        token: state.token,
     });
+    openTransport(params);
+}
+
+// openTransport prefers a WebSocket and falls back to Server-Sent Events plus
+// POST when the upgrade is blocked, e.g. by a corporate proxy.
+function openTransport(params) {
+    const handlers = {
+        onOpen: handleTransportOpen,
+        onMessage: handleTransportMessage,
+        onClose: handleTransportClose,
+        onError: handleTransportError,
+    };
+
+    if (state.useFallback || typeof WebSocket === "undefined") {
+        state.socket = openEventSourceTransport(params, handlers);
+        return;
+    }
+
+    state.socket = openWebSocketTransport(params, {
+        ...handlers,
+        onError: (opened) => {
+            if (opened) {
+                handleTransportError();
+                return;
+            }
+            state.useFallback = true;
+            state.socket = openEventSourceTransport(params, handlers);
+        },
+    });
+}
+
+function openWebSocketTransport(params, handlers) {
+    const protocol = window.location.protocol === "https:" ? "wss" : "ws";
     const socket = new WebSocket(`${protocol}://${window.location.host}/ws?${params.toString()}`);
-    state.socket = socket;
+    let opened = false;
 
     socket.addEventListener("open", () => {
-        if (dom.messageInput) {
-            dom.messageInput.value = "";
-            autoGrowTextarea();
+        opened = true;
+        handlers.onOpen();
+    });
+    socket.addEventListener("message", (event) => handlers.onMessage(event.data));
+    socket.addEventListener("close", () => {
+        if (opened) {
+            handlers.onClose();
         }
-        updateConnection(true);
-        setComposerEnabled(true);
-        appendSystem("已連線，客服稍候即將加入");
-        requestHistorySync();
     });
+    socket.addEventListener("error", () => handlers.onError(opened));
+
+    return {
+        isOpen: () => socket.readyState === WebSocket.OPEN,
+        send: (data) => socket.send(data),
+        close: () => socket.close(),
+    };
+}
 
-    socket.addEventListener("message", (event) => {
+function openEventSourceTransport(params, handlers) {
+    const source = new EventSource(`/ws/sse?${params.toString()}`);
+    let sessionId = null;
+    let closed = false;
+
+    const close = () => {
+        if (closed) {
+            return;
+        }
+        closed = true;
+        source.close();
+        handlers.onClose();
+    };
+
+    source.addEventListener("session", (event) => {
         try {
-            const message = JSON.parse(event.data);
-            handleIncoming(message);
+            sessionId = JSON.parse(event.data).sessionId;
         } catch (error) {
-            console.error("invalid message", error);
+            console.error("invalid session", error);
+            return;
         }
+        handlers.onOpen();
     });
-
-    socket.addEventListener("close", () => {
-        updateConnection(false);
-        setComposerEnabled(false);
-        if (state.suppressDisconnectNotice) {
-            state.suppressDisconnectNotice = false;
+    source.addEventListener("message", (event) => handlers.onMessage(event.data));
+    source.addEventListener("error", () => {
+        // The server ends the session with the stream, so do not let
+        // EventSource reconnect into a new session silently.
+        if (sessionId) {
+            close();
             return;
         }
-        appendSystem("連線已關閉，您可重新點擊開始對話");
+        closed = true;
+        source.close();
+        handlers.onError(false);
     });
 
-    socket.addEventListener("error", () => {
-        updateConnection(false);
-        setComposerEnabled(false);
-        appendSystem("連線發生異常，請稍後再試");
-    });
+    return {
+        isOpen: () => !closed && sessionId !== null,
+        send: (data) => {
+            fetch(`/ws/send?session=${encodeURIComponent(sessionId)}`, {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: data,
+            }).catch((error) => console.error("send failed", error));
+        },
+        close,
+    };
+}
+
+function handleTransportOpen() {
+    if (dom.messageInput) {
+        dom.messageInput.value = "";
+        autoGrowTextarea();
+    }
+    updateConnection(true);
+    setComposerEnabled(true);
+    appendSystem("已連線，客服稍候即將加入");
+    requestHistorySync();
+}
+
+function handleTransportMessage(data) {
+    try {
+        const message = JSON.parse(data);
+        handleIncoming(message);
+    } catch (error) {
+        console.error("invalid message", error);
+    }
+}
+
+function handleTransportClose() {
+    updateConnection(false);
+    setComposerEnabled(false);
+    if (state.suppressDisconnectNotice) {
+        state.suppressDisconnectNotice = false;
+        return;
+    }
+    appendSystem("連線已關閉，您可重新點擊開始對話");
+}
+
+function handleTransportError() {
+    updateConnection(false);
+    setComposerEnabled(false);
+    appendSystem("連線發生異常，請稍後再試");
 }
 
 function closeSocket({ silent = false } = {}) {
@@ -422,6 +555,10 @@ function closeSocket({ silent = false } = {}) {
     }
 }
 
+function isSocketOpen() {
+    return Boolean(state.socket && state.socket.isOpen());
+}
+
 function updateConnection(connected) {
     state.connected = connected;
     dom.connectionState.textContent = connected ? "已連線" : "未連線";
@@ -458,12 +595,27 @@ function handleIncoming(message) {
             break;
         case "chat.message":
             appendMessage(message);
+            if (message.payload && message.payload.actions) {
+                appendBotActions(message.payload.actions);
+            }
             break;
         case "system.notice":
             if (message.metadata && message.metadata.assignedAgent) {
                 setAssignedAgent(message.metadata.assignedAgent);
+            } else if (message.metadata && message.metadata.announcement) {
+                // Announcements are resent on every connection until they expire.
+                if (!state.seenAnnouncements.has(message.metadata.announcement)) {
+                    state.seenAnnouncements.add(message.metadata.announcement);
+                    appendSystem(`【公告】${message.content}`);
+                }
             } else {
+                if (message.metadata && message.metadata.offline && !state.assignedAgent) {
+                    dom.chatSubtitle.textContent = OFFLINE_SUBTITLE;
+                }
                 appendSystem(message.content);
+                if (message.payload && message.payload.menu) {
+                    appendPreChatMenu(message.payload.menu);
+                }
             }
             break;
         default:
@@ -594,8 +746,8 @@ function showTyping(message) {
     }, TYPING_TIMEOUT);
 }
 
-function sendMessage(content) {
-    if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
+function sendMessage(content, metadata) {
+    if (!isSocketOpen()) {
         return;
     }
     state.socket.send(
@@ -603,12 +755,51 @@ function sendMessage(content) {
             cmd: "chat.message",
             type: "chat.message",
             content,
+            metadata,
         })
     );
 }
 
+function appendPreChatMenu(options) {
+    if (!dom.messageTimeline || !Array.isArray(options) || options.length === 0) return;
+    const menu = document.createElement("div");
+    menu.className = "wk-menu";
+    options.forEach((option) => {
+        const button = document.createElement("button");
+        button.type = "button";
+        button.className = "wk-menu-option";
+        button.textContent = option.label;
+        button.addEventListener("click", () => {
+            sendMessage(option.label, { menuOption: option.id });
+            menu.remove();
+        });
+        menu.appendChild(button);
+    });
+    dom.messageTimeline.appendChild(menu);
+    scrollToBottom();
+}
+
+function appendBotActions(actions) {
+    if (!dom.messageTimeline || !Array.isArray(actions) || actions.length === 0) return;
+    const menu = document.createElement("div");
+    menu.className = "wk-menu";
+    actions.forEach((action) => {
+        const button = document.createElement("button");
+        button.type = "button";
+        button.className = "wk-menu-option";
+        button.textContent = action.label;
+        button.addEventListener("click", () => {
+            sendMessage(action.label, { handoff: action.id });
+            menu.remove();
+        });
+        menu.appendChild(button);
+    });
+    dom.messageTimeline.appendChild(menu);
+    scrollToBottom();
+}
+
 function sendTyping() {
-    if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
+    if (!isSocketOpen()) {
         return;
     }
     state.socket.send(
@@ -621,7 +812,7 @@ function sendTyping() {
 }
 
 function requestHistorySync() {
-    if (!state.socket || state.socket.readyState !== WebSocket.OPEN) {
+    if (!isSocketOpen()) {
         return;
     }
     state.socket.send(